@@ -0,0 +1,269 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"crypto/md5"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/open-ch/ja3"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// versionNames maps the TLS versions to their names
+var versionNames = map[uint16]string{
+	0x0300: "SSL 3.0",
+	0x0301: "TLS 1.0",
+	0x0302: "TLS 1.1",
+	0x0303: "TLS 1.2",
+	0x0304: "TLS 1.3",
+}
+
+// cipherSuiteNames maps the cipher suites not implemented by crypto/tls to their IANA names
+var cipherSuiteNames = map[uint16]string{
+	0x0033: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA",
+	0x0039: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA",
+	0x0067: "TLS_DHE_RSA_WITH_AES_128_CBC_SHA256",
+	0x006b: "TLS_DHE_RSA_WITH_AES_256_CBC_SHA256",
+	0x009e: "TLS_DHE_RSA_WITH_AES_128_GCM_SHA256",
+	0x009f: "TLS_DHE_RSA_WITH_AES_256_GCM_SHA384",
+	0x00ff: "TLS_EMPTY_RENEGOTIATION_INFO_SCSV",
+	0x1304: "TLS_AES_128_CCM_SHA256",
+	0x1305: "TLS_AES_128_CCM_8_SHA256",
+	0x5600: "TLS_FALLBACK_SCSV",
+	0xc024: "TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384",
+	0xc028: "TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384",
+}
+
+// extensionNames maps the TLS extension types to their IANA names
+var extensionNames = map[uint16]string{
+	0:     "server_name",
+	1:     "max_fragment_length",
+	2:     "client_certificate_url",
+	3:     "trusted_ca_keys",
+	4:     "truncated_hmac",
+	5:     "status_request",
+	6:     "user_mapping",
+	7:     "client_authz",
+	8:     "server_authz",
+	9:     "cert_type",
+	10:    "supported_groups",
+	11:    "ec_point_formats",
+	12:    "srp",
+	13:    "signature_algorithms",
+	14:    "use_srtp",
+	15:    "heartbeat",
+	16:    "application_layer_protocol_negotiation",
+	17:    "status_request_v2",
+	18:    "signed_certificate_timestamp",
+	19:    "client_certificate_type",
+	20:    "server_certificate_type",
+	21:    "padding",
+	22:    "encrypt_then_mac",
+	23:    "extended_master_secret",
+	24:    "token_binding",
+	25:    "cached_info",
+	27:    "compress_certificate",
+	28:    "record_size_limit",
+	34:    "delegated_credentials",
+	35:    "session_ticket",
+	41:    "pre_shared_key",
+	42:    "early_data",
+	43:    "supported_versions",
+	44:    "cookie",
+	45:    "psk_key_exchange_modes",
+	47:    "certificate_authorities",
+	48:    "oid_filters",
+	49:    "post_handshake_auth",
+	50:    "signature_algorithms_cert",
+	51:    "key_share",
+	57:    "quic_transport_parameters",
+	13172: "next_protocol_negotiation",
+	17513: "application_settings",
+	65037: "encrypted_client_hello",
+	65281: "renegotiation_info",
+}
+
+// curveNames maps the supported groups to their IANA names
+var curveNames = map[uint16]string{
+	1:    "sect163k1",
+	2:    "sect163r1",
+	3:    "sect163r2",
+	4:    "sect193r1",
+	5:    "sect193r2",
+	6:    "sect233k1",
+	7:    "sect233r1",
+	8:    "sect239k1",
+	9:    "sect283k1",
+	10:   "sect283r1",
+	11:   "sect409k1",
+	12:   "sect409r1",
+	13:   "sect571k1",
+	14:   "sect571r1",
+	15:   "secp160k1",
+	16:   "secp160r1",
+	17:   "secp160r2",
+	18:   "secp192k1",
+	19:   "secp192r1",
+	20:   "secp224k1",
+	21:   "secp224r1",
+	22:   "secp256k1",
+	23:   "secp256r1",
+	24:   "secp384r1",
+	25:   "secp521r1",
+	26:   "brainpoolP256r1",
+	27:   "brainpoolP384r1",
+	28:   "brainpoolP512r1",
+	29:   "x25519",
+	30:   "x448",
+	256:  "ffdhe2048",
+	257:  "ffdhe3072",
+	258:  "ffdhe4096",
+	259:  "ffdhe6144",
+	260:  "ffdhe8192",
+	4588: "X25519MLKEM768",
+}
+
+// pointFormatNames maps the elliptic curve point formats to their IANA names
+var pointFormatNames = map[uint16]string{
+	0: "uncompressed",
+	1: "ansiX962_compressed_prime",
+	2: "ansiX962_compressed_char2",
+}
+
+// errInvalidJA3String is returned if a JA3 string does not consist of five comma separated fields of decimal values
+var errInvalidJA3String = errors.New("invalid JA3 string")
+
+// explainMain implements the explain command
+func explainMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("explain", "ja3-string|hex-client-hello", stderr)
+	if code := parseFlags(flags, args, 1, 1); code >= 0 {
+		return code
+	}
+
+	if err := explain(strings.TrimSpace(flags.Arg(0)), stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// explain writes the breakdown of a JA3 string or a hex encoded Client Hello segment to writer
+func explain(input string, writer io.Writer) error {
+	var j *ja3.JA3
+	var anomalies []*ja3.ParseError
+	ja3String := input
+	if !strings.Contains(input, ",") {
+		segment, err := hex.DecodeString(strings.Join(strings.Fields(input), ""))
+		if err != nil {
+			return fmt.Errorf("neither a JA3 string nor a hex encoded Client Hello: %v", err)
+		}
+		j, anomalies, err = ja3.ComputeJA3FromSegmentLenient(segment)
+		if err != nil {
+			return err
+		}
+		ja3String = j.GetJA3String()
+	}
+
+	fields := strings.Split(ja3String, ",")
+	if len(fields) != 5 {
+		return errInvalidJA3String
+	}
+	version, err := parseJA3Values(fields[0])
+	if err != nil || len(version) != 1 {
+		return errInvalidJA3String
+	}
+	var values [4][]uint16
+	for i := range values {
+		if values[i], err = parseJA3Values(fields[i+1]); err != nil {
+			return err
+		}
+	}
+
+	fmt.Fprintf(writer, "JA3:        %v\n", ja3String)
+	fmt.Fprintf(writer, "JA3 digest: %v\n", ja3.Digest(md5.Sum([]byte(ja3String))))
+	if j != nil && j.GetSNI() != "" {
+		fmt.Fprintf(writer, "SNI:        %v\n", j.GetSNI())
+	}
+	fmt.Fprintf(writer, "\nVersion:\n  %-5v %v\n", version[0], lookupName(versionNames, version[0]))
+	writeValues(writer, "Cipher suites", values[0], cipherSuiteName)
+	writeValues(writer, "Extensions", values[1], func(v uint16) string { return lookupName(extensionNames, v) })
+	writeValues(writer, "Elliptic curves", values[2], func(v uint16) string { return lookupName(curveNames, v) })
+	writeValues(writer, "Elliptic curve point formats", values[3], func(v uint16) string { return lookupName(pointFormatNames, v) })
+
+	if j != nil {
+		if codes := j.Lint(); len(codes) > 0 {
+			fmt.Fprintf(writer, "\nLint:\n")
+			for _, code := range codes {
+				fmt.Fprintf(writer, "  %v\n", code)
+			}
+		}
+		if len(anomalies) > 0 {
+			fmt.Fprintf(writer, "\nAnomalies:\n")
+			for _, a := range anomalies {
+				fmt.Fprintf(writer, "  %v at offset %v (%v)\n", a, a.Offset, a.Field)
+			}
+		}
+	}
+	return nil
+}
+
+// parseJA3Values parses a dash separated list of decimal values of a JA3 string field
+func parseJA3Values(field string) ([]uint16, error) {
+	if field == "" {
+		return nil, nil
+	}
+	parts := strings.Split(field, "-")
+	values := make([]uint16, len(parts))
+	for i, p := range parts {
+		v, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, errInvalidJA3String
+		}
+		values[i] = uint16(v)
+	}
+	return values, nil
+}
+
+// writeValues writes the values of a JA3 field with their names to writer
+func writeValues(writer io.Writer, title string, values []uint16, name func(uint16) string) {
+	fmt.Fprintf(writer, "\n%v:\n", title)
+	if len(values) == 0 {
+		fmt.Fprintf(writer, "  none\n")
+	}
+	for _, v := range values {
+		fmt.Fprintf(writer, "  %-5v %v\n", v, name(v))
+	}
+}
+
+// lookupName returns the name of v in names, GREASE for reserved GREASE values or unknown
+func lookupName(names map[uint16]string, v uint16) string {
+	if name, ok := names[v]; ok {
+		return name
+	}
+	if isGREASE(v) {
+		return "GREASE"
+	}
+	return "unknown"
+}
+
+// cipherSuiteName returns the name of the cipher suite, preferring the names of crypto/tls
+func cipherSuiteName(v uint16) string {
+	if name := tls.CipherSuiteName(v); !strings.HasPrefix(name, "0x") {
+		return name
+	}
+	return lookupName(cipherSuiteNames, v)
+}
+
+// isGREASE reports whether v is one of the reserved GREASE values of RFC 8701
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
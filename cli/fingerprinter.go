@@ -0,0 +1,205 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"sort"
+	"strings"
+)
+
+// Fields are the named values a Fingerprinter adds to the output record.
+type Fields map[string]interface{}
+
+// Fingerprinter computes a fingerprint from a Client Hello found by the engine, which provides the flow metadata, the
+// payload and the parsed JA3 object. All fields returned by the enabled fingerprinters for the same Client Hello end
+// up in one output record.
+type Fingerprinter interface {
+	// Name returns the name used to enable the fingerprinter on the command line
+	Name() string
+	// Fingerprint returns the computed fields or nil if the record could not be fingerprinted. The record must not be
+	// referenced after returning.
+	Fingerprint(record engine.Record) Fields
+}
+
+// fingerprinters holds the constructors of all available fingerprinters by name
+var fingerprinters = map[string]func() Fingerprinter{
+	"ja3":     func() Fingerprinter { return &ja3Fingerprinter{} },
+	"lint":    func() Fingerprinter { return &lintFingerprinter{} },
+	"session": func() Fingerprinter { return &sessionFingerprinter{} },
+}
+
+// DefaultFingerprinters lists the fingerprinters enabled by default
+const DefaultFingerprinters = "ja3,lint,session"
+
+// NewFingerprinters returns the fingerprinters for the comma separated list of names.
+func NewFingerprinters(names string) ([]Fingerprinter, error) {
+	var fps []Fingerprinter
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		newFingerprinter, ok := fingerprinters[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown fingerprinter %q (available: %v)", name, strings.Join(FingerprinterNames(), ", "))
+		}
+		fps = append(fps, newFingerprinter())
+	}
+	if len(fps) == 0 {
+		return nil, fmt.Errorf("no fingerprinter enabled")
+	}
+	return fps, nil
+}
+
+// FingerprinterNames returns the sorted names of all available fingerprinters.
+func FingerprinterNames() []string {
+	names := make([]string, 0, len(fingerprinters))
+	for name := range fingerprinters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ja3Fingerprinter adds the JA3 string, digest and SNI of Client Hellos
+type ja3Fingerprinter struct{}
+
+func (f *ja3Fingerprinter) Name() string {
+	return "ja3"
+}
+
+func (f *ja3Fingerprinter) Fingerprint(record engine.Record) Fields {
+	// Use the same convention as in the official Python implementation
+	return Fields{
+		"ja3":        record.JA3.GetJA3String(),
+		"ja3_digest": record.JA3.GetJA3Hash(),
+		"sni":        record.JA3.GetSNI(),
+	}
+}
+
+// lintFingerprinter adds the lint codes of Client Hellos violating the TLS RFCs
+type lintFingerprinter struct{}
+
+func (f *lintFingerprinter) Name() string {
+	return "lint"
+}
+
+func (f *lintFingerprinter) Fingerprint(record engine.Record) Fields {
+	codes := record.JA3.Lint()
+	if len(codes) == 0 {
+		return nil
+	}
+	return Fields{"lint": codes}
+}
+
+// sessionFingerprinter adds the JA3S fingerprint, the negotiated parameters and the outcome of the handshake of
+// records of engines tracking sessions
+type sessionFingerprinter struct{}
+
+func (f *sessionFingerprinter) Name() string {
+	return "session"
+}
+
+func (f *sessionFingerprinter) Fingerprint(record engine.Record) Fields {
+	s := record.Session
+	if s == nil {
+		return nil
+	}
+	fields := Fields{
+		"handshake":     s.Status,
+		"handshake_end": s.End.UnixNano(),
+	}
+	if s.JA3S != nil {
+		fields["ja3s"] = s.JA3S.GetJA3SString()
+		fields["ja3s_digest"] = s.JA3S.GetJA3SHash()
+		fields["tls_version"] = lookupName(versionNames, s.JA3S.GetVersion())
+		fields["cipher_suite"] = cipherSuiteName(s.JA3S.GetCipherSuite())
+		if alpn := s.JA3S.GetALPN(); alpn != "" {
+			fields["alpn"] = alpn
+		}
+	}
+	if s.Alert >= 0 {
+		fields["alert"] = s.Alert
+	}
+	return fields
+}
+
+// fingerprint the record with all fingerprinters and write one record with their combined fields, the Community ID of
+// its flow with the seed and the input file it was read from, if any, to writer, unless none of them could fingerprint
+// the record
+func fingerprint(record engine.Record, input string, fingerprinters []Fingerprinter, seed uint16, writer io.Writer) error {
+	var out Fields
+	for _, fp := range fingerprinters {
+		fields := fp.Fingerprint(record)
+		if fields == nil {
+			continue
+		}
+		if out == nil {
+			out = Fields{
+				"community_id":     record.CommunityID(seed),
+				"destination_ip":   record.DstIP.String(),
+				"destination_port": record.DstPort,
+				"source_ip":        record.SrcIP.String(),
+				"source_port":      record.SrcPort,
+				"timestamp":        record.Timestamp.UnixNano(),
+			}
+			if input != "" {
+				out["file"] = input
+			}
+			if record.Interface != "" {
+				out["interface"] = record.Interface
+			}
+			if len(record.Tunnels) > 0 {
+				out["tunnels"] = tunnelFields(record.Tunnels)
+			}
+		}
+		for name, value := range fields {
+			out[name] = value
+		}
+	}
+	if out == nil {
+		return nil
+	}
+	return writeJSON(out, writer)
+}
+
+// tunnelFields returns the outer tuple, type and ID of every tunnel, outermost first
+func tunnelFields(tunnels []engine.Tunnel) []Fields {
+	out := make([]Fields, 0, len(tunnels))
+	for _, t := range tunnels {
+		fields := Fields{
+			"destination_ip": t.DstIP.String(),
+			"source_ip":      t.SrcIP.String(),
+			"type":           t.Type,
+		}
+		if t.SrcPort != 0 || t.DstPort != 0 {
+			fields["destination_port"] = t.DstPort
+			fields["source_port"] = t.SrcPort
+		}
+		if t.ID != 0 {
+			fields["id"] = t.ID
+		}
+		out = append(out, fields)
+	}
+	return out
+}
+
+// writeJSON to writer
+func writeJSON(record Fields, writer io.Writer) error {
+	js, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	// Write the JSON to the writer
+	_, err = writer.Write(append(js, '\n'))
+	return err
+}
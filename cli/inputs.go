@@ -0,0 +1,264 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// stdinInput is the input reading a capture from stdin
+const stdinInput = "-"
+
+// stdin is read by the stdin input, it is replaced by the tests
+var stdin io.Reader = os.Stdin
+
+// captureExtensions are the extensions of the files read from directories, optionally followed by a
+// compressionExtension
+var (
+	captureExtensions     = []string{".pcap", ".pcapng", ".cap"}
+	compressionExtensions = []string{"", ".gz", ".zst", ".xz"}
+)
+
+// mergeBuffer is the number of records buffered per input while merging multiple inputs
+const mergeBuffer = 256
+
+// maxOpenInputs limits the number of inputs read at the same time, it is lowered by the tests
+var maxOpenInputs = 64
+
+// expandInputs returns the capture files to read for the arguments, which are file paths, glob patterns, directories
+// or stdinInput. Directories are searched recursively for files with a capture extension.
+func expandInputs(args []string) ([]string, error) {
+	var inputs []string
+	readsStdin := false
+	for _, arg := range args {
+		if arg == stdinInput {
+			if readsStdin {
+				return nil, fmt.Errorf("stdin can only be read once")
+			}
+			readsStdin = true
+			inputs = append(inputs, arg)
+			continue
+		}
+
+		paths := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			if paths, err = filepath.Glob(arg); err != nil {
+				return nil, err
+			}
+			if len(paths) == 0 {
+				return nil, fmt.Errorf("no files match %q", arg)
+			}
+		}
+		for _, path := range paths {
+			info, err := os.Stat(path)
+			if err != nil {
+				return nil, err
+			}
+			if !info.IsDir() {
+				inputs = append(inputs, path)
+				continue
+			}
+			// The files of a directory are walked in lexical order
+			err = filepath.WalkDir(path, func(p string, d fs.DirEntry, err error) error {
+				if err == nil && !d.IsDir() && isCaptureFile(p) {
+					inputs = append(inputs, p)
+				}
+				return err
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return inputs, nil
+}
+
+// isCaptureFile reports whether the name of the file has a capture extension
+func isCaptureFile(path string) bool {
+	name := strings.ToLower(filepath.Base(path))
+	for _, capture := range captureExtensions {
+		for _, compression := range compressionExtensions {
+			if strings.HasSuffix(name, capture+compression) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// readInputs runs an engine returned by newRunner on every input and calls handler for every found Client Hello
+// together with its input. Multiple inputs are read concurrently and their records are merged by timestamp, records
+// with the same timestamp keep the order of the inputs. With more than maxOpenInputs inputs, the inputs are only
+// started once the merge reached their first packet, so inputs following each other in time, e.g. rotated captures,
+// are read one after the other. If more than maxOpenInputs inputs overlap in time, e.g. the captures of many sensors,
+// the inputs beyond the limit wait until another input is done, so their records may be passed on out of order. The
+// first error stops all inputs.
+func readInputs(ctx context.Context, newRunner func() runner, inputs []string,
+	handler func(input string, record engine.Record)) error {
+	if len(inputs) == 1 {
+		return readFile(ctx, newRunner(), inputs[0], func(record engine.Record) {
+			handler(inputs[0], record)
+		})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	streams := make([]chan engine.Record, len(inputs))
+	errs := make([]error, len(inputs))
+	open := 0
+	start := func(i int) {
+		open++
+		streams[i] = make(chan engine.Record, mergeBuffer)
+		go func(i int, input string) {
+			defer close(streams[i])
+			errs[i] = readFile(ctx, newRunner(), input, func(record engine.Record) {
+				select {
+				case streams[i] <- record.Clone():
+				case <-ctx.Done():
+				}
+			})
+			if errs[i] != nil {
+				cancel()
+			}
+		}(i, inputs[i])
+	}
+
+	// Inputs which are not started yet wait in the merge with the timestamp of their first packet
+	firsts := make([]*time.Time, len(inputs))
+	for i, input := range inputs {
+		if len(inputs) <= maxOpenInputs || input == stdinInput {
+			continue
+		}
+		first, ok, err := firstTimestamp(input)
+		if err != nil {
+			return err
+		}
+		if ok {
+			firsts[i] = &first
+		}
+	}
+	heads := make([]*engine.Record, len(inputs))
+	next := func(i int) {
+		heads[i] = nil
+		if record, ok := <-streams[i]; ok {
+			heads[i] = &record
+		} else {
+			open--
+		}
+	}
+	for i, input := range inputs {
+		if len(inputs) <= maxOpenInputs || input == stdinInput {
+			start(i)
+			next(i)
+		}
+	}
+
+	// Merge the streams by always passing on the oldest of their next records. waiting are the inputs whose first
+	// packet was reached while the maximum number of inputs was open, in that order.
+	var waiting []int
+	for {
+		for open < maxOpenInputs && len(waiting) > 0 {
+			start(waiting[0])
+			next(waiting[0])
+			waiting = waiting[1:]
+		}
+		oldest := -1
+		var oldestTime time.Time
+		for i := range inputs {
+			var ts time.Time
+			if heads[i] != nil {
+				ts = heads[i].Timestamp
+			} else if firsts[i] != nil {
+				ts = *firsts[i]
+			} else {
+				continue
+			}
+			if oldest < 0 || ts.Before(oldestTime) {
+				oldest, oldestTime = i, ts
+			}
+		}
+		if oldest < 0 {
+			break
+		}
+		if firsts[oldest] != nil {
+			firsts[oldest] = nil
+			waiting = append(waiting, oldest)
+			continue
+		}
+		if ctx.Err() == nil {
+			handler(inputs[oldest], *heads[oldest])
+		}
+		next(oldest)
+	}
+
+	// Report the error which stopped the other inputs
+	var err error
+	for _, e := range errs {
+		if e != nil && (err == nil || errors.Is(err, context.Canceled)) {
+			err = e
+		}
+	}
+	return err
+}
+
+// firstTimestamp returns the timestamp of the first packet of the capture file and whether it has any packets
+func firstTimestamp(path string) (time.Time, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	defer f.Close()
+	r, err := engine.ReadFile(f)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("%v: %w", path, err)
+	}
+	_, ci, err := r.ZeroCopyReadPacketData()
+	if err == io.EOF {
+		return time.Time{}, false, nil
+	} else if err != nil {
+		return time.Time{}, false, fmt.Errorf("%v: %w", path, err)
+	}
+	return ci.Timestamp, true, nil
+}
+
+// readFile runs the engine on the capture file, whose format and compression are detected automatically. Pipelines
+// process the chunks of pcap files in parallel.
+func readFile(ctx context.Context, e runner, path string, handler func(engine.Record)) error {
+	if p, ok := e.(*engine.Pipeline); ok && path != stdinInput {
+		if err := p.RunFile(ctx, path, handler); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		return nil
+	}
+	file := stdin
+	if path != stdinInput {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		file = f
+	}
+	r, err := engine.ReadFile(file)
+	if err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	if err := e.Run(ctx, r, handler); err != nil {
+		return fmt.Errorf("%v: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,191 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"github.com/google/gopacket/pcapgo"
+	"github.com/klauspost/compress/zstd"
+	"github.com/open-ch/ja3/engine"
+	"github.com/ulikunitz/xz"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadCompressed(t *testing.T) {
+	/*
+		Compressed captures in a directory are decompressed transparently and merged by timestamp.
+	*/
+	capture, err := os.ReadFile("testdata/google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	compressors := map[string]func(io.Writer) (io.WriteCloser, error){
+		"google.pcap.gz":  func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"google.pcap.zst": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		"google.pcap.xz":  func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+	}
+	for name, newWriter := range compressors {
+		var compressed bytes.Buffer
+		w, err := newWriter(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write(capture)
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), compressed.Bytes(), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"read", dir}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected: %v but got: %v (%v)\n", exitOK, code, stderr.String())
+	}
+	lines := strings.Split(strings.TrimRight(stdout.String(), "\n"), "\n")
+	expected := []string{
+		record(34577, 1537516825571014000, `"file":"`+filepath.Join(dir, "google.pcap.gz")+`"`),
+		record(34577, 1537516825571014000, `"file":"`+filepath.Join(dir, "google.pcap.xz")+`"`),
+		record(34577, 1537516825571014000, `"file":"`+filepath.Join(dir, "google.pcap.zst")+`"`),
+		record(34579, 1537516825571016000, `"file":"`+filepath.Join(dir, "google.pcap.gz")+`"`),
+		record(34579, 1537516825571016000, `"file":"`+filepath.Join(dir, "google.pcap.xz")+`"`),
+		record(34579, 1537516825571016000, `"file":"`+filepath.Join(dir, "google.pcap.zst")+`"`),
+	}
+	if strings.Join(lines, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected: %v but got: %v\n", expected, lines)
+	}
+}
+
+func TestReadStdin(t *testing.T) {
+	/*
+		A capture piped into stdin is read with the input "-".
+	*/
+	capture, err := os.ReadFile("testdata/google.pcapng")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func(r io.Reader) { stdin = r }(stdin)
+	stdin = bytes.NewReader(capture)
+
+	var stdout, stderr bytes.Buffer
+	if code := run([]string{"read", "-workers=2", "-"}, &stdout, &stderr); code != exitOK {
+		t.Fatalf("Expected: %v but got: %v (%v)\n", exitOK, code, stderr.String())
+	}
+	expected := record(34577, 1537516825571014000, `"file":"-","interface":"eth0"`) + "\n"
+	if stdout.String() != expected {
+		t.Errorf("Expected: %v but got: %v\n", expected, stdout.String())
+	}
+}
+
+// shiftedCapture writes the packets of the capture file moved by shift in time to a file in dir and returns its path
+func shiftedCapture(t *testing.T, dir, name string, shift time.Duration) string {
+	f, err := os.Open("testdata/google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcapgo.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var capture bytes.Buffer
+	w := pcapgo.NewWriterNanos(&capture)
+	if err := w.WriteFileHeader(r.Snaplen(), r.LinkType()); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		data, ci, err := r.ReadPacketData()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		ci.Timestamp = ci.Timestamp.Add(shift)
+		if err := w.WritePacket(ci, data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, capture.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+type openInputsTestContainer struct {
+	shifts     []time.Duration
+	expRecords int
+	expOrdered bool
+}
+
+func TestReadInputsOpenLimit(t *testing.T) {
+	/*
+		Build container with testing data
+
+		With more inputs than can be open at the same time, inputs following each other in time are read one after the
+		other and merged in order. Inputs overlapping in time beyond the limit wait until another input is done, so all
+		records are read, but not necessarily in order.
+	*/
+	defer func(max int) { maxOpenInputs = max }(maxOpenInputs)
+	maxOpenInputs = 2
+
+	var openInputsTestSet = map[string]openInputsTestContainer{
+		"Following each other": {
+			shifts:     []time.Duration{3 * time.Hour, 0, 2 * time.Hour, time.Hour, 4 * time.Hour},
+			expRecords: 10,
+			expOrdered: true,
+		},
+		"Two overlapping": {
+			shifts:     []time.Duration{0, time.Hour, 0, time.Hour},
+			expRecords: 8,
+			expOrdered: true,
+		},
+		"Three overlapping": {
+			shifts:     []time.Duration{0, time.Hour, 0, 0},
+			expRecords: 8,
+		},
+		"All overlapping": {
+			shifts:     []time.Duration{0, 0, 0, 0, 0, 0},
+			expRecords: 12,
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range openInputsTestSet {
+		dir := t.TempDir()
+		var inputs []string
+		for i, shift := range test.shifts {
+			inputs = append(inputs, shiftedCapture(t, dir, string(rune('a'+i))+".pcap", shift))
+		}
+		var records []engine.Record
+		err := readInputs(context.Background(), func() runner { return &engine.Engine{} }, inputs,
+			func(input string, record engine.Record) {
+				records = append(records, record)
+			})
+		if err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+			continue
+		}
+		if len(records) != test.expRecords {
+			t.Errorf("%v: Expected: %v records but got: %v\n", name, test.expRecords, len(records))
+		}
+		for i := 1; test.expOrdered && i < len(records); i++ {
+			if records[i].Timestamp.Before(records[i-1].Timestamp) {
+				t.Errorf("%v: Expected: %v after %v\n", name, records[i].Timestamp, records[i-1].Timestamp)
+			}
+		}
+	}
+}
@@ -7,83 +7,345 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"math"
 	"os"
+	"sort"
+	"strings"
+	"time"
 )
 
+// Exit codes
+const (
+	exitOK    = 0
+	exitError = 1
+	exitUsage = 2
+)
+
+// command is a subcommand of the exporter, which returns the exit code of the program
+type command struct {
+	description string
+	run         func(args []string, stdout, stderr io.Writer) int
+}
+
+// commands holds all subcommands by name
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"read":      {"Read pcap or pcapng files and print the fingerprints of the found Client Hellos", readMain},
+		"live":      {"Capture from an interface and print the fingerprints of the found Client Hellos", liveMain},
+		"lookup":    {"Print the found Client Hellos in pcap or pcapng files matching the given JA3 digests", lookupMain},
+		"explain":   {"Explain the fields of a JA3 string or a hex encoded Client Hello", explainMain},
+		"stats":     {"Print statistics of the Client Hellos per JA3 digest, source and destination in pcap or pcapng files", statsMain},
+		"tlsconfig": {"Print the JA3 digest of a described Go tls.Config", tlsConfigMain},
+		"watch":     {"Watch a directory of rotating capture files and print the fingerprints of the found Client Hellos", watchMain},
+	}
+}
+
 func main() {
-	flag.Usage = func() {
-		fmt.Fprintf(os.Stderr, "Usage of %s:\n\nCreates JA3 digests for TLS client fingerprinting.\n\n", os.Args[0])
-		flag.PrintDefaults()
-		fmt.Fprintf(os.Stderr, "\n\nExample:\n\n[host:]# ./ja3exporter -pcap=\"/path/to/file\"\n{\"destination_ip\":\"172.217.168.67\",\"destination_port\":443,\"ja3\":\"771,49200-49196-49199-49195-49172-49162-49171-49161-159-158-57-51-157-156-53-47-10-255,0-11-10-35-13-5-15-13172,23-25-28-27-24-26-22-14-13-11-12-9-10,0-1-2\",\"ja3_digest\":\"5e647d60a56d199388ae462b75b3cdad\",\"source_ip\":\"213.156.236.180\",\"source_port\":34577,\"sni\":\"www.google.ch\",\"timestamp\":1537516825571014000}\n\n")
-	}
-	pcap := flag.String("pcap", "", "Path to pcap file to be read")
-	pcapng := flag.String("pcapng", "", "Path to pcapng file to be read")
-	device := flag.String("interface", "", "Name of interface to be read (e.g. eth0)")
-	compat := flag.Bool("c", false, "Activates compatibility mode (use this if packet does not consist of a pure ETH/IP/TCP stack)")
-	flag.Parse()
-
-	if *pcap != "" {
-		// Read pcap file
-		f, err := os.Open(*pcap)
-		if err != nil {
-			panic(err)
-		}
-		defer f.Close()
-		r, err := ReadPcapFile(f)
-		if err != nil {
-			panic(err)
-		}
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
 
-		// Compute JA3 digests and output to os.Stdout
-		if *compat {
-			err = ComputeJA3FromReader(r, os.Stdout)
-		} else {
-			err = CompatComputeJA3FromReader(r, os.Stdout)
-		}
-		if err != nil {
-			panic(err)
+// run the subcommand given in args and return the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "-help" || args[0] == "--help" || args[0] == "help" {
+		usage(stderr)
+		if len(args) == 0 {
+			return exitUsage
 		}
-	} else if *pcapng != "" {
-		// Read pcapng file
-		f, err := os.Open(*pcapng)
-		if err != nil {
-			panic(err)
+		return exitOK
+	}
+
+	cmd, ok := commands[args[0]]
+	if !ok {
+		fmt.Fprintf(stderr, "Unknown command %q\n\n", args[0])
+		usage(stderr)
+		return exitUsage
+	}
+	return cmd.run(args[1:], stdout, stderr)
+}
+
+// usage prints the list of subcommands
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage of ja3exporter:\n\nCreates JA3 digests for TLS client fingerprinting.\n\n  ja3exporter <command> [flags] [arguments]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %-10s %v\n", name, commands[name].description)
+	}
+	fmt.Fprintf(w, "\nRun 'ja3exporter <command> -h' for the flags of a command.\n\nExample:\n\n[host:]# ./ja3exporter read /path/to/file\n{\"destination_ip\":\"172.217.168.67\",\"destination_port\":443,\"file\":\"/path/to/file\",\"ja3\":\"771,49200-49196-49199-49195-49172-49162-49171-49161-159-158-57-51-157-156-53-47-10-255,0-11-10-35-13-5-15-13172,23-25-28-27-24-26-22-14-13-11-12-9-10,0-1-2\",\"ja3_digest\":\"5e647d60a56d199388ae462b75b3cdad\",\"sni\":\"www.google.ch\",\"source_ip\":\"213.156.236.180\",\"source_port\":34577,\"timestamp\":1537516825571014000}\n\n")
+}
+
+// newFlagSet returns a flag set for the subcommand, which prints its usage with the description and arguments
+func newFlagSet(name, arguments string, stderr io.Writer) *flag.FlagSet {
+	flags := flag.NewFlagSet(name, flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprintf(stderr, "Usage of ja3exporter %v:\n\n%v.\n\n  ja3exporter %v [flags] %v\n\nFlags:\n", name, commands[name].description, name, arguments)
+		flags.PrintDefaults()
+	}
+	return flags
+}
+
+// parseFlags parses args and returns the exit code for usage errors or -1 if the command can continue. The number of
+// positional arguments has to be in [minArgs, maxArgs], a negative maxArgs allows any number.
+func parseFlags(flags *flag.FlagSet, args []string, minArgs, maxArgs int) int {
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
 		}
-		defer f.Close()
-		r, err := ReadPcapngFile(f)
-		if err != nil {
-			panic(err)
+		return exitUsage
+	}
+	if flags.NArg() < minArgs || (maxArgs >= 0 && flags.NArg() > maxArgs) {
+		flags.Usage()
+		return exitUsage
+	}
+	return -1
+}
+
+// engineFlags are the flags shared by all commands running the engine
+type engineFlags struct {
+	compat         *bool
+	defragMemory   *int
+	defragTimeout  *time.Duration
+	filter         *string
+	fingerprints   *string
+	sessions       *bool
+	sessionTimeout *time.Duration
+	maxSessions    *int
+	seed           *uint
+}
+
+// noFilter is the value of the filter flag disabling the filter
+const noFilter = "none"
+
+// addEngineFlags adds the flags configuring the engine and the fingerprinters to flags, the filter flag defaults to
+// defaultFilter
+func addEngineFlags(flags *flag.FlagSet, defaultFilter string) engineFlags {
+	return engineFlags{
+		compat:         flags.Bool("compat", false, "Activates compatibility mode (use this if packets use protocols not supported by the default mode)"),
+		defragMemory:   flags.Int("defrag-memory", engine.DefaultDefragMemory, "Maximum number of bytes buffered for IP fragment reassembly (negative disables reassembly)"),
+		defragTimeout:  flags.Duration("defrag-timeout", engine.DefaultDefragTimeout, "Time after which incomplete fragmented IP packets are dropped"),
+		filter:         flags.String("filter", defaultFilter, "BPF filter expression in tcpdump syntax selecting the packets to decode, \""+engine.DefaultFilter+"\" for the built-in filter passing TLS handshakes, QUIC, fragments and tunnels or \""+noFilter+"\""),
+		fingerprints:   flags.String("fingerprints", DefaultFingerprinters, "Comma separated list of enabled fingerprinters (available: "+strings.Join(FingerprinterNames(), ", ")+")"),
+		sessions:       flags.Bool("sessions", false, "Write one record per TLS session once its handshake completed or ended, with the Server Hello and the outcome of the handshake"),
+		sessionTimeout: flags.Duration("session-timeout", engine.DefaultSessionTimeout, "Time without packets after which the handshake of a session ends as incomplete"),
+		maxSessions:    flags.Int("max-sessions", engine.DefaultMaxSessions, "Maximum number of sessions tracked at the same time, the sessions without packets for the longest time end as incomplete when it is exceeded"),
+		seed:           flags.Uint("community-id-seed", 0, "Seed of the Community ID flow hashes, has to match the seed of the Zeek or Suricata logs they are joined with"),
+	}
+}
+
+// newEngine returns the engine and the fingerprinters configured by the flags
+func (f engineFlags) newEngine() (*engine.Engine, []Fingerprinter, error) {
+	fps, err := NewFingerprinters(*f.fingerprints)
+	if err != nil {
+		return nil, nil, err
+	}
+	if *f.seed > math.MaxUint16 {
+		return nil, nil, fmt.Errorf("invalid Community ID seed %v", *f.seed)
+	}
+	e := &engine.Engine{
+		Compat:         *f.compat,
+		DefragMemory:   *f.defragMemory,
+		DefragTimeout:  *f.defragTimeout,
+		Sessions:       *f.sessions,
+		SessionTimeout: *f.sessionTimeout,
+		MaxSessions:    *f.maxSessions,
+	}
+	if *f.filter != noFilter {
+		e.Filter = *f.filter
+	}
+	return e, fps, nil
+}
+
+// communityIDSeed returns the seed of the Community ID flow hashes
+func (f engineFlags) communityIDSeed() uint16 {
+	return uint16(*f.seed)
+}
+
+// runner runs on the packets of a reader and calls handler for every found Client Hello, it is either a single engine
+// or a pipeline of engines
+type runner interface {
+	Run(ctx context.Context, reader engine.Reader, handler func(engine.Record)) error
+}
+
+// addWorkersFlag adds the flag setting the number of engines decoding the packets of a file in parallel to flags
+func addWorkersFlag(flags *flag.FlagSet) *int {
+	return flags.Int("workers", 1, "Number of engines decoding the packets of a file in parallel (0 uses all cores)")
+}
+
+// newRunner returns the engine configured by the flags or a pipeline of such engines if workers is not one
+func (f engineFlags) newRunner(workers int) (runner, []Fingerprinter, error) {
+	if workers < 0 {
+		return nil, nil, fmt.Errorf("invalid number of workers %v", workers)
+	}
+	e, fps, err := f.newEngine()
+	if err != nil || workers == 1 {
+		return e, fps, err
+	}
+	p := &engine.Pipeline{
+		Workers: workers,
+		NewEngine: func() *engine.Engine {
+			e, _, _ := f.newEngine()
+			return e
+		},
+	}
+	return p, fps, nil
+}
+
+// writeRecords returns a handler writing the records and their input with the writer. The first write error is stored
+// in errp and cancels the engine.
+func writeRecords(cancel context.CancelFunc, writer recordWriter, errp *error) func(string, engine.Record) {
+	return func(input string, record engine.Record) {
+		if err := writer.write(input, record); err != nil && *errp == nil {
+			*errp = err
+			cancel()
 		}
+	}
+}
+
+// closeWriter closes the writer and stores its error in errp unless an earlier write error is stored there
+func closeWriter(writer recordWriter, errp *error) {
+	if err := writer.Close(); err != nil && *errp == nil {
+		*errp = err
+	}
+}
+
+// readMain implements the read command
+func readMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("read", "file|directory|glob|-...", stderr)
+	ef := addEngineFlags(flags, noFilter)
+	of := addOutputFlags(flags)
+	workers := addWorkersFlag(flags)
+	if code := parseFlags(flags, args, 1, -1); code >= 0 {
+		return code
+	}
+	_, fps, err := ef.newRunner(*workers)
+	if err == nil {
+		err = of.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	newRunner := func() runner {
+		r, _, _ := ef.newRunner(*workers)
+		return r
+	}
+	inputs, err := expandInputs(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	writer, err := of.newWriter(fps, ef.communityIDSeed(), stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var writeErr error
+	err = readInputs(ctx, newRunner, inputs, writeRecords(cancel, writer, &writeErr))
+	closeWriter(writer, &writeErr)
+	if writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// lookupMain implements the lookup command
+func lookupMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("lookup", "file|directory|glob|-...", stderr)
+	ef := addEngineFlags(flags, noFilter)
+	of := addOutputFlags(flags)
+	digests := flags.String("digests", "", "Comma separated list of JA3 digests to look up")
+	list := flags.String("list", "", "Path to a file with one JA3 digest per line to look up")
+	workers := addWorkersFlag(flags)
+	if code := parseFlags(flags, args, 1, -1); code >= 0 {
+		return code
+	}
+	_, fps, err := ef.newRunner(*workers)
+	if err == nil {
+		err = of.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	newRunner := func() runner {
+		r, _, _ := ef.newRunner(*workers)
+		return r
+	}
+	inputs, err := expandInputs(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
 
-		// Compute JA3 digests and output to os.Stdout
-		if *compat {
-			err = ComputeJA3FromReader(r, os.Stdout)
-		} else {
-			err = CompatComputeJA3FromReader(r, os.Stdout)
+	// Collect the digests to look up
+	wanted := make(map[string]bool)
+	for _, d := range strings.Split(*digests, ",") {
+		if d = strings.ToLower(strings.TrimSpace(d)); d != "" {
+			wanted[d] = true
 		}
+	}
+	if *list != "" {
+		f, err := os.Open(*list)
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(stderr, err)
+			return exitError
 		}
-	} else if *device != "" {
-		// Read from interface
-		r, err := ReadFromInterface(*device)
+		hashes, err := readHashList(f)
+		f.Close()
 		if err != nil {
-			panic(err)
+			fmt.Fprintln(stderr, err)
+			return exitError
 		}
-
-		// Compute JA3 digests and output to os.Stdout
-		if *compat {
-			err = ComputeJA3FromReader(r, os.Stdout)
-		} else {
-			err = CompatComputeJA3FromReader(r, os.Stdout)
+		for d := range hashes {
+			wanted[d] = true
 		}
-		if err != nil {
-			panic(err)
+	}
+	if len(wanted) == 0 {
+		fmt.Fprintln(stderr, "no JA3 digests to look up, use -digests or -list")
+		return exitUsage
+	}
+
+	writer, err := of.newWriter(fps, ef.communityIDSeed(), stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var writeErr error
+	write := writeRecords(cancel, writer, &writeErr)
+	err = readInputs(ctx, newRunner, inputs, func(input string, record engine.Record) {
+		if wanted[record.JA3.GetJA3Hash()] {
+			write(input, record)
 		}
-	} else {
-		flag.Usage()
-		os.Exit(1)
+	})
+	closeWriter(writer, &writeErr)
+	if writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
 	}
+	return exitOK
 }
@@ -0,0 +1,309 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+)
+
+const (
+	googleJA3       = "771,49200-49196-49199-49195-49172-49162-49171-49161-159-158-57-51-157-156-53-47-10-255,0-11-10-35-13-5-15-13172,23-25-28-27-24-26-22-14-13-11-12-9-10,0-1-2"
+	googleJA3Digest = "5e647d60a56d199388ae462b75b3cdad"
+	googleRecord    = `{"community_id":"%v","destination_ip":"172.217.168.67","destination_port":443,"ja3":"` + googleJA3 + `","ja3_digest":"` + googleJA3Digest + `","sni":"www.google.ch","source_ip":"213.156.236.180","source_port":%v,"timestamp":%v}`
+)
+
+// googleCommunityIDs are the Community IDs of the flows in the test captures by source port with seed zero
+var googleCommunityIDs = map[int]string{
+	34577: "1:NjmWJBXZWwgWlrLu4VBjJnrBtSE=",
+	34579: "1:bOkm61YSCRGBt5zH77yUSd4+EHw=",
+}
+
+type commandTestContainer struct {
+	args      []string
+	expCode   int
+	expStdout []string
+	expStderr string
+}
+
+// record returns the expected output line of the Client Hello in the test captures with the extra fields inserted
+func record(srcPort int, timestamp int64, extra string) string {
+	r := fmt.Sprintf(googleRecord, googleCommunityIDs[srcPort], srcPort, timestamp)
+	if extra != "" {
+		r = strings.Replace(r, `"ja3":`, extra+`,"ja3":`, 1)
+	}
+	return r
+}
+
+func TestRun(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Run every command end to end against the checked-in captures and check the output and the exit code.
+	*/
+	first := record(34577, 1537516825571014000, `"file":"testdata/google.pcap"`)
+	second := record(34579, 1537516825571016000, `"file":"testdata/google.pcap"`)
+	firstNg := record(34577, 1537516825571014000, `"file":"testdata/google.pcapng","interface":"eth0"`)
+	explained, err := os.ReadFile("testdata/google.explain")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var commandTestSet = []commandTestContainer{
+		{ // No command
+			args:      nil,
+			expCode:   exitUsage,
+			expStderr: "Usage of ja3exporter",
+		},
+		{ // Unknown command
+			args:      []string{"foo"},
+			expCode:   exitUsage,
+			expStderr: `Unknown command "foo"`,
+		},
+		{ // Help
+			args:      []string{"read", "-h"},
+			expCode:   exitOK,
+			expStderr: "Usage of ja3exporter read",
+		},
+		{ // Read pcap
+			args:      []string{"read", "testdata/google.pcap"},
+			expCode:   exitOK,
+			expStdout: []string{first, second},
+		},
+		{ // Read pcap with the default filter
+			args:      []string{"read", "-filter=default", "testdata/google.pcap"},
+			expCode:   exitOK,
+			expStdout: []string{first, second},
+		},
+		{ // Read pcap with a pipeline of engines
+			args:      []string{"read", "-workers=4", "testdata/google.pcap"},
+			expCode:   exitOK,
+			expStdout: []string{first, second},
+		},
+		{ // Read with an invalid number of workers
+			args:      []string{"read", "-workers=-1", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: "invalid number of workers",
+		},
+		{ // Read pcap with sessions, which are still open at the end of the capture
+			args:    []string{"read", "-sessions", "-workers=4", "testdata/google.pcap"},
+			expCode: exitOK,
+			expStdout: []string{
+				record(34577, 1537516825571014000, `"file":"testdata/google.pcap","handshake":"incomplete","handshake_end":1537516825571014000`),
+				record(34579, 1537516825571016000, `"file":"testdata/google.pcap","handshake":"incomplete","handshake_end":1537516825571016000`),
+			},
+		},
+		{ // Read pcap with a Community ID seed
+			args:      []string{"read", "-community-id-seed=1", "testdata/google.pcapng"},
+			expCode:   exitOK,
+			expStdout: []string{strings.Replace(firstNg, googleCommunityIDs[34577], "1:HEzXRad7FOxIOA9F59DfIFHuFLg=", 1)},
+		},
+		{ // Read with an invalid Community ID seed
+			args:      []string{"read", "-community-id-seed=65536", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: "invalid Community ID seed",
+		},
+		{ // Read pcapng with interface names
+			args:      []string{"read", "testdata/google.pcapng"},
+			expCode:   exitOK,
+			expStdout: []string{firstNg},
+		},
+		{ // Read multiple files in compatibility mode, merged by timestamp
+			args:      []string{"read", "-compat", "testdata/google.pcap", "testdata/google.pcap"},
+			expCode:   exitOK,
+			expStdout: []string{first, first, second, second},
+		},
+		{ // Read the captures in a directory
+			args:      []string{"read", "testdata"},
+			expCode:   exitOK,
+			expStdout: []string{first, firstNg, second},
+		},
+		{ // Read the captures matching a glob
+			args:      []string{"read", "-workers=2", "testdata/*.pcap*"},
+			expCode:   exitOK,
+			expStdout: []string{first, firstNg, second},
+		},
+		{ // Read glob without matches
+			args:      []string{"read", "testdata/*.none"},
+			expCode:   exitError,
+			expStderr: "no files match",
+		},
+		{ // Read stdin twice
+			args:      []string{"read", "-", "-"},
+			expCode:   exitError,
+			expStderr: "stdin can only be read once",
+		},
+		{ // Read without files
+			args:      []string{"read"},
+			expCode:   exitUsage,
+			expStderr: "Usage of ja3exporter read",
+		},
+		{ // Read with unknown fingerprinter
+			args:      []string{"read", "-fingerprints=foo", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: "unknown fingerprinter",
+		},
+		{ // Read missing file
+			args:      []string{"read", "testdata/missing.pcap"},
+			expCode:   exitError,
+			expStderr: "no such file or directory",
+		},
+		{ // Read file of unknown format
+			args:      []string{"read", "testdata/unknown.bin"},
+			expCode:   exitError,
+			expStderr: "testdata/unknown.bin: unknown capture file format",
+		},
+		{ // Live without interface
+			args:      []string{"live"},
+			expCode:   exitUsage,
+			expStderr: "Usage of ja3exporter live",
+		},
+		{ // Lookup digest
+			args:      []string{"lookup", "-digests=" + strings.ToUpper(googleJA3Digest), "testdata/google.pcap"},
+			expCode:   exitOK,
+			expStdout: []string{first, second},
+		},
+		{ // Lookup digest list
+			args:      []string{"lookup", "-list=testdata/digests.txt", "testdata/google.pcapng"},
+			expCode:   exitOK,
+			expStdout: []string{firstNg},
+		},
+		{ // Lookup unknown digest
+			args:    []string{"lookup", "-digests=7b871a8d50bdac2c9186af16af86a0f4", "testdata/google.pcap"},
+			expCode: exitOK,
+		},
+		{ // Lookup without digests
+			args:      []string{"lookup", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: "no JA3 digests to look up",
+		},
+		{ // Explain JA3 string
+			args:      []string{"explain", "771,4865-2570,0-43-65281,29,0"},
+			expCode:   exitOK,
+			expStdout: []string{"JA3:        771,4865-2570,0-43-65281,29,0", "JA3 digest: ", "", "Version:", "  771   TLS 1.2", "", "Cipher suites:", "  4865  TLS_AES_128_GCM_SHA256", "  2570  GREASE", "", "Extensions:", "  0     server_name", "  43    supported_versions", "  65281 renegotiation_info", "", "Elliptic curves:", "  29    x25519", "", "Elliptic curve point formats:", "  0     uncompressed"},
+		},
+		{ // Explain hex encoded Client Hello
+			args:      []string{"explain", "16030100c9010000c503035232ebe8e7b5f37a0d71d5eeb8f2e6a4bd94053711aabdc1d4bdd30befc027f0000024c030c02cc02fc02bc014c00ac013c009009f009e00390033009d009c0035002f000a00ff0100007800000012001000000d7777772e676f6f676c652e6368000b000403000102000a001c001a00170019001c001b0018001a0016000e000d000b000c0009000a00230000000d0020001e060106020603050105020503040104020403030103020303020102020203000500050100000000000f00010133740000"},
+			expCode:   exitOK,
+			expStdout: strings.Split(strings.TrimRight(string(explained), "\n"), "\n"),
+		},
+		{ // Explain invalid JA3 string
+			args:      []string{"explain", "771,4865,0"},
+			expCode:   exitError,
+			expStderr: "invalid JA3 string",
+		},
+		{ // Explain invalid hex
+			args:      []string{"explain", "zz"},
+			expCode:   exitError,
+			expStderr: "neither a JA3 string nor a hex encoded Client Hello",
+		},
+		{ // Explain truncated Client Hello
+			args:      []string{"explain", "160301"},
+			expCode:   exitError,
+			expStderr: "length check 1 failed",
+		},
+		{ // Stats
+			args:    []string{"stats", "testdata/google.pcap", "testdata/google.pcapng"},
+			expCode: exitOK,
+			expStdout: []string{
+				"COUNT  JA3 DIGEST                        SOURCES  SNIS  FIRST SEEN                   LAST SEEN                    JA3",
+				"3      " + googleJA3Digest + "  1        1     2018-09-21T08:00:25.571014Z  2018-09-21T08:00:25.571016Z  " + googleJA3,
+				"",
+				"COUNT  SOURCE           JA3 DIGESTS",
+				"3      213.156.236.180  1",
+				"",
+				"COUNT  DESTINATION         JA3 DIGESTS",
+				"3      172.217.168.67:443  1",
+			},
+		},
+		{ // Stats as JSON without top tables
+			args:    []string{"stats", "-format=json", "-top=0", "testdata/google.pcap"},
+			expCode: exitOK,
+			expStdout: []string{`{"digests":[{"ja3_digest":"` + googleJA3Digest + `","ja3":"` + googleJA3 + `","count":2,"sources":1,` +
+				`"snis":1,"first_seen":1537516825571014000,"last_seen":1537516825571016000}],"top_sources":[],"top_destinations":[]}`},
+		},
+		{ // Stats as CSV
+			args:    []string{"stats", "-format=csv", "-top=1", "testdata/google.pcap"},
+			expCode: exitOK,
+			expStdout: []string{
+				"count,ja3_digest,sources,snis,first_seen,last_seen,ja3",
+				"2," + googleJA3Digest + `,1,1,2018-09-21T08:00:25.571014Z,2018-09-21T08:00:25.571016Z,"` + googleJA3 + `"`,
+				"",
+				"count,source,ja3_digests",
+				"2,213.156.236.180,1",
+				"",
+				"count,destination,ja3_digests",
+				"2,172.217.168.67:443,1",
+			},
+		},
+		{ // Stats with unknown format
+			args:      []string{"stats", "-format=xml", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: `unknown format "xml"`,
+		},
+		{ // Read as Zeek log
+			args:    []string{"read", "-format=zeek", "testdata/google.pcap"},
+			expCode: exitOK,
+			expStdout: append(zeekHeader("2018-09-21-08-00-25"),
+				"1537516825.571014\tCi8uDuGgJwE13jvGBQ\t213.156.236.180\t34577\t172.217.168.67\t443\twww.google.ch\t"+googleJA3Digest+"\t-\t"+googleCommunityIDs[34577],
+				"1537516825.571016\tCrMmTd8sun13R6cc6\t213.156.236.180\t34579\t172.217.168.67\t443\twww.google.ch\t"+googleJA3Digest+"\t-\t"+googleCommunityIDs[34579],
+				"#close\t2018-09-21-08-00-25"),
+		},
+		{ // Read with unknown format
+			args:      []string{"read", "-format=xml", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: `unknown format "xml"`,
+		},
+		{ // Read JSON to log directory
+			args:      []string{"read", "-log-dir=testdata", "testdata/google.pcap"},
+			expCode:   exitUsage,
+			expStderr: "-log-dir needs -format=zeek",
+		},
+		{ // TLS config not on the blocklist
+			args:    []string{"tlsconfig", "-blocklist=testdata/digests.txt", "testdata/config.json"},
+			expCode: exitOK,
+		},
+		{ // TLS config with wrong expectation
+			args:      []string{"tlsconfig", "-expect=" + googleJA3Digest, "testdata/config.json"},
+			expCode:   exitError,
+			expStderr: "does not match the expected digest " + googleJA3Digest,
+		},
+		{ // TLS config with wrong JA4 expectation
+			args:      []string{"tlsconfig", "-expect=t13d1516h2_8daaf6152771_e5627efa2ab1", "testdata/config.json"},
+			expCode:   exitError,
+			expStderr: "does not match the expected fingerprint t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+	}
+
+	// Run through all test cases
+	for _, test := range commandTestSet {
+		var stdout, stderr bytes.Buffer
+		code := run(test.args, &stdout, &stderr)
+		if code != test.expCode {
+			t.Errorf("%v: Expected: %v but got: %v (%v)\n", test.args, test.expCode, code, stderr.String())
+		}
+		if test.expStdout != nil {
+			lines := strings.Split(strings.TrimRight(stdout.String(), "\n"), "\n")
+			if len(lines) != len(test.expStdout) {
+				t.Errorf("%v: Expected: %v but got: %v\n", test.args, test.expStdout, lines)
+				continue
+			}
+			for i, line := range lines {
+				// The digest of explained JA3 strings is only checked for its presence
+				if line != test.expStdout[i] && !(strings.HasPrefix(test.expStdout[i], "JA3 digest: ") && strings.HasPrefix(line, test.expStdout[i])) {
+					t.Errorf("%v: Expected: %q but got: %q\n", test.args, test.expStdout[i], line)
+				}
+			}
+		}
+		if !strings.Contains(stderr.String(), test.expStderr) {
+			t.Errorf("%v: Expected: %v but got: %v\n", test.args, test.expStderr, stderr.String())
+		}
+	}
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// liveMain implements the live command
+func liveMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("live", "interface", stderr)
+	ef := addEngineFlags(flags, engine.DefaultFilter)
+	of := addOutputFlags(flags)
+	afPacket := flags.Bool("afpacket", false, "Capture with Linux AF_PACKET sockets instead of libpcap (needed in binaries built without cgo)")
+	fanout := flags.Int("fanout", 1, "Number of AF_PACKET sockets and engines the packets are distributed across by flow, needs -afpacket")
+	ringSize := flags.Int("ring-size", engine.DefaultRingSize, "Size in bytes of the ring buffer of every AF_PACKET socket")
+	statsInterval := flags.Duration("stats-interval", time.Minute, "Interval of the capture statistics logged to stderr, 0 only logs them when the capture stops")
+	metricsAddr := flags.String("metrics", "", "Address to serve Prometheus metrics on at /metrics, e.g. \":9101\"")
+	maxLabelValues := flags.Int("metrics-max-labels", defaultMaxLabelValues, "Number of distinct JA3 hashes and SNIs exported as metrics, the others are counted as \""+otherLabel+"\"")
+	if code := parseFlags(flags, args, 1, 1); code >= 0 {
+		return code
+	}
+	if *fanout > 1 && !*afPacket {
+		fmt.Fprintln(stderr, "-fanout needs -afpacket")
+		return exitUsage
+	}
+	_, _, err := ef.newEngine()
+	if err == nil {
+		err = of.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	// Open the capture
+	var readers []engine.Reader
+	if *afPacket {
+		readers, err = engine.ReadFromAFPacket(flags.Arg(0), engine.AFPacketOptions{Fanout: *fanout, RingSize: *ringSize})
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	} else {
+		r, err := engine.ReadFromInterface(flags.Arg(0))
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		readers = []engine.Reader{r}
+	}
+	defer func() {
+		for _, r := range readers {
+			if c, ok := r.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	engines := make([]*engine.Engine, len(readers))
+	fps := make([][]Fingerprinter, len(readers))
+	for i := range readers {
+		engines[i], fps[i], _ = ef.newEngine()
+	}
+	errs := make([]error, len(readers))
+
+	// Every engine writes JSON with its own fingerprinters, while all of them share the Zeek log
+	out := &syncWriter{w: stdout}
+	writers := make([]recordWriter, len(readers))
+	for i := range readers {
+		if i > 0 && *of.format == formatZeek {
+			writers[i] = writers[0]
+			continue
+		}
+		if writers[i], err = of.newWriter(fps[i], ef.communityIDSeed(), out); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+
+	// Serve the metrics until the capture stops
+	var m *metrics
+	served := make(chan error, 1)
+	if *metricsAddr != "" {
+		listener, err := net.Listen("tcp", *metricsAddr)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		m = newMetrics(readers, engines, *maxLabelValues)
+		go func() {
+			err := m.serve(ctx, listener)
+			if err != nil {
+				cancel()
+			}
+			served <- err
+		}()
+	}
+
+	// Run one engine per reader until SIGINT or SIGTERM, the first error stops all of them. The engines finish the
+	// packet at hand, so every record found is written completely before the exporter exits.
+	var wg sync.WaitGroup
+	for i, r := range readers {
+		wg.Add(1)
+		go func(i int, r engine.Reader) {
+			defer wg.Done()
+			var writeErr error
+			write := writeRecords(cancel, writers[i], &writeErr)
+			err := engines[i].Run(ctx, r, func(record engine.Record) {
+				write("", record)
+				if m != nil {
+					m.observe(record)
+				}
+			})
+			if writeErr != nil {
+				err = writeErr
+			}
+			if err != nil && err != context.Canceled {
+				errs[i] = err
+				cancel()
+			}
+		}(i, r)
+	}
+
+	// Log the statistics periodically while the engines are running
+	logger := slog.New(slog.NewTextHandler(stderr, nil))
+	stopped := make(chan struct{})
+	var statsDone sync.WaitGroup
+	if *statsInterval > 0 {
+		statsDone.Add(1)
+		go func() {
+			defer statsDone.Done()
+			ticker := time.NewTicker(*statsInterval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					logCaptureStats(logger, "capture statistics", readers, engines)
+				case <-stopped:
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	for _, w := range writers {
+		if err := w.Close(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	close(stopped)
+	statsDone.Wait()
+	if m != nil {
+		cancel()
+		errs = append(errs, <-served)
+	}
+
+	logCaptureStats(logger, "capture stopped", readers, engines)
+	for _, err := range errs {
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+	}
+	return exitOK
+}
+
+// logCaptureStats logs the number of packets received and dropped by the kernel and the interface over all readers,
+// if they report them, and the number of decoded packets, Client Hellos and parse failures over all engines
+func logCaptureStats(logger *slog.Logger, msg string, readers []engine.Reader, engines []*engine.Engine) {
+	var attrs []any
+	if capture, ok := captureStats(readers); ok {
+		attrs = append(attrs, "packets_received", capture.Packets, "packets_dropped", capture.Drops,
+			"packets_dropped_by_interface", capture.InterfaceDrops)
+	}
+	decode := decodeStats(engines)
+	attrs = append(attrs, "packets_decoded", decode.Packets, "client_hellos", decode.ClientHellos,
+		"parse_failures", decode.ParseFailures)
+	logger.Info(msg, attrs...)
+}
+
+// captureStats returns the capture statistics summed over all readers and whether all of them reported them
+func captureStats(readers []engine.Reader) (engine.CaptureStats, bool) {
+	var total engine.CaptureStats
+	for _, r := range readers {
+		sr, ok := r.(engine.StatsReader)
+		if !ok {
+			return total, false
+		}
+		stats, err := sr.Stats()
+		if err != nil {
+			return total, false
+		}
+		total.Packets += stats.Packets
+		total.Drops += stats.Drops
+		total.InterfaceDrops += stats.InterfaceDrops
+	}
+	return total, true
+}
+
+// decodeStats returns the decode statistics summed over all engines
+func decodeStats(engines []*engine.Engine) engine.DecodeStats {
+	var total engine.DecodeStats
+	for _, e := range engines {
+		stats := e.DecodeStats()
+		total.Packets += stats.Packets
+		total.ClientHellos += stats.ClientHellos
+		total.ParseFailures += stats.ParseFailures
+		total.ParseFailuresByKind.UnsupportedVersion += stats.ParseFailuresByKind.UnsupportedVersion
+		total.ParseFailuresByKind.Truncated += stats.ParseFailuresByKind.Truncated
+		total.ParseFailuresByKind.Malformed += stats.ParseFailuresByKind.Malformed
+		total.ParseFailuresByKind.UnsupportedSNIType += stats.ParseFailuresByKind.UnsupportedSNIType
+	}
+	return total
+}
+
+// syncWriter serializes the writes of engines running concurrently
+type syncWriter struct {
+	lock sync.Mutex
+	w    io.Writer
+}
+
+func (w *syncWriter) Write(p []byte) (int, error) {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	return w.w.Write(p)
+}
@@ -0,0 +1,80 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"github.com/open-ch/ja3/engine"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// statsReader reports fixed capture statistics for the packets of a capture file
+type statsReader struct {
+	engine.Reader
+}
+
+func (statsReader) Stats() (engine.CaptureStats, error) {
+	return engine.CaptureStats{Packets: 10, Drops: 2, InterfaceDrops: 1}, nil
+}
+
+func TestLogCaptureStats(t *testing.T) {
+	/*
+		Build container with testing data
+	*/
+	type logCaptureStatsTestContainer struct {
+		reportsStats bool
+		expLine      string
+	}
+
+	var logCaptureStatsTestSet = map[string]logCaptureStatsTestContainer{
+		"Capture statistics": {
+			reportsStats: true,
+			expLine: "level=INFO msg=\"capture stopped\" packets_received=10 packets_dropped=2 " +
+				"packets_dropped_by_interface=1 packets_decoded=3 client_hellos=2 parse_failures=0\n",
+		},
+		"No capture statistics": {
+			reportsStats: false,
+			expLine:      "level=INFO msg=\"capture stopped\" packets_decoded=3 client_hellos=2 parse_failures=0\n",
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range logCaptureStatsTestSet {
+		f, err := os.Open("testdata/google.pcap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		r, err := engine.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.reportsStats {
+			r = statsReader{r}
+		}
+		e := &engine.Engine{}
+		if err := e.Run(context.Background(), r, func(engine.Record) {}); err != nil {
+			t.Fatal(err)
+		}
+
+		var buf bytes.Buffer
+		logger := slog.New(slog.NewTextHandler(&buf, nil))
+		logCaptureStats(logger, "capture stopped", []engine.Reader{r}, []*engine.Engine{e})
+		// Cut off the time of the line
+		line := buf.String()
+		if i := strings.Index(line, "level="); i >= 0 {
+			line = line[i:]
+		}
+		if line != test.expLine {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expLine, line)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"github.com/open-ch/ja3/engine"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// otherLabel is the label value the values beyond the cardinality limit of a label are counted under
+const otherLabel = "other"
+
+// defaultMaxLabelValues is the default number of distinct JA3 hashes and SNIs exported
+const defaultMaxLabelValues = 1000
+
+// metrics holds the Prometheus metrics of a live capture. The counters of the Client Hellos are updated by observe,
+// the counters of the readers and engines are read when the metrics are scraped.
+type metrics struct {
+	registry  *prometheus.Registry
+	byHash    *prometheus.CounterVec
+	bySNI     *prometheus.CounterVec
+	byVersion *prometheus.CounterVec
+	latency   prometheus.Histogram
+	hashes    *labelLimiter
+	snis      *labelLimiter
+}
+
+// newMetrics returns the metrics of the readers and engines of a live capture. At most maxLabelValues distinct JA3
+// hashes and SNIs are exported, the others are counted as otherLabel.
+func newMetrics(readers []engine.Reader, engines []*engine.Engine, maxLabelValues int) *metrics {
+	m := &metrics{
+		registry: prometheus.NewRegistry(),
+		byHash: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ja3exporter_client_hellos_total",
+			Help: "Number of Client Hellos by JA3 hash.",
+		}, []string{"ja3"}),
+		bySNI: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ja3exporter_client_hellos_by_sni_total",
+			Help: "Number of Client Hellos by SNI.",
+		}, []string{"sni"}),
+		byVersion: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "ja3exporter_client_hellos_by_version_total",
+			Help: "Number of Client Hellos by the TLS version of the Client Hello.",
+		}, []string{"version"}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "ja3exporter_processing_latency_seconds",
+			Help:    "Time from capturing the packet of a Client Hello until its record was written.",
+			Buckets: prometheus.ExponentialBuckets(0.0001, 4, 10),
+		}),
+		hashes: newLabelLimiter(maxLabelValues),
+		snis:   newLabelLimiter(maxLabelValues),
+	}
+	m.registry.MustRegister(m.byHash, m.bySNI, m.byVersion, m.latency, &engineCollector{readers, engines},
+		collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	return m
+}
+
+// observe counts the Client Hello of the record, which was written now
+func (m *metrics) observe(record engine.Record) {
+	m.byHash.WithLabelValues(m.hashes.value(record.JA3.GetJA3Hash())).Inc()
+	m.bySNI.WithLabelValues(m.snis.value(record.JA3.GetSNI())).Inc()
+	m.byVersion.WithLabelValues(versionLabel(record.JA3.GetJA3String())).Inc()
+	m.latency.Observe(time.Since(record.Timestamp).Seconds())
+}
+
+// versionLabel returns the name of the version in the first field of the JA3 string
+func versionLabel(ja3String string) string {
+	field, _, _ := strings.Cut(ja3String, ",")
+	version, err := strconv.ParseUint(field, 10, 16)
+	if err != nil {
+		return "unknown"
+	}
+	return lookupName(versionNames, uint16(version))
+}
+
+// serve the metrics at /metrics on the listener until the context is done
+func (m *metrics) serve(ctx context.Context, listener net.Listener) error {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+	if err := server.Serve(listener); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// labelLimiter limits the number of distinct values of a label, which keeps a flood of random fingerprints or SNIs
+// from exhausting the memory of the exporter and the monitoring system
+type labelLimiter struct {
+	lock   sync.Mutex
+	max    int
+	values map[string]struct{}
+}
+
+// newLabelLimiter returns a limiter passing max distinct values
+func newLabelLimiter(max int) *labelLimiter {
+	return &labelLimiter{max: max, values: make(map[string]struct{})}
+}
+
+// value returns the value if it was seen before or the limit is not reached yet, otherwise otherLabel
+func (l *labelLimiter) value(v string) string {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if _, ok := l.values[v]; ok {
+		return v
+	}
+	if len(l.values) >= l.max {
+		return otherLabel
+	}
+	l.values[v] = struct{}{}
+	return v
+}
+
+// Descriptions of the metrics collected from the readers and engines
+var (
+	packetsReceivedDesc = prometheus.NewDesc("ja3exporter_packets_received_total",
+		"Number of packets received by the capture.", nil, nil)
+	packetsDroppedDesc = prometheus.NewDesc("ja3exporter_packets_dropped_total",
+		"Number of packets dropped by the kernel or the network interface before they were read.", []string{"by"}, nil)
+	packetsDecodedDesc = prometheus.NewDesc("ja3exporter_packets_decoded_total",
+		"Number of packets passed to the engines.", nil, nil)
+	parseFailuresDesc = prometheus.NewDesc("ja3exporter_parse_failures_total",
+		"Number of TCP segments starting with a TLS handshake record which could not be parsed as a Client Hello.",
+		[]string{"kind"}, nil)
+)
+
+// engineCollector collects the capture statistics of the readers and the decode statistics of the engines
+type engineCollector struct {
+	readers []engine.Reader
+	engines []*engine.Engine
+}
+
+// Describe sends the descriptions of the collected metrics
+func (c *engineCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- packetsReceivedDesc
+	ch <- packetsDroppedDesc
+	ch <- packetsDecodedDesc
+	ch <- parseFailuresDesc
+}
+
+// Collect sends the current values of the metrics, the capture statistics only if all readers report them
+func (c *engineCollector) Collect(ch chan<- prometheus.Metric) {
+	if capture, ok := captureStats(c.readers); ok {
+		ch <- prometheus.MustNewConstMetric(packetsReceivedDesc, prometheus.CounterValue, float64(capture.Packets))
+		ch <- prometheus.MustNewConstMetric(packetsDroppedDesc, prometheus.CounterValue, float64(capture.Drops), "kernel")
+		ch <- prometheus.MustNewConstMetric(packetsDroppedDesc, prometheus.CounterValue, float64(capture.InterfaceDrops),
+			"interface")
+	}
+
+	decode := decodeStats(c.engines)
+	ch <- prometheus.MustNewConstMetric(packetsDecodedDesc, prometheus.CounterValue, float64(decode.Packets))
+	kinds := decode.ParseFailuresByKind
+	for kind, n := range map[string]uint64{
+		"unsupported_version":  kinds.UnsupportedVersion,
+		"truncated":            kinds.Truncated,
+		"malformed":            kinds.Malformed,
+		"unsupported_sni_type": kinds.UnsupportedSNIType,
+	} {
+		ch <- prometheus.MustNewConstMetric(parseFailuresDesc, prometheus.CounterValue, float64(n), kind)
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMetrics(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The metrics of the Client Hellos in google.pcap have to be served, the JA3 hash and SNI only up to the label
+		limit.
+	*/
+	type metricsTestContainer struct {
+		maxLabelValues int
+		expLines       []string
+	}
+
+	var metricsTestSet = map[string]metricsTestContainer{
+		"Labels": {
+			maxLabelValues: 10,
+			expLines: []string{
+				`ja3exporter_client_hellos_total{ja3="5e647d60a56d199388ae462b75b3cdad"} 2`,
+				`ja3exporter_client_hellos_by_sni_total{sni="www.google.ch"} 2`,
+				`ja3exporter_client_hellos_by_version_total{version="TLS 1.2"} 2`,
+				`ja3exporter_packets_received_total 10`,
+				`ja3exporter_packets_dropped_total{by="kernel"} 2`,
+				`ja3exporter_packets_dropped_total{by="interface"} 1`,
+				`ja3exporter_packets_decoded_total 3`,
+				`ja3exporter_parse_failures_total{kind="truncated"} 0`,
+				`ja3exporter_processing_latency_seconds_count 2`,
+			},
+		},
+		"Label limit": {
+			maxLabelValues: 0,
+			expLines: []string{
+				`ja3exporter_client_hellos_total{ja3="other"} 2`,
+				`ja3exporter_client_hellos_by_sni_total{sni="other"} 2`,
+				`ja3exporter_client_hellos_by_version_total{version="TLS 1.2"} 2`,
+			},
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range metricsTestSet {
+		f, err := os.Open("testdata/google.pcap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		r, err := engine.ReadFile(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		readers := []engine.Reader{statsReader{r}}
+		e := &engine.Engine{}
+		m := newMetrics(readers, []*engine.Engine{e}, test.maxLabelValues)
+		if err := e.Run(context.Background(), r, m.observe); err != nil {
+			t.Fatal(err)
+		}
+
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		served := make(chan error, 1)
+		go func() {
+			served <- m.serve(ctx, listener)
+		}()
+		resp, err := http.Get("http://" + listener.Addr().String() + "/metrics")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+		}
+
+		lines := strings.Split(string(body), "\n")
+		for _, expLine := range test.expLines {
+			found := false
+			for _, line := range lines {
+				found = found || line == expLine
+			}
+			if !found {
+				t.Errorf("%v: Expected: %v but got: %v\n", name, expLine, string(body))
+			}
+		}
+	}
+}
@@ -0,0 +1,83 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"time"
+)
+
+// formatZeek is the output format writing Zeek ssl.log, the default output format is formatJSON
+const formatZeek = "zeek"
+
+// defaultRotateInterval is the default interval of capture time after which the Zeek logs are rotated
+const defaultRotateInterval = time.Hour
+
+// recordWriter writes the records found by the engines in an output format
+type recordWriter interface {
+	// write the record read from the input file, if any
+	write(input string, record engine.Record) error
+	// Close flushes the output, records must not be written afterwards
+	Close() error
+}
+
+// outputFlags are the flags shared by all commands writing records
+type outputFlags struct {
+	format *string
+	logDir *string
+	rotate *time.Duration
+}
+
+// addOutputFlags adds the flags selecting the output format to flags
+func addOutputFlags(flags *flag.FlagSet) outputFlags {
+	return outputFlags{
+		format: flags.String("format", formatJSON, "Output format: "+formatJSON+" for one JSON object per record or "+formatZeek+" for a Zeek ssl.log with the JA3 and JA3S digests"),
+		logDir: flags.String("log-dir", "", "Directory the Zeek ssl.log is written to instead of stdout, needs -format="+formatZeek),
+		rotate: flags.Duration("rotate", defaultRotateInterval, "Interval of capture time after which the ssl.log in -log-dir is rotated, 0 disables rotation"),
+	}
+}
+
+// validate returns an error if the flags cannot be combined
+func (f outputFlags) validate() error {
+	switch {
+	case *f.format != formatJSON && *f.format != formatZeek:
+		return fmt.Errorf("unknown format %q", *f.format)
+	case *f.logDir != "" && *f.format != formatZeek:
+		return errors.New("-log-dir needs -format=" + formatZeek)
+	case *f.rotate < 0:
+		return fmt.Errorf("invalid rotation interval %v", *f.rotate)
+	}
+	return nil
+}
+
+// newWriter returns the writer of the format configured by the flags. JSON records are written with the fingerprints
+// of the fingerprinters, the Zeek logs always have the same fields. Both include the Community ID with the seed.
+func (f outputFlags) newWriter(fps []Fingerprinter, seed uint16, stdout io.Writer) (recordWriter, error) {
+	if *f.format == formatZeek {
+		return newZeekWriter(*f.logDir, *f.rotate, seed, stdout)
+	}
+	return &jsonWriter{fingerprinters: fps, seed: seed, writer: stdout}, nil
+}
+
+// jsonWriter writes one JSON object per record with the fields of the fingerprinters
+type jsonWriter struct {
+	fingerprinters []Fingerprinter
+	seed           uint16
+	writer         io.Writer
+}
+
+func (w *jsonWriter) write(input string, record engine.Record) error {
+	return fingerprint(record, input, w.fingerprinters, w.seed, w.writer)
+}
+
+func (w *jsonWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,271 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// Output formats of the stats command
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+	formatCSV   = "csv"
+)
+
+// digestStats holds the aggregated statistics of one JA3 digest
+type digestStats struct {
+	Digest    string `json:"ja3_digest"`
+	JA3String string `json:"ja3"`
+	Count     int    `json:"count"`
+	// Sources and SNIs are the numbers of distinct source IPs and SNIs
+	Sources   int   `json:"sources"`
+	SNIs      int   `json:"snis"`
+	FirstSeen int64 `json:"first_seen"`
+	LastSeen  int64 `json:"last_seen"`
+
+	sources map[string]struct{}
+	snis    map[string]struct{}
+}
+
+// endpointStats holds the aggregated statistics of a source IP or a destination IP and port
+type endpointStats struct {
+	Address string `json:"address"`
+	Count   int    `json:"count"`
+	// Digests is the number of distinct JA3 digests
+	Digests int `json:"ja3_digests"`
+
+	digests map[string]struct{}
+}
+
+// captureSummary aggregates the statistics of the Client Hellos of a capture
+type captureSummary struct {
+	digests      map[string]*digestStats
+	sources      map[string]*endpointStats
+	destinations map[string]*endpointStats
+}
+
+// newCaptureSummary returns an empty summary
+func newCaptureSummary() *captureSummary {
+	return &captureSummary{
+		digests:      make(map[string]*digestStats),
+		sources:      make(map[string]*endpointStats),
+		destinations: make(map[string]*endpointStats),
+	}
+}
+
+// add the Client Hello of the record to the summary
+func (s *captureSummary) add(record engine.Record) {
+	digest := record.JA3.GetJA3Hash()
+	source := record.SrcIP.String()
+	timestamp := record.Timestamp.UnixNano()
+
+	d, ok := s.digests[digest]
+	if !ok {
+		d = &digestStats{
+			Digest:    digest,
+			JA3String: record.JA3.GetJA3String(),
+			FirstSeen: timestamp,
+			LastSeen:  timestamp,
+			sources:   make(map[string]struct{}),
+			snis:      make(map[string]struct{}),
+		}
+		s.digests[digest] = d
+	}
+	d.Count++
+	d.sources[source] = struct{}{}
+	if sni := record.JA3.GetSNI(); sni != "" {
+		d.snis[sni] = struct{}{}
+	}
+	// The records of multiple inputs are merged by timestamp, but the records of a single input may be out of order
+	if timestamp < d.FirstSeen {
+		d.FirstSeen = timestamp
+	}
+	if timestamp > d.LastSeen {
+		d.LastSeen = timestamp
+	}
+
+	addEndpoint(s.sources, source, digest)
+	addEndpoint(s.destinations, net.JoinHostPort(record.DstIP.String(), strconv.Itoa(int(record.DstPort))), digest)
+}
+
+// addEndpoint counts the Client Hello with the digest for the address
+func addEndpoint(endpoints map[string]*endpointStats, address, digest string) {
+	e, ok := endpoints[address]
+	if !ok {
+		e = &endpointStats{Address: address, digests: make(map[string]struct{})}
+		endpoints[address] = e
+	}
+	e.Count++
+	e.digests[digest] = struct{}{}
+}
+
+// statsReport holds the statistics in the order they are written
+type statsReport struct {
+	Digests         []*digestStats   `json:"digests"`
+	TopSources      []*endpointStats `json:"top_sources"`
+	TopDestinations []*endpointStats `json:"top_destinations"`
+}
+
+// report returns the digests ordered by descending count and the top sources and destinations with the most Client
+// Hellos
+func (s *captureSummary) report(top int) statsReport {
+	r := statsReport{Digests: make([]*digestStats, 0, len(s.digests))}
+	for _, d := range s.digests {
+		d.Sources, d.SNIs = len(d.sources), len(d.snis)
+		r.Digests = append(r.Digests, d)
+	}
+	sort.Slice(r.Digests, func(a, b int) bool {
+		if r.Digests[a].Count != r.Digests[b].Count {
+			return r.Digests[a].Count > r.Digests[b].Count
+		}
+		return r.Digests[a].Digest < r.Digests[b].Digest
+	})
+	r.TopSources = topEndpoints(s.sources, top)
+	r.TopDestinations = topEndpoints(s.destinations, top)
+	return r
+}
+
+// topEndpoints returns the top endpoints with the most Client Hellos
+func topEndpoints(endpoints map[string]*endpointStats, top int) []*endpointStats {
+	sorted := make([]*endpointStats, 0, len(endpoints))
+	for _, e := range endpoints {
+		e.Digests = len(e.digests)
+		sorted = append(sorted, e)
+	}
+	sort.Slice(sorted, func(a, b int) bool {
+		if sorted[a].Count != sorted[b].Count {
+			return sorted[a].Count > sorted[b].Count
+		}
+		return sorted[a].Address < sorted[b].Address
+	})
+	if len(sorted) > top {
+		sorted = sorted[:top]
+	}
+	return sorted
+}
+
+// statsMain implements the stats command
+func statsMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("stats", "file|directory|glob|-...", stderr)
+	compat := flags.Bool("compat", false, "Activates compatibility mode (use this if packets use protocols not supported by the default mode)")
+	format := flags.String("format", formatTable, "Output format: "+formatTable+", "+formatJSON+" or "+formatCSV)
+	top := flags.Int("top", 10, "Number of rows of the tables of the sources and destinations with the most Client Hellos, 0 omits them")
+	if code := parseFlags(flags, args, 1, -1); code >= 0 {
+		return code
+	}
+	var write func(statsReport, io.Writer) error
+	switch *format {
+	case formatTable:
+		write = writeStatsTable
+	case formatJSON:
+		write = writeStatsJSON
+	case formatCSV:
+		write = writeStatsCSV
+	default:
+		fmt.Fprintf(stderr, "unknown format %q\n", *format)
+		return exitUsage
+	}
+
+	inputs, err := expandInputs(flags.Args())
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	summary := newCaptureSummary()
+	newRunner := func() runner {
+		return &engine.Engine{Compat: *compat}
+	}
+	err = readInputs(context.Background(), newRunner, inputs, func(input string, record engine.Record) {
+		summary.add(record)
+	})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	if err := write(summary.report(*top), stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// formatTime formats the timestamp in nanoseconds for the table and CSV output
+func formatTime(timestamp int64) string {
+	return time.Unix(0, timestamp).UTC().Format(time.RFC3339Nano)
+}
+
+// writeStatsTable writes the report as text tables to writer
+func writeStatsTable(r statsReport, writer io.Writer) error {
+	tw := tabwriter.NewWriter(writer, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "COUNT\tJA3 DIGEST\tSOURCES\tSNIS\tFIRST SEEN\tLAST SEEN\tJA3\n")
+	for _, d := range r.Digests {
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", d.Count, d.Digest, d.Sources, d.SNIs, formatTime(d.FirstSeen),
+			formatTime(d.LastSeen), d.JA3String)
+	}
+	for _, table := range []struct {
+		title     string
+		endpoints []*endpointStats
+	}{{"SOURCE", r.TopSources}, {"DESTINATION", r.TopDestinations}} {
+		if len(table.endpoints) == 0 {
+			continue
+		}
+		fmt.Fprintf(tw, "\nCOUNT\t%v\tJA3 DIGESTS\n", table.title)
+		for _, e := range table.endpoints {
+			fmt.Fprintf(tw, "%v\t%v\t%v\n", e.Count, e.Address, e.Digests)
+		}
+	}
+	return tw.Flush()
+}
+
+// writeStatsJSON writes the report as one JSON object to writer
+func writeStatsJSON(r statsReport, writer io.Writer) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(writer, "%s\n", data)
+	return err
+}
+
+// writeStatsCSV writes the report as CSV tables with a header row each, separated by empty lines, to writer
+func writeStatsCSV(r statsReport, writer io.Writer) error {
+	w := csv.NewWriter(writer)
+	w.Write([]string{"count", "ja3_digest", "sources", "snis", "first_seen", "last_seen", "ja3"})
+	for _, d := range r.Digests {
+		w.Write([]string{strconv.Itoa(d.Count), d.Digest, strconv.Itoa(d.Sources), strconv.Itoa(d.SNIs),
+			formatTime(d.FirstSeen), formatTime(d.LastSeen), d.JA3String})
+	}
+	for _, table := range []struct {
+		title     string
+		endpoints []*endpointStats
+	}{{"source", r.TopSources}, {"destination", r.TopDestinations}} {
+		if len(table.endpoints) == 0 {
+			continue
+		}
+		w.Flush()
+		fmt.Fprintln(writer)
+		w.Write([]string{"count", table.title, "ja3_digests"})
+		for _, e := range table.endpoints {
+			w.Write([]string{strconv.Itoa(e.Count), e.Address, strconv.Itoa(e.Digests)})
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"github.com/open-ch/ja3/engine"
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCaptureSummary(t *testing.T) {
+	/*
+		The Client Hellos are aggregated per digest and endpoint, also if their records are out of order.
+	*/
+	f, err := os.Open("testdata/google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := engine.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []engine.Record
+	err = (&engine.Engine{}).Run(context.Background(), r, func(record engine.Record) {
+		records = append(records, record.Clone())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := records[0].Timestamp
+	summary := newCaptureSummary()
+	for i, srcIP := range []net.IP{{10, 0, 0, 2}, {10, 0, 0, 1}, {10, 0, 0, 2}} {
+		record := records[0]
+		record.SrcIP = srcIP
+		record.Timestamp = start.Add(-time.Duration(i) * time.Second)
+		summary.add(record)
+	}
+	report := summary.report(1)
+
+	if len(report.Digests) != 1 {
+		t.Fatalf("Expected: %v digests but got: %v\n", 1, len(report.Digests))
+	}
+	d := report.Digests[0]
+	expFirst, expLast := start.Add(-2*time.Second).UnixNano(), start.UnixNano()
+	if d.Count != 3 || d.Sources != 2 || d.SNIs != 1 || d.FirstSeen != expFirst || d.LastSeen != expLast {
+		t.Errorf("Expected: %v %v %v %v %v but got: %v %v %v %v %v\n", 3, 2, 1, expFirst, expLast, d.Count, d.Sources,
+			d.SNIs, d.FirstSeen, d.LastSeen)
+	}
+	if len(report.TopSources) != 1 || report.TopSources[0].Address != "10.0.0.2" || report.TopSources[0].Count != 2 {
+		t.Errorf("Expected: %v with %v Client Hellos but got: %+v\n", "10.0.0.2", 2, report.TopSources)
+	}
+}
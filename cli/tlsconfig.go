@@ -0,0 +1,229 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"github.com/open-ch/ja3"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// tlsConfigDescription is the JSON description of the fields of a tls.Config which influence the Client Hello
+type tlsConfigDescription struct {
+	ServerName       string   `json:"server_name"`
+	MinVersion       string   `json:"min_version"`
+	MaxVersion       string   `json:"max_version"`
+	CipherSuites     []string `json:"cipher_suites"`
+	CurvePreferences []string `json:"curve_preferences"`
+	NextProtos       []string `json:"next_protos"`
+}
+
+// tlsVersions maps the version names used in the JSON description to the crypto/tls constants
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// knownCurves lists the curves which can be referenced by name in the JSON description
+var knownCurves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521, tls.X25519, tls.X25519MLKEM768}
+
+// ReadTLSConfig reads the JSON description of a tls.Config from reader. Cipher suites and curves are referenced by
+// their crypto/tls names (e.g. "TLS_AES_128_GCM_SHA256", "X25519") or by their numeric value.
+func ReadTLSConfig(reader io.Reader) (*tls.Config, error) {
+	var desc tlsConfigDescription
+	dec := json.NewDecoder(reader)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&desc); err != nil {
+		return nil, fmt.Errorf("invalid TLS config description: %v", err)
+	}
+
+	config := &tls.Config{
+		ServerName: desc.ServerName,
+		NextProtos: desc.NextProtos,
+	}
+	var err error
+	if config.MinVersion, err = parseTLSVersion(desc.MinVersion); err != nil {
+		return nil, err
+	}
+	if config.MaxVersion, err = parseTLSVersion(desc.MaxVersion); err != nil {
+		return nil, err
+	}
+
+	cipherSuites := make(map[string]uint16)
+	for _, cs := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+		cipherSuites[cs.Name] = cs.ID
+	}
+	for _, name := range desc.CipherSuites {
+		id, ok := cipherSuites[name]
+		if !ok {
+			if id, err = parseUint16(name); err != nil {
+				return nil, fmt.Errorf("unknown cipher suite %q", name)
+			}
+		}
+		config.CipherSuites = append(config.CipherSuites, id)
+	}
+
+	for _, name := range desc.CurvePreferences {
+		curve, err := parseCurve(name)
+		if err != nil {
+			return nil, err
+		}
+		config.CurvePreferences = append(config.CurvePreferences, curve)
+	}
+
+	return config, nil
+}
+
+// parseTLSVersion of the JSON description, an empty version leaves the crypto/tls default in place
+func parseTLSVersion(version string) (uint16, error) {
+	if version == "" {
+		return 0, nil
+	}
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+	return v, nil
+}
+
+// parseCurve by its crypto/tls name or numeric value
+func parseCurve(name string) (tls.CurveID, error) {
+	for _, curve := range knownCurves {
+		if curve.String() == name {
+			return curve, nil
+		}
+	}
+	id, err := parseUint16(name)
+	if err != nil {
+		return 0, fmt.Errorf("unknown curve %q", name)
+	}
+	return tls.CurveID(id), nil
+}
+
+// parseUint16 in decimal or hexadecimal (0x prefixed) representation
+func parseUint16(s string) (uint16, error) {
+	v, err := strconv.ParseUint(s, 0, 16)
+	return uint16(v), err
+}
+
+// readHashList reads one JA3 digest or JA4 fingerprint per line, ignoring empty lines and comments starting with #
+func readHashList(reader io.Reader) (map[string]bool, error) {
+	hashes := make(map[string]bool)
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hashes[strings.ToLower(strings.Fields(line)[0])] = true
+	}
+	return hashes, scanner.Err()
+}
+
+// writeConfigJSON writes the fingerprint of a tls.Config to writer
+func writeConfigJSON(j *ja3.JA3, writer io.Writer) error {
+	js, err := json.Marshal(struct {
+		JA3String string `json:"ja3"`
+		JA3Hash   string `json:"ja3_digest"`
+		JA4       string `json:"ja4"`
+		SNI       string `json:"sni"`
+	}{
+		j.GetJA3String(),
+		j.GetJA3Hash(),
+		j.GetJA4(),
+		j.GetSNI(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write the JSON to the writer
+	writer.Write(js)
+	writer.Write([]byte("\n"))
+	return nil
+}
+
+// tlsConfigMain implements the tlsconfig command, which prints the fingerprint of a described tls.Config and checks it
+// against an expected digest and a blocklist
+func tlsConfigMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("tlsconfig", "config.json", stderr)
+	expect := flags.String("expect", "", "Expected JA3 digest or JA4 fingerprint, fails if the fingerprint differs")
+	blocklist := flags.String("blocklist", "", "Path to a file with one JA3 digest or JA4 fingerprint per line, fails if either fingerprint is listed")
+	if code := parseFlags(flags, args, 1, 1); code >= 0 {
+		return code
+	}
+
+	// Read the config description, "-" reads from stdin
+	var in io.Reader = os.Stdin
+	if path := flags.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		in = f
+	}
+	config, err := ReadTLSConfig(in)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	j, err := ja3.ComputeJA3FromConfig(config)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	if err := writeConfigJSON(j, stdout); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	// Check the fingerprint, JA4 fingerprints are told apart from JA3 digests by their separators
+	if *expect != "" {
+		if strings.Contains(*expect, "_") {
+			if !strings.EqualFold(*expect, j.GetJA4()) {
+				fmt.Fprintf(stderr, "JA4 fingerprint %v does not match the expected fingerprint %v\n", j.GetJA4(), *expect)
+				return exitError
+			}
+		} else if !strings.EqualFold(*expect, j.GetJA3Hash()) {
+			fmt.Fprintf(stderr, "JA3 digest %v does not match the expected digest %v\n", j.GetJA3Hash(), *expect)
+			return exitError
+		}
+	}
+	if *blocklist != "" {
+		f, err := os.Open(*blocklist)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		defer f.Close()
+		hashes, err := readHashList(f)
+		if err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitError
+		}
+		if hashes[j.GetJA3Hash()] {
+			fmt.Fprintf(stderr, "JA3 digest %v is blocklisted\n", j.GetJA3Hash())
+			return exitError
+		}
+		if hashes[strings.ToLower(j.GetJA4())] {
+			fmt.Fprintf(stderr, "JA4 fingerprint %v is blocklisted\n", j.GetJA4())
+			return exitError
+		}
+	}
+	return exitOK
+}
@@ -0,0 +1,306 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"syscall"
+	"time"
+)
+
+// defaultCheckpoint is the name of the checkpoint file in the watched directory
+const defaultCheckpoint = ".ja3exporter-checkpoint"
+
+// watchMain implements the watch command
+func watchMain(args []string, stdout, stderr io.Writer) int {
+	flags := newFlagSet("watch", "directory", stderr)
+	ef := addEngineFlags(flags, noFilter)
+	of := addOutputFlags(flags)
+	workers := addWorkersFlag(flags)
+	checkpoint := flags.String("checkpoint", "", "Path of the file recording the processed files across restarts (default \""+defaultCheckpoint+"\" in the directory)")
+	remove := flags.Bool("delete", false, "Delete the files once they were processed")
+	archive := flags.String("archive", "", "Directory the files are moved into once they were processed")
+	poll := flags.Duration("poll", 10*time.Second, "Interval of the directory scans on platforms without inotify")
+	if code := parseFlags(flags, args, 1, 1); code >= 0 {
+		return code
+	}
+	_, fps, err := ef.newRunner(*workers)
+	if err == nil {
+		err = of.validate()
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	if *remove && *archive != "" {
+		fmt.Fprintln(stderr, "-delete and -archive cannot be combined")
+		return exitUsage
+	}
+
+	writer, err := of.newWriter(fps, ef.communityIDSeed(), stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	var writeErr error
+	write := writeRecords(cancel, writer, &writeErr)
+	w := &watcher{
+		dir:        flags.Arg(0),
+		checkpoint: *checkpoint,
+		archive:    *archive,
+		remove:     *remove,
+		poll:       *poll,
+		errors:     stderr,
+		process: func(ctx context.Context, path string) error {
+			r, _, _ := ef.newRunner(*workers)
+			return readFile(ctx, r, path, func(record engine.Record) {
+				write(path, record)
+			})
+		},
+	}
+	if w.checkpoint == "" {
+		w.checkpoint = filepath.Join(w.dir, defaultCheckpoint)
+	}
+	err = w.run(ctx)
+	closeWriter(writer, &writeErr)
+	if writeErr != nil {
+		err = writeErr
+	}
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitError
+	}
+	return exitOK
+}
+
+// fileState identifies the content of a processed file, so a file written again under the same name is processed again
+type fileState struct {
+	Size     int64 `json:"size"`
+	Modified int64 `json:"modified"`
+}
+
+// watcher processes the completed capture files of a directory. A file is completed once it was closed after writing
+// or moved into the directory, or once a newer file appeared, as with the rotating files of tcpdump -G. The processed
+// files are recorded in the checkpoint file after their records were written, so a restart does not process them
+// again. The records are delivered at least once, not exactly once: a file which was being processed when the watcher
+// stopped or crashed is processed again from the start after a restart, and the records written from it before are
+// written a second time.
+type watcher struct {
+	dir        string
+	checkpoint string
+	archive    string
+	remove     bool
+	poll       time.Duration
+	errors     io.Writer
+	// process the capture file at path, errors only skip the file unless the context is done
+	process func(ctx context.Context, path string) error
+
+	processed map[string]fileState
+	// pending are the names of the files which may still be written
+	pending map[string]bool
+}
+
+// run processes the completed files until the context is done
+func (w *watcher) run(ctx context.Context) error {
+	if err := w.loadCheckpoint(); err != nil {
+		return err
+	}
+	w.pending = make(map[string]bool)
+	err := w.watch(ctx)
+	if ctx.Err() != nil {
+		return nil
+	}
+	return err
+}
+
+// loadCheckpoint reads the processed files from the checkpoint file, which does not exist on the first run
+func (w *watcher) loadCheckpoint() error {
+	w.processed = make(map[string]fileState)
+	data, err := os.ReadFile(w.checkpoint)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, &w.processed); err != nil {
+		return fmt.Errorf("%v: %v", w.checkpoint, err)
+	}
+	return nil
+}
+
+// saveCheckpoint writes the processed files still in the directory to the checkpoint file. The file is replaced
+// atomically, so a crash leaves either the old or the new checkpoint.
+func (w *watcher) saveCheckpoint() error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	present := make(map[string]bool, len(entries))
+	for _, entry := range entries {
+		present[entry.Name()] = true
+	}
+	for name := range w.processed {
+		if !present[name] {
+			delete(w.processed, name)
+		}
+	}
+
+	data, err := json.Marshal(w.processed)
+	if err != nil {
+		return err
+	}
+	tmp := w.checkpoint + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, w.checkpoint)
+}
+
+// scan the directory and process the capture files except the most recently modified one, which is pending as it may
+// still be written
+func (w *watcher) scan(ctx context.Context) error {
+	entries, err := os.ReadDir(w.dir)
+	if err != nil {
+		return err
+	}
+	type file struct {
+		name     string
+		modified time.Time
+	}
+	var files []file
+	for _, entry := range entries {
+		if entry.IsDir() || !isCaptureFile(entry.Name()) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		files = append(files, file{entry.Name(), info.ModTime()})
+	}
+	sort.Slice(files, func(a, b int) bool {
+		if !files[a].modified.Equal(files[b].modified) {
+			return files[a].modified.Before(files[b].modified)
+		}
+		return files[a].name < files[b].name
+	})
+	for i, f := range files {
+		if i == len(files)-1 {
+			w.pending[f.name] = true
+			break
+		}
+		if err := w.complete(ctx, f.name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// created marks the new file as pending and completes the files pending before, as a new file is only created once
+// the previous file was rotated
+func (w *watcher) created(ctx context.Context, name string) error {
+	names := make([]string, 0, len(w.pending))
+	for pending := range w.pending {
+		if pending != name {
+			names = append(names, pending)
+		}
+	}
+	sort.Strings(names)
+	for _, pending := range names {
+		if err := w.complete(ctx, pending); err != nil {
+			return err
+		}
+	}
+	w.pending[name] = true
+	return nil
+}
+
+// complete processes the completed file unless it was processed before and then deletes or archives it. The file is
+// recorded in the checkpoint only after its records were written. It only returns errors which stop the watcher,
+// failures to delete or archive the file are reported and leave it in place.
+func (w *watcher) complete(ctx context.Context, name string) error {
+	delete(w.pending, name)
+	path := filepath.Join(w.dir, name)
+	info, err := os.Stat(path)
+	if err != nil {
+		// The file may have been removed in the meantime
+		return nil
+	}
+	state := fileState{Size: info.Size(), Modified: info.ModTime().UnixNano()}
+	if processed, ok := w.processed[name]; ok && processed == state {
+		return nil
+	}
+
+	err = w.process(ctx, path)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	w.processed[name] = state
+	var moveErr error
+	if err != nil {
+		// Failed files are kept for inspection
+		fmt.Fprintln(w.errors, err)
+	} else if w.remove {
+		moveErr = os.Remove(path)
+	} else if w.archive != "" {
+		moveErr = moveFile(path, filepath.Join(w.archive, name))
+	}
+	if moveErr != nil {
+		fmt.Fprintln(w.errors, moveErr)
+	}
+	return w.saveCheckpoint()
+}
+
+// rename is used to move files, it is replaced by the tests
+var rename = os.Rename
+
+// moveFile moves the file at path to target. Across file systems, where it cannot be renamed, the file is copied
+// with its modification time and then removed.
+func moveFile(path, target string) error {
+	err := rename(path, target)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+	dst, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		os.Remove(target)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(target)
+		return err
+	}
+	if err := os.Chtimes(target, info.ModTime(), info.ModTime()); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
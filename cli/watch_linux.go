@@ -0,0 +1,74 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"golang.org/x/sys/unix"
+	"time"
+	"unsafe"
+)
+
+// inotifyTimeout is the time after which waiting for inotify events returns, so the watcher can react to cancellation
+const inotifyTimeout = 250 * time.Millisecond
+
+// watch the directory with inotify and process the completed files until the context is done
+func (w *watcher) watch(ctx context.Context) error {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC)
+	if err != nil {
+		return fmt.Errorf("initializing inotify: %v", err)
+	}
+	defer unix.Close(fd)
+	_, err = unix.InotifyAddWatch(fd, w.dir, unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO|unix.IN_CREATE)
+	if err != nil {
+		return fmt.Errorf("watching %v: %v", w.dir, err)
+	}
+
+	// Files written before the watch was added are found by scanning the directory
+	if err := w.scan(ctx); err != nil {
+		return err
+	}
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for ctx.Err() == nil {
+		fds := []unix.PollFd{{Fd: int32(fd), Events: unix.POLLIN}}
+		n, err := unix.Poll(fds, int(inotifyTimeout/time.Millisecond))
+		if err == unix.EINTR || (err == nil && n == 0) {
+			continue
+		} else if err != nil {
+			return err
+		}
+		n, err = unix.Read(fd, buf)
+		if err != nil {
+			return err
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			start := offset + unix.SizeofInotifyEvent
+			offset = start + int(event.Len)
+			name := string(bytes.TrimRight(buf[start:offset], "\x00"))
+
+			switch {
+			case event.Mask&unix.IN_Q_OVERFLOW != 0:
+				// Events were lost, so the directory has to be scanned again
+				err = w.scan(ctx)
+			case !isCaptureFile(name) || event.Mask&unix.IN_ISDIR != 0:
+			case event.Mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) != 0:
+				err = w.complete(ctx, name)
+			case event.Mask&unix.IN_CREATE != 0:
+				err = w.created(ctx, name)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
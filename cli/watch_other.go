@@ -0,0 +1,31 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build !linux
+
+package main
+
+import (
+	"context"
+	"time"
+)
+
+// watch the directory by scanning it periodically and process the completed files until the context is done. Without
+// inotify, a file is only completed once a newer file appeared.
+func (w *watcher) watch(ctx context.Context) error {
+	ticker := time.NewTicker(w.poll)
+	defer ticker.Stop()
+	for {
+		if err := w.scan(ctx); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,221 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// testWatcher records the files processed by a watcher running on dir
+type testWatcher struct {
+	watcher
+	lock  sync.Mutex
+	files []string
+	done  chan struct{}
+	stop  context.CancelFunc
+}
+
+// startWatcher runs a watcher on dir in the background
+func startWatcher(t *testing.T, dir string, remove bool) *testWatcher {
+	w := &testWatcher{done: make(chan struct{})}
+	w.watcher = watcher{
+		dir:        dir,
+		checkpoint: filepath.Join(dir, defaultCheckpoint),
+		remove:     remove,
+		poll:       10 * time.Millisecond,
+		errors:     &bytes.Buffer{},
+		process: func(ctx context.Context, path string) error {
+			w.lock.Lock()
+			defer w.lock.Unlock()
+			w.files = append(w.files, filepath.Base(path))
+			return nil
+		},
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	w.stop = cancel
+	go func() {
+		defer close(w.done)
+		if err := w.run(ctx); err != nil {
+			t.Errorf("Expected: %v but got: %v\n", nil, err)
+		}
+	}()
+	return w
+}
+
+// waitFor waits until the watcher processed the files, in any order, and stops it
+func (w *testWatcher) waitFor(t *testing.T, files ...string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		w.lock.Lock()
+		n := len(w.files)
+		w.lock.Unlock()
+		if n >= len(files) || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	// Give the watcher the chance to process too many files
+	time.Sleep(50 * time.Millisecond)
+	w.stop()
+	<-w.done
+
+	sort.Strings(w.files)
+	if len(w.files) != len(files) {
+		t.Fatalf("Expected: %v but got: %v\n", files, w.files)
+	}
+	for i := range files {
+		if w.files[i] != files[i] {
+			t.Errorf("Expected: %v but got: %v\n", files, w.files)
+		}
+	}
+}
+
+// writeCapture writes a file into dir with a modification time the given number of minutes ago. Files written now keep
+// their time, as a running watcher may process and delete them as soon as they are closed.
+func writeCapture(t *testing.T, dir, name string, minutesAgo int) {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte("capture"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if minutesAgo == 0 {
+		return
+	}
+	modified := time.Now().Add(-time.Duration(minutesAgo) * time.Minute)
+	if err := os.Chtimes(path, modified, modified); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWatcher(t *testing.T) {
+	/*
+		Files present at the start are processed except the newest one, which is completed by the next rotation. Files
+		recorded in the checkpoint are not processed again after a restart.
+	*/
+	dir := t.TempDir()
+	writeCapture(t, dir, "a.pcap", 3)
+	writeCapture(t, dir, "b.pcap", 2)
+	writeCapture(t, dir, "notes.txt", 1)
+	w := startWatcher(t, dir, false)
+	w.waitFor(t, "a.pcap")
+
+	w = startWatcher(t, dir, false)
+	time.Sleep(50 * time.Millisecond)
+	writeCapture(t, dir, "c.pcap", 0)
+	w.waitFor(t, "b.pcap", "c.pcap")
+
+	// Nothing is processed again after a restart, unless a file was rewritten
+	writeCapture(t, dir, "a.pcap", 1)
+	w = startWatcher(t, dir, false)
+	w.waitFor(t, "a.pcap")
+}
+
+func TestWatcherDelete(t *testing.T) {
+	/*
+		Processed files are deleted and removed from the checkpoint.
+	*/
+	dir := t.TempDir()
+	writeCapture(t, dir, "a.pcap", 2)
+	writeCapture(t, dir, "b.pcap.gz", 1)
+	w := startWatcher(t, dir, true)
+	time.Sleep(50 * time.Millisecond)
+	writeCapture(t, dir, "c.pcap", 0)
+	w.waitFor(t, "a.pcap", "b.pcap.gz", "c.pcap")
+
+	for _, name := range []string{"a.pcap", "b.pcap.gz", "c.pcap"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); !os.IsNotExist(err) {
+			t.Errorf("Expected: %v but got: %v\n", "deleted file", err)
+		}
+	}
+	checkpoint, err := os.ReadFile(filepath.Join(dir, defaultCheckpoint))
+	if err != nil || string(checkpoint) != "{}" {
+		t.Errorf("Expected: %v but got: %v (%v)\n", "{}", string(checkpoint), err)
+	}
+}
+
+type watcherArchiveTestContainer struct {
+	archive    string
+	renameErr  error
+	expArchive bool
+	expLogged  bool
+}
+
+func TestWatcherArchive(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Processed files are moved into the archive, by copying them to another file system. Files which cannot be
+		archived are kept and the watcher goes on.
+	*/
+	defer func(r func(string, string) error) { rename = r }(rename)
+
+	var watcherArchiveTestSet = map[string]watcherArchiveTestContainer{
+		"Renamed": {
+			archive:    "archive",
+			expArchive: true,
+		},
+		"Other file system": {
+			archive:    "archive",
+			renameErr:  syscall.EXDEV,
+			expArchive: true,
+		},
+		"Missing archive": {
+			archive:   "missing",
+			expLogged: true,
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range watcherArchiveTestSet {
+		dir := t.TempDir()
+		if err := os.Mkdir(filepath.Join(dir, "archive"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		writeCapture(t, dir, "a.pcap", 1)
+		rename = func(old, new string) error {
+			if test.renameErr != nil {
+				return &os.LinkError{Op: "rename", Old: old, New: new, Err: test.renameErr}
+			}
+			return os.Rename(old, new)
+		}
+		var stderr bytes.Buffer
+		w := &watcher{
+			dir:        dir,
+			checkpoint: filepath.Join(dir, defaultCheckpoint),
+			archive:    filepath.Join(dir, test.archive),
+			errors:     &stderr,
+			process:    func(ctx context.Context, path string) error { return nil },
+			processed:  make(map[string]fileState),
+			pending:    make(map[string]bool),
+		}
+		if err := w.complete(context.Background(), "a.pcap"); err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, "archive", "a.pcap"))
+		if archived := err == nil && string(content) == "capture"; archived != test.expArchive {
+			t.Errorf("%v: Expected: %v but got: %v (%v)\n", name, test.expArchive, archived, err)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "a.pcap")); os.IsNotExist(err) != test.expArchive {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expArchive, os.IsNotExist(err))
+		}
+		if logged := stderr.Len() > 0; logged != test.expLogged {
+			t.Errorf("%v: Expected: %v but got: %v (%v)\n", name, test.expLogged, logged, stderr.String())
+		}
+		// Files kept in the directory stay in the checkpoint, so they are not processed again
+		if _, ok := w.processed["a.pcap"]; ok == test.expArchive {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, !test.expArchive, ok)
+		}
+	}
+}
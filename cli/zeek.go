@@ -0,0 +1,280 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"crypto/sha1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/open-ch/ja3/engine"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Layout of the Zeek logs
+const (
+	zeekLogPath    = "ssl"
+	zeekLogName    = zeekLogPath + ".log"
+	zeekTimeFormat = "2006-01-02-15-04-05"
+	zeekUnset      = "-"
+	zeekEmpty      = "(empty)"
+)
+
+// zeekColumns are the fields and types of the logs, which are the fields of the ssl.log of the Zeek JA3 package
+// followed by the Community ID of the flow
+var zeekColumns = [][2]string{
+	{"ts", "time"},
+	{"uid", "string"},
+	{"id.orig_h", "addr"},
+	{"id.orig_p", "port"},
+	{"id.resp_h", "addr"},
+	{"id.resp_p", "port"},
+	{"server_name", "string"},
+	{"ja3", "string"},
+	{"ja3s", "string"},
+	{"community_id", "string"},
+}
+
+// base62Digits are the digits of the Zeek uids
+const base62Digits = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// errWriterClosed is returned when a record is written after the writer was closed
+var errWriterClosed = errors.New("record writer closed")
+
+// zeekWriter writes the records as Zeek ssl.log in TSV format, either to a writer or to ssl.log in a directory. The log
+// in a directory is rotated every interval of capture time, the rotated logs are named after the time they were opened
+// at, as Zeek does. Rotation is triggered by the records, so a log is only rotated once a record of a later interval
+// is written or the writer is closed. A zeekWriter may be used by multiple goroutines at the same time.
+type zeekWriter struct {
+	lock   sync.Mutex
+	dir    string
+	rotate time.Duration
+	seed   uint16
+	out    io.Writer
+	// file is the open log in dir or nil
+	file *os.File
+	// open is the capture time the current log was opened at, end the end of its rotation interval or zero if it is
+	// not rotated, last the time of the last record written to it
+	open, end, last time.Time
+	opened          bool
+	closed          bool
+}
+
+// newZeekWriter returns a writer of ssl.log to dir, rotated every interval unless it is zero, or to out if dir is
+// empty. The Community IDs of the flows are computed with the seed.
+func newZeekWriter(dir string, rotate time.Duration, seed uint16, out io.Writer) (*zeekWriter, error) {
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("%v is no directory", dir)
+		}
+	} else {
+		rotate = 0
+	}
+	return &zeekWriter{dir: dir, rotate: rotate, seed: seed, out: out}, nil
+}
+
+// write the record as one line of the log, rotating the log first if the record belongs to a later interval
+func (w *zeekWriter) write(input string, record engine.Record) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return errWriterClosed
+	}
+
+	ts := record.Timestamp
+	if w.opened && !w.end.IsZero() && !ts.Before(w.end) {
+		if err := w.closeLog(w.end); err != nil {
+			return err
+		}
+		if err := w.openLog(ts.Truncate(w.rotate)); err != nil {
+			return err
+		}
+	} else if !w.opened {
+		if err := w.openLog(ts); err != nil {
+			return err
+		}
+	}
+	if ts.After(w.last) {
+		w.last = ts
+	}
+
+	ja3s := zeekUnset
+	if record.Session != nil && record.Session.JA3S != nil {
+		ja3s = record.Session.JA3S.GetJA3SHash()
+	}
+	sni := zeekUnset
+	if s := record.JA3.GetSNI(); s != "" {
+		sni = zeekEscape(s)
+	}
+	line := strings.Join([]string{
+		zeekTime(ts),
+		zeekUID(record),
+		record.SrcIP.String(),
+		strconv.Itoa(int(record.SrcPort)),
+		record.DstIP.String(),
+		strconv.Itoa(int(record.DstPort)),
+		sni,
+		record.JA3.GetJA3Hash(),
+		ja3s,
+		record.CommunityID(w.seed),
+	}, "\t")
+	_, err := io.WriteString(w.output(), line+"\n")
+	return err
+}
+
+// Close writes the footer of the current log and, if it is rotated, rotates it a last time
+func (w *zeekWriter) Close() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	if !w.opened {
+		return nil
+	}
+	return w.closeLog(w.last)
+}
+
+// output returns the writer of the current log
+func (w *zeekWriter) output() io.Writer {
+	if w.file != nil {
+		return w.file
+	}
+	return w.out
+}
+
+// openLog opens a new log at the capture time and writes its header. A log left over in the directory, e.g. by a
+// killed exporter, is moved aside first.
+func (w *zeekWriter) openLog(open time.Time) error {
+	if w.dir != "" {
+		path := filepath.Join(w.dir, zeekLogName)
+		if info, err := os.Stat(path); err == nil {
+			if err := w.rotateLog(info.ModTime()); err != nil {
+				return err
+			}
+		}
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err != nil {
+			return err
+		}
+		w.file = f
+	}
+	w.open, w.last, w.opened = open, open, true
+	w.end = time.Time{}
+	if w.rotate > 0 {
+		w.end = open.Truncate(w.rotate).Add(w.rotate)
+	}
+
+	fields := make([]string, len(zeekColumns))
+	types := make([]string, len(zeekColumns))
+	for i, c := range zeekColumns {
+		fields[i], types[i] = c[0], c[1]
+	}
+	_, err := fmt.Fprintf(w.output(), "#separator \\x09\n#set_separator\t,\n#empty_field\t%v\n#unset_field\t%v\n#path\t%v\n"+
+		"#open\t%v\n#fields\t%v\n#types\t%v\n", zeekEmpty, zeekUnset, zeekLogPath, open.UTC().Format(zeekTimeFormat),
+		strings.Join(fields, "\t"), strings.Join(types, "\t"))
+	return err
+}
+
+// closeLog writes the footer of the current log with the capture time it is closed at, closes its file and moves it
+// to its rotated name if it is rotated
+func (w *zeekWriter) closeLog(close time.Time) error {
+	w.opened = false
+	_, err := fmt.Fprintf(w.output(), "#close\t%v\n", close.UTC().Format(zeekTimeFormat))
+	if w.file == nil {
+		return err
+	}
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	w.file = nil
+	if err == nil && w.rotate > 0 {
+		err = w.rotateLog(w.open)
+	}
+	return err
+}
+
+// rotateLog moves the log in the directory to the rotated name of the log opened at the time. If a rotated log of the
+// same time exists already, e.g. written by an earlier run over the same capture, a counter is added to the name, so
+// no rotated log is ever overwritten.
+func (w *zeekWriter) rotateLog(open time.Time) error {
+	for n := 0; ; n++ {
+		path := w.rotatedPath(open, n)
+		if _, err := os.Lstat(path); os.IsNotExist(err) {
+			return os.Rename(filepath.Join(w.dir, zeekLogName), path)
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// rotatedPath returns the path of the log opened at the time once it is rotated, with the counter n unless it is zero
+func (w *zeekWriter) rotatedPath(open time.Time, n int) string {
+	name := zeekLogPath + "." + open.UTC().Format(zeekTimeFormat)
+	if n > 0 {
+		name += "-" + strconv.Itoa(n)
+	}
+	return filepath.Join(w.dir, name+".log")
+}
+
+// zeekTime formats the timestamp as seconds since the epoch with microsecond precision
+func zeekTime(ts time.Time) string {
+	ns := ts.UnixNano()
+	return fmt.Sprintf("%d.%06d", ns/int64(time.Second), ns%int64(time.Second)/int64(time.Microsecond))
+}
+
+// zeekUID returns a uid in the format of Zeek for the session of the record. It is derived from the flow and the time
+// of the Client Hello, so the same capture always gets the same uids whatever the seed of the Community IDs.
+func zeekUID(record engine.Record) string {
+	h := sha1.Sum([]byte(record.CommunityID(0) + strconv.FormatInt(record.Timestamp.UnixNano(), 10)))
+	uid := []byte{'C'}
+	uid = appendBase62(uid, binary.BigEndian.Uint64(h[0:8]))
+	return string(appendBase62(uid, uint64(binary.BigEndian.Uint32(h[8:12]))))
+}
+
+// appendBase62 appends the base62 representation of v to b
+func appendBase62(b []byte, v uint64) []byte {
+	var digits [11]byte
+	i := len(digits)
+	for {
+		i--
+		digits[i] = base62Digits[v%62]
+		v /= 62
+		if v == 0 {
+			break
+		}
+	}
+	return append(b, digits[i:]...)
+}
+
+// zeekEscape escapes the separator, non-printable bytes and values which would be mistaken for unset or empty fields
+// as \x followed by the hexadecimal value of the byte, as Zeek does
+func zeekEscape(s string) string {
+	if s == zeekUnset || s == zeekEmpty {
+		return fmt.Sprintf("\\x%02x", s[0]) + s[1:]
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '\\' {
+			fmt.Fprintf(&b, "\\x%02x", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
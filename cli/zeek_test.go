@@ -0,0 +1,199 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package main
+
+import (
+	"context"
+	"github.com/open-ch/ja3/engine"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// zeekHeader returns the expected header lines of a Zeek log opened at the time
+func zeekHeader(open string) []string {
+	return []string{
+		`#separator \x09`,
+		"#set_separator\t,",
+		"#empty_field\t(empty)",
+		"#unset_field\t-",
+		"#path\tssl",
+		"#open\t" + open,
+		"#fields\tts\tuid\tid.orig_h\tid.orig_p\tid.resp_h\tid.resp_p\tserver_name\tja3\tja3s\tcommunity_id",
+		"#types\ttime\tstring\taddr\tport\taddr\tport\tstring\tstring\tstring\tstring",
+	}
+}
+
+type zeekRotationTestContainer struct {
+	expOpen  string
+	expClose string
+	expLines int
+}
+
+func TestZeekWriterRotation(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The log has to be rotated at every hour of capture time a record is written in, named after its opening time.
+		A log left over by an earlier run is moved aside first.
+	*/
+	f, err := os.Open("testdata/google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := engine.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []engine.Record
+	err = (&engine.Engine{}).Run(context.Background(), r, func(record engine.Record) {
+		records = append(records, record.Clone())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	leftover := time.Date(2018, 9, 20, 23, 59, 0, 0, time.UTC)
+	if err := os.WriteFile(filepath.Join(dir, "ssl.log"), []byte("#path\tssl\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(filepath.Join(dir, "ssl.log"), leftover, leftover); err != nil {
+		t.Fatal(err)
+	}
+
+	w, err := newZeekWriter(dir, time.Hour, 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, ts := range []string{"08:59:59", "09:00:00", "09:30:00", "11:05:00"} {
+		record := records[0]
+		record.Timestamp, _ = time.Parse(time.RFC3339, "2018-09-21T"+ts+"Z")
+		if err := w.write("", record); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.write("", records[0]); err != errWriterClosed {
+		t.Errorf("Expected: %v but got: %v\n", errWriterClosed, err)
+	}
+
+	var zeekRotationTestSet = map[string]zeekRotationTestContainer{
+		"ssl.2018-09-21-08-59-59.log": {expOpen: "2018-09-21-08-59-59", expClose: "2018-09-21-09-00-00", expLines: 1},
+		"ssl.2018-09-21-09-00-00.log": {expOpen: "2018-09-21-09-00-00", expClose: "2018-09-21-10-00-00", expLines: 2},
+		"ssl.2018-09-21-11-00-00.log": {expOpen: "2018-09-21-11-00-00", expClose: "2018-09-21-11-05-00", expLines: 1},
+	}
+
+	// Run through all test cases
+	for name, test := range zeekRotationTestSet {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+			continue
+		}
+		lines := strings.Split(strings.TrimRight(string(content), "\n"), "\n")
+		header := zeekHeader(test.expOpen)
+		if len(lines) != len(header)+test.expLines+1 {
+			t.Errorf("%v: Expected: %v lines but got: %v\n", name, len(header)+test.expLines+1, len(lines))
+			continue
+		}
+		for i, line := range header {
+			if lines[i] != line {
+				t.Errorf("%v: Expected: %q but got: %q\n", name, line, lines[i])
+			}
+		}
+		if last := lines[len(lines)-1]; last != "#close\t"+test.expClose {
+			t.Errorf("%v: Expected: %q but got: %q\n", name, "#close\t"+test.expClose, last)
+		}
+	}
+	if content, err := os.ReadFile(filepath.Join(dir, "ssl.2018-09-20-23-59-00.log")); err != nil || string(content) != "#path\tssl\n" {
+		t.Errorf("Expected: %q but got: %q (%v)\n", "#path\tssl\n", content, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "ssl.log")); !os.IsNotExist(err) {
+		t.Errorf("Expected: %v but got: %v\n", "no ssl.log", err)
+	}
+}
+
+func TestZeekWriterKeepsRotatedLogs(t *testing.T) {
+	/*
+		Rotated logs of the same open time, e.g. from an earlier run over the same capture, are kept and the new log
+		gets a counter in its name.
+	*/
+	f, err := os.Open("testdata/google.pcap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := engine.ReadFile(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var records []engine.Record
+	err = (&engine.Engine{}).Run(context.Background(), r, func(record engine.Record) {
+		records = append(records, record.Clone())
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	earlier := filepath.Join(dir, "ssl.2018-09-21-08-00-25.log")
+	if err := os.WriteFile(earlier, []byte("earlier run\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for run := 0; run < 2; run++ {
+		w, err := newZeekWriter(dir, time.Hour, 0, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.write("", records[0]); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if content, err := os.ReadFile(earlier); err != nil || string(content) != "earlier run\n" {
+		t.Errorf("Expected: %q but got: %q (%v)\n", "earlier run\n", content, err)
+	}
+	for _, name := range []string{"ssl.2018-09-21-08-00-25-1.log", "ssl.2018-09-21-08-00-25-2.log"} {
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil || !strings.HasPrefix(string(content), zeekHeader("2018-09-21-08-00-25")[0]) {
+			t.Errorf("%v: Expected: %v but got: %q (%v)\n", name, "a rotated log", content, err)
+		}
+	}
+}
+
+func TestZeekEscape(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Separators, non-printable bytes and the markers of unset and empty fields must not appear in the values.
+	*/
+	var zeekEscapeTestSet = map[string]string{
+		"www.google.ch": "www.google.ch",
+		"a\tb":          `a\x09b`,
+		"a\\b":          `a\x5cb`,
+		"\xffa":         `\xffa`,
+		"-":             `\x2d`,
+		"(empty)":       `\x28empty)`,
+		"-a":            "-a",
+	}
+
+	// Run through all test cases
+	for in, exp := range zeekEscapeTestSet {
+		if out := zeekEscape(in); out != exp {
+			t.Errorf("%q: Expected: %v but got: %v\n", in, exp, out)
+		}
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import "crypto/tls"
+
+const (
+	// The lowest Client Hello version a tls.ClientHelloInfo can describe without listing any supported versions
+	ssl30 uint16 = 0x0300
+)
+
+// ComputeJA3FromClientHelloInfo returns the JA3 object populated from the Client Hello seen by a crypto/tls server,
+// e.g. in the GetConfigForClient callback. This requires the ordered Extensions field of Go 1.24 or newer.
+//
+// The ClientHelloInfo does not contain the legacy version field of the Client Hello. If the client sent the
+// supported_versions extension, the version is assumed to be TLS 1.2 as mandated by RFC 8446, otherwise the highest of
+// the SupportedVersions, which crypto/tls derives from the legacy version, is used. As the raw Client Hello is not
+// available, the returned JA3 object can not be linted.
+func ComputeJA3FromClientHelloInfo(info *tls.ClientHelloInfo) *JA3 {
+	j := JA3{}
+
+	// Version
+	j.version = ssl30
+	for _, ex := range info.Extensions {
+		if ex == supportedVersionsExtensionType {
+			j.version = tls12
+		}
+	}
+	if j.version != tls12 {
+		for _, v := range info.SupportedVersions {
+			if v > j.version {
+				j.version = v
+			}
+		}
+	}
+
+	// Ignore any GREASE values in the cipher suites, extensions and elliptic curves
+	for _, cs := range info.CipherSuites {
+		if cs&greaseBitmask != 0x0A0A {
+			j.cipherSuites = append(j.cipherSuites, cs)
+		}
+	}
+	for _, ex := range info.Extensions {
+		if ex&greaseBitmask != 0x0A0A {
+			j.extensions = append(j.extensions, ex)
+		}
+	}
+	for _, ec := range info.SupportedCurves {
+		if uint16(ec)&greaseBitmask != 0x0A0A {
+			j.ellipticCurves = append(j.ellipticCurves, uint16(ec))
+		}
+	}
+	j.ellipticCurvePF = append(j.ellipticCurvePF, info.SupportedPoints...)
+
+	if info.ServerName != "" {
+		j.sni = []byte(info.ServerName)
+	}
+	return &j
+}
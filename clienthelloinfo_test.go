@@ -0,0 +1,107 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"bytes"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"testing"
+)
+
+// recordingConn records all data read from the underlying connection
+type recordingConn struct {
+	net.Conn
+	recorded bytes.Buffer
+}
+
+func (c *recordingConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.recorded.Write(b[:n])
+	return n, err
+}
+
+// serveClientHello runs a crypto/tls server on one end of a pipe and passes the other end to the client function. It
+// returns the ClientHelloInfo of the server together with the raw bytes of the Client Hello record.
+func serveClientHello(t *testing.T, client func(net.Conn)) (*tls.ClientHelloInfo, []byte) {
+	clientConn, serverConn := net.Pipe()
+	recConn := &recordingConn{Conn: serverConn}
+
+	var info *tls.ClientHelloInfo
+	var record []byte
+	errAbort := errors.New("abort handshake")
+	server := tls.Server(recConn, &tls.Config{
+		GetConfigForClient: func(chi *tls.ClientHelloInfo) (*tls.Config, error) {
+			info = chi
+			record = append([]byte(nil), recConn.recorded.Bytes()...)
+			return nil, errAbort
+		},
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer clientConn.Close()
+		client(clientConn)
+	}()
+	go func() {
+		// Drain the alert sent by the server
+		io.Copy(io.Discard, clientConn)
+	}()
+
+	if err := server.Handshake(); !errors.Is(err, errAbort) {
+		t.Fatalf("Expected: %v but got: %v\n", errAbort, err)
+	}
+	server.Close()
+	<-done
+	return info, record
+}
+
+func TestComputeJA3FromClientHelloInfo(t *testing.T) {
+	/*
+		Let a crypto/tls server parse Client Hellos and check that the resulting JA3 objects are equal to the ones
+		computed from the identical segments.
+	*/
+	var clients = map[string]func(net.Conn){
+		"Recorded Client Hello": func(c net.Conn) {
+			c.Write(googleClientHello)
+		},
+		"Go TLS 1.3 client": func(c net.Conn) {
+			tls.Client(c, &tls.Config{ServerName: "www.example.com"}).Handshake()
+		},
+		"Go TLS 1.2 client": func(c net.Conn) {
+			tls.Client(c, &tls.Config{
+				ServerName:       "www.example.com",
+				MaxVersion:       tls.VersionTLS12,
+				CurvePreferences: []tls.CurveID{tls.CurveP256, tls.CurveP384},
+			}).Handshake()
+		},
+	}
+
+	for name, client := range clients {
+		info, record := serveClientHello(t, client)
+		expJA3, err := ComputeJA3FromSegment(record)
+		if err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+			continue
+		}
+
+		ja3 := ComputeJA3FromClientHelloInfo(info)
+		if ja3.GetJA3String() != expJA3.GetJA3String() || ja3.GetJA3Hash() != expJA3.GetJA3Hash() || ja3.GetSNI() != expJA3.GetSNI() {
+			t.Errorf("%v: Expected: %v, %v, %v but got: %v, %v, %v\n",
+				name,
+				expJA3.GetJA3String(),
+				expJA3.GetJA3Hash(),
+				expJA3.GetSNI(),
+				ja3.GetJA3String(),
+				ja3.GetJA3Hash(),
+				ja3.GetSNI())
+		}
+	}
+}
@@ -28,5 +28,19 @@ string and digest.
   ja3String := j.GetJA3ByteString()
   anyWriterClass.Write(ja3String)
 
+Lenient Parsing
+Malformed Client Hellos are rejected by ComputeJA3FromSegment. To still get a
+best-effort fingerprint, e.g. of buggy or malicious clients, parse the segment in
+lenient mode, which additionally returns the anomalies encountered.
+
+  j, anomalies, err := ja3.ComputeJA3FromSegmentLenient(tcpPayload)
+  if err != nil {
+  // The packet could not be recognized as Client Hello at all
+  panic(err)
+  }
+  for _, a := range anomalies {
+  fmt.Printf("Anomaly: %v\n", a)
+  }
+
 */
 package ja3
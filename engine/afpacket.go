@@ -0,0 +1,21 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+// DefaultRingSize is the default size in bytes of the ring buffer of every AF_PACKET socket.
+const DefaultRingSize = 64 << 20
+
+// AFPacketOptions configures the capture with ReadFromAFPacket.
+type AFPacketOptions struct {
+	// Fanout is the number of sockets the packets are distributed across, one socket is opened if it is zero
+	Fanout int
+	// FanoutGroup is the ID of the fanout group, which is derived from the process ID if it is zero
+	FanoutGroup uint16
+	// RingSize is the size in bytes of the ring buffer of every socket, which is rounded up to whole blocks of 1 MiB.
+	// Zero selects DefaultRingSize.
+	RingSize int
+}
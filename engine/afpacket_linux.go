@@ -0,0 +1,281 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+	"golang.org/x/sys/unix"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unsafe"
+)
+
+// Layout of the TPACKET_V3 ring buffer
+const (
+	afPacketBlockSize    = 1 << 20
+	afPacketFrameSize    = 2048
+	afPacketBlockTimeout = 50 // milliseconds
+	// Offsets in the block descriptor
+	blockStatusOffset      = 8
+	blockNumPacketsOffset  = 12
+	blockFirstPacketOffset = 16
+	// Offsets in the packet header
+	packetNextOffset    = 0
+	packetSecOffset     = 4
+	packetNsecOffset    = 8
+	packetSnapLenOffset = 12
+	packetLenOffset     = 16
+	packetMacOffset     = 24
+)
+
+// ReadFromAFPacket captures from the interface with Linux AF_PACKET sockets using TPACKET_V3 ring buffers, which
+// needs neither cgo nor libpcap. With options.Fanout greater than one, it opens that many sockets in a fanout group
+// and returns one reader per socket. The kernel distributes the packets across the sockets by flow hash after
+// reassembling fragments, so every reader can be run by its own engine in its own goroutine. The interface is in
+// promiscuous mode. The readers implement StatsReader and io.Closer.
+func ReadFromAFPacket(device string, options AFPacketOptions) ([]Reader, error) {
+	intf, err := net.InterfaceByName(device)
+	if err != nil {
+		return nil, err
+	}
+	// Loopback interfaces have empty Ethernet headers, other interfaces without hardware address deliver raw IP
+	linkType := layers.LinkTypeEthernet
+	if intf.Flags&net.FlagLoopback == 0 && len(intf.HardwareAddr) == 0 {
+		linkType = layers.LinkTypeRaw
+	}
+
+	n := options.Fanout
+	if n < 1 {
+		n = 1
+	}
+	group := options.FanoutGroup
+	if group == 0 {
+		group = uint16(os.Getpid())
+	}
+	ringSize := options.RingSize
+	if ringSize <= 0 {
+		ringSize = DefaultRingSize
+	}
+	numBlocks := (ringSize + afPacketBlockSize - 1) / afPacketBlockSize
+
+	readers := make([]Reader, 0, n)
+	for i := 0; i < n; i++ {
+		r, err := openAFPacket(intf, linkType, numBlocks)
+		if err == nil && n > 1 {
+			err = unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_FANOUT,
+				int(group)|(unix.PACKET_FANOUT_HASH|unix.PACKET_FANOUT_FLAG_DEFRAG)<<16)
+			if err != nil {
+				r.Close()
+				err = fmt.Errorf("joining fanout group %v: %v", group, err)
+			}
+		}
+		if err != nil {
+			for _, r := range readers {
+				r.(*afPacketReader).Close()
+			}
+			return nil, err
+		}
+		readers = append(readers, r)
+	}
+	return readers, nil
+}
+
+// afPacketReader reads the blocks of a TPACKET_V3 ring buffer
+type afPacketReader struct {
+	fd       int
+	ring     []byte
+	device   string
+	linkType layers.LinkType
+	// block is the index of the current block, held reports whether it was handed over by the kernel, packets is the
+	// number of packets left in it and offset the offset of the next packet in the ring
+	block   int
+	blocks  int
+	held    bool
+	packets int
+	offset  int
+	// stats accumulates the statistics, as the kernel resets them when they are read
+	statsLock sync.Mutex
+	stats     CaptureStats
+}
+
+// openAFPacket opens a socket bound to the interface with a ring buffer of numBlocks blocks. The socket is opened
+// without protocol, so it receives no packets until it is bound, as they would come from any interface.
+func openAFPacket(intf *net.Interface, linkType layers.LinkType, numBlocks int) (*afPacketReader, error) {
+	fd, err := unix.Socket(unix.AF_PACKET, unix.SOCK_RAW|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return nil, fmt.Errorf("opening AF_PACKET socket: %v", err)
+	}
+	r := &afPacketReader{fd: fd, device: intf.Name, linkType: linkType, blocks: numBlocks}
+	if err := r.setup(intf, numBlocks); err != nil {
+		r.Close()
+		return nil, err
+	}
+	return r, nil
+}
+
+// setup maps a ring buffer of numBlocks blocks for the socket, binds it to the interface and enables promiscuous mode
+func (r *afPacketReader) setup(intf *net.Interface, numBlocks int) error {
+	if err := unix.SetsockoptInt(r.fd, unix.SOL_PACKET, unix.PACKET_VERSION, unix.TPACKET_V3); err != nil {
+		return fmt.Errorf("setting TPACKET_V3: %v", err)
+	}
+	req := unix.TpacketReq3{
+		Block_size:     afPacketBlockSize,
+		Block_nr:       uint32(numBlocks),
+		Frame_size:     afPacketFrameSize,
+		Frame_nr:       uint32(numBlocks * afPacketBlockSize / afPacketFrameSize),
+		Retire_blk_tov: afPacketBlockTimeout,
+	}
+	if err := unix.SetsockoptTpacketReq3(r.fd, unix.SOL_PACKET, unix.PACKET_RX_RING, &req); err != nil {
+		return fmt.Errorf("setting up the ring buffer: %v", err)
+	}
+	ring, err := unix.Mmap(r.fd, 0, numBlocks*afPacketBlockSize, unix.PROT_READ|unix.PROT_WRITE, unix.MAP_SHARED)
+	if err != nil {
+		return fmt.Errorf("mapping the ring buffer: %v", err)
+	}
+	r.ring = ring
+	if err := unix.Bind(r.fd, &unix.SockaddrLinklayer{Protocol: htons(unix.ETH_P_ALL), Ifindex: intf.Index}); err != nil {
+		return fmt.Errorf("binding to %v: %v", intf.Name, err)
+	}
+	mreq := unix.PacketMreq{Ifindex: int32(intf.Index), Type: unix.PACKET_MR_PROMISC}
+	if err := unix.SetsockoptPacketMreq(r.fd, unix.SOL_PACKET, unix.PACKET_ADD_MEMBERSHIP, &mreq); err != nil {
+		return fmt.Errorf("enabling promiscuous mode: %v", err)
+	}
+	return nil
+}
+
+// ZeroCopyReadPacketData returns the next packet of the ring buffer, which is only valid until the next call
+func (r *afPacketReader) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	for r.packets == 0 {
+		// Hand the current block back to the kernel once all its packets were read
+		if r.held {
+			atomic.StoreUint32(r.blockStatus(), unix.TP_STATUS_KERNEL)
+			r.block = (r.block + 1) % r.blocks
+			r.held = false
+		}
+		if atomic.LoadUint32(r.blockStatus())&unix.TP_STATUS_USER == 0 {
+			if err := r.wait(); err != nil {
+				return nil, gopacket.CaptureInfo{}, err
+			}
+			continue
+		}
+		base := r.block * afPacketBlockSize
+		r.held = true
+		r.packets = int(binary.NativeEndian.Uint32(r.ring[base+blockNumPacketsOffset:]))
+		r.offset = base + int(binary.NativeEndian.Uint32(r.ring[base+blockFirstPacketOffset:]))
+	}
+
+	header := r.ring[r.offset:]
+	ci := gopacket.CaptureInfo{
+		Timestamp:     time.Unix(int64(binary.NativeEndian.Uint32(header[packetSecOffset:])), int64(binary.NativeEndian.Uint32(header[packetNsecOffset:]))),
+		CaptureLength: int(binary.NativeEndian.Uint32(header[packetSnapLenOffset:])),
+		Length:        int(binary.NativeEndian.Uint32(header[packetLenOffset:])),
+	}
+	start := r.offset + int(binary.NativeEndian.Uint16(header[packetMacOffset:]))
+	data := r.ring[start : start+ci.CaptureLength]
+
+	r.packets--
+	r.offset += int(binary.NativeEndian.Uint32(header[packetNextOffset:]))
+	return data, ci, nil
+}
+
+// blockStatus returns the status word of the current block, which is shared with the kernel
+func (r *afPacketReader) blockStatus() *uint32 {
+	return (*uint32)(unsafe.Pointer(&r.ring[r.block*afPacketBlockSize+blockStatusOffset]))
+}
+
+// wait until the kernel hands over a block or the read timeout expired. Errors of the socket, e.g. as the interface
+// went down, are returned, as polling would return immediately again.
+func (r *afPacketReader) wait() error {
+	fds := []unix.PollFd{{Fd: int32(r.fd), Events: unix.POLLIN | unix.POLLERR}}
+	n, err := unix.Poll(fds, int(liveReadTimeout/time.Millisecond))
+	if err == unix.EINTR || (err == nil && n == 0) {
+		return errTimeout{}
+	} else if err != nil {
+		return err
+	}
+	switch {
+	case fds[0].Revents&unix.POLLNVAL != 0:
+		return fmt.Errorf("capturing on %v: socket closed", r.device)
+	case fds[0].Revents&unix.POLLERR != 0:
+		errno, err := unix.GetsockoptInt(r.fd, unix.SOL_SOCKET, unix.SO_ERROR)
+		if err != nil {
+			return fmt.Errorf("capturing on %v: %v", r.device, err)
+		}
+		return fmt.Errorf("capturing on %v: %v", r.device, unix.Errno(errno))
+	case fds[0].Revents&unix.POLLHUP != 0:
+		return fmt.Errorf("capturing on %v: socket hung up", r.device)
+	}
+	return nil
+}
+
+// LinkType returns the link type of the interface
+func (r *afPacketReader) LinkType() layers.LinkType {
+	return r.linkType
+}
+
+// InterfaceName returns the name of the captured interface
+func (r *afPacketReader) InterfaceName(index int) string {
+	return r.device
+}
+
+// Stats returns the number of packets received and dropped by the kernel since the socket was opened
+func (r *afPacketReader) Stats() (CaptureStats, error) {
+	r.statsLock.Lock()
+	defer r.statsLock.Unlock()
+	stats, err := unix.GetsockoptTpacketStatsV3(r.fd, unix.SOL_PACKET, unix.PACKET_STATISTICS)
+	if err != nil {
+		return r.stats, err
+	}
+	// The kernel counts the dropped packets as received as well
+	r.stats.Packets += uint64(stats.Packets - stats.Drops)
+	r.stats.Drops += uint64(stats.Drops)
+	return r.stats, nil
+}
+
+// setFilter attaches the filter to the socket, so the kernel drops the packets before they reach the ring buffer
+func (r *afPacketReader) setFilter(expr string) error {
+	program, err := compileFilter(r.linkType, expr)
+	if err != nil || program == nil {
+		return err
+	}
+	raw, err := bpf.Assemble(program)
+	if err != nil {
+		return err
+	}
+	filter := make([]unix.SockFilter, len(raw))
+	for i, ins := range raw {
+		filter[i] = unix.SockFilter{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	prog := unix.SockFprog{Len: uint16(len(filter)), Filter: &filter[0]}
+	return unix.SetsockoptSockFprog(r.fd, unix.SOL_SOCKET, unix.SO_ATTACH_FILTER, &prog)
+}
+
+// Close unmaps the ring buffer and closes the socket, closing it again does nothing
+func (r *afPacketReader) Close() error {
+	if r.ring != nil {
+		unix.Munmap(r.ring)
+		r.ring = nil
+	}
+	if r.fd < 0 {
+		return nil
+	}
+	fd := r.fd
+	r.fd = -1
+	return unix.Close(fd)
+}
+
+// htons converts v to network byte order
+func htons(v uint16) uint16 {
+	return v<<8 | v>>8
+}
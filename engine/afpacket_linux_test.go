@@ -0,0 +1,206 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/sys/unix"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadFromAFPacket(t *testing.T) {
+	/*
+		A Client Hello sent over the loopback interface has to be found by one of the engines running on the readers of
+		the fanout group. The test needs the CAP_NET_RAW capability and is skipped otherwise.
+	*/
+	readers, err := ReadFromAFPacket("lo", AFPacketOptions{Fanout: 2, RingSize: 1 << 20})
+	if err != nil {
+		t.Skipf("AF_PACKET capture not possible: %v", err)
+	}
+	defer func() {
+		for _, r := range readers {
+			r.(io.Closer).Close()
+		}
+	}()
+	if len(readers) != 2 {
+		t.Fatalf("Expected: %v readers but got: %v\n", 2, len(readers))
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var wg sync.WaitGroup
+	found := make(chan Record, len(readers))
+	for _, r := range readers {
+		wg.Add(1)
+		go func(r Reader) {
+			defer wg.Done()
+			e := &Engine{Filter: DefaultFilter}
+			e.Run(ctx, r, func(record Record) {
+				if bytes.Equal(record.Payload, googleClientHello) {
+					found <- record.Clone()
+					cancel()
+				}
+			})
+		}(r)
+	}
+
+	// Send the Client Hello over a TCP connection on the loopback interface
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		if c, err := l.Accept(); err == nil {
+			io.Copy(io.Discard, c)
+			c.Close()
+		}
+	}()
+	c, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if _, err := c.Write(googleClientHello); err != nil {
+		t.Fatal(err)
+	}
+
+	wg.Wait()
+	select {
+	case r := <-found:
+		if r.JA3.GetJA3Hash() != googleJA3Hash || r.DstPort != uint16(l.Addr().(*net.TCPAddr).Port) {
+			t.Errorf("Expected: %v but got: %v\n", googleJA3Hash, r.JA3.GetJA3Hash())
+		}
+	default:
+		t.Fatalf("Expected: %v records but got: %v\n", 1, 0)
+	}
+
+	var stats CaptureStats
+	for _, r := range readers {
+		s, err := r.(StatsReader).Stats()
+		if err != nil {
+			t.Fatalf("Expected: %v but got: %v\n", nil, err)
+		}
+		stats.Packets += s.Packets
+	}
+	if stats.Packets == 0 {
+		t.Errorf("Expected: some packets but got: %v\n", stats.Packets)
+	}
+}
+
+type afPacketErrorTestContainer struct {
+	intf      *net.Interface
+	numBlocks int
+	expErr    string
+}
+
+func TestOpenAFPacketError(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Failures after the socket was opened have to close it and return an error. The test needs the CAP_NET_RAW
+		capability and is skipped otherwise.
+	*/
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skipf("No loopback interface: %v", err)
+	}
+	r, err := openAFPacket(lo, layers.LinkTypeEthernet, 1)
+	if err != nil {
+		t.Skipf("AF_PACKET capture not possible: %v", err)
+	}
+	if err := r.Close(); err != nil {
+		t.Errorf("Expected: %v but got: %v\n", nil, err)
+	}
+	// Closing again must not close a descriptor reused in the meantime
+	if err := r.Close(); err != nil || r.fd != -1 {
+		t.Errorf("Expected: %v but got: %v (fd %v)\n", nil, err, r.fd)
+	}
+
+	var afPacketErrorTestSet = map[string]afPacketErrorTestContainer{
+		"Ring buffer too large": {
+			intf:      lo,
+			numBlocks: 1 << 20,
+			expErr:    "setting up the ring buffer",
+		},
+		"Unknown interface": {
+			intf:      &net.Interface{Index: 1 << 30, Name: "unknown"},
+			numBlocks: 1,
+			expErr:    "binding to unknown",
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range afPacketErrorTestSet {
+		r, err := openAFPacket(test.intf, layers.LinkTypeEthernet, test.numBlocks)
+		if r != nil || err == nil || !strings.Contains(err.Error(), test.expErr) {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expErr, err)
+		}
+	}
+}
+
+type afPacketWaitTestContainer struct {
+	fd     int
+	expErr string
+}
+
+func TestAFPacketWaitError(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Errors and hang ups reported by poll have to be returned instead of waiting again, which would return
+		immediately and spin. Sockets and pipes stand in for the AF_PACKET socket, so no capability is needed.
+	*/
+	refused := func() int {
+		l, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr := l.LocalAddr().(*net.UDPAddr)
+		l.Close()
+		fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := unix.Connect(fd, &unix.SockaddrInet4{Port: addr.Port, Addr: [4]byte{127, 0, 0, 1}}); err != nil {
+			t.Fatal(err)
+		}
+		// The port unreachable message of the closed port sets the error of the socket
+		unix.Write(fd, []byte("ping"))
+		time.Sleep(10 * time.Millisecond)
+		return fd
+	}
+	hungUp := func() int {
+		var fds [2]int
+		if err := unix.Pipe2(fds[:], unix.O_CLOEXEC); err != nil {
+			t.Fatal(err)
+		}
+		unix.Close(fds[1])
+		return fds[0]
+	}
+	var waitErrorTestSet = map[string]afPacketWaitTestContainer{
+		"Socket error": {refused(), "connection refused"},
+		"Hung up":      {hungUp(), "socket hung up"},
+	}
+
+	// Run through all test cases
+	for name, test := range waitErrorTestSet {
+		r := &afPacketReader{fd: test.fd, device: "lo"}
+		err := r.wait()
+		if err == nil || !strings.Contains(err.Error(), test.expErr) {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expErr, err)
+		}
+		r.Close()
+	}
+}
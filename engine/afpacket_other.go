@@ -0,0 +1,18 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build !linux
+
+package engine
+
+import (
+	"errors"
+)
+
+// ReadFromAFPacket is only available on Linux.
+func ReadFromAFPacket(device string, options AFPacketOptions) ([]Reader, error) {
+	return nil, errors.New("AF_PACKET capture is only available on Linux")
+}
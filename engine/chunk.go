@@ -0,0 +1,424 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultChunkSize is the default size of the chunks pcap files are split into by Pipeline.RunFile.
+const DefaultChunkSize = 64 << 20
+
+// Layout of pcap files
+const (
+	pcapFileHeaderLen   = 24
+	pcapRecordHeaderLen = 16
+	// pcapSyncRecords is the number of consecutive consistent record headers needed to find the start of a record in
+	// the middle of a file
+	pcapSyncRecords = 8
+	// pcapSyncMaxLength is the maximum original length of a packet accepted as consistent, which is the maximum
+	// snap length of tcpdump
+	pcapSyncMaxLength = 262144
+	// pcapSyncMaxSpan is the maximum time in seconds between the packet at the start of a chunk and a packet accepted
+	// as consistent in either direction, so clocks stepped back do not prevent splitting, and pcapSyncMaxBackwards the
+	// maximum time a packet accepted as consistent may go back from the one before
+	pcapSyncMaxSpan      = 365 * 24 * 60 * 60
+	pcapSyncMaxBackwards = 60
+)
+
+// RunFile runs the pipeline on the capture file at path. Pcap files are memory mapped and split into chunks at record
+// boundaries, which the workers process in parallel. The records of the chunks are merged by timestamp, so for files in
+// the order of capture the handler sees the records in the order of the file, as if a single engine had run on it, and
+// files concatenated from captures overlapping in time are put back into the order of capture. Fragments of a datagram
+// split across two chunks cannot be reassembled. Other formats, platforms without memory mapping and engines tracking
+// sessions, which would be split across chunks, are read sequentially with Run.
+func (p *Pipeline) RunFile(ctx context.Context, path string, handler func(Record)) error {
+	engines := p.newEngines()
+	var data []byte
+	err := errNoMmap
+	if !engines[0].Sessions {
+		data, err = mapFile(path)
+	}
+	if err == errNoMmap {
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return p.runReader(ctx, f, engines, handler)
+	} else if err != nil {
+		return err
+	}
+	defer unmapFile(data)
+
+	header, ok := parsePcapHeader(data)
+	if !ok {
+		return p.runReader(ctx, bytes.NewReader(data), engines, handler)
+	}
+	chunkSize := p.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	return p.runChunks(ctx, splitPcap(data, header, chunkSize), engines, handler)
+}
+
+// runReader runs the engines on the capture file read from file, whose format is detected automatically
+func (p *Pipeline) runReader(ctx context.Context, file io.Reader, engines []*Engine, handler func(Record)) error {
+	r, err := ReadFile(file)
+	if err != nil {
+		return err
+	}
+	return p.run(ctx, r, engines, handler)
+}
+
+// chunkResult holds the cloned records found in a chunk, done is closed once the chunk was processed. claimed is set by
+// the goroutine processing the chunk, token tells whether it holds a token of the pending chunks, next is the index of
+// the next record to merge and gen counts the heads of the chunk in the merge, so outdated heads are skipped.
+type chunkResult struct {
+	records []Record
+	err     error
+	done    chan struct{}
+	claimed int32
+	token   bool
+	next    int
+	gen     int
+}
+
+// chunkHead is the timestamp of the next record of a chunk in the merge, or of its first packet before it was processed
+type chunkHead struct {
+	ts    time.Time
+	chunk int
+	gen   int
+}
+
+// chunkHeads is a heap of the heads of the chunks, the oldest first and those of the same time in the order of the file
+type chunkHeads []chunkHead
+
+func (h chunkHeads) Len() int { return len(h) }
+
+func (h chunkHeads) Less(a, b int) bool {
+	if !h[a].ts.Equal(h[b].ts) {
+		return h[a].ts.Before(h[b].ts)
+	}
+	return h[a].chunk < h[b].chunk
+}
+
+func (h chunkHeads) Swap(a, b int) { h[a], h[b] = h[b], h[a] }
+
+func (h *chunkHeads) Push(x any) { *h = append(*h, x.(chunkHead)) }
+
+func (h *chunkHeads) Pop() any {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// runChunks runs the engines on the chunks in parallel and merges their records by timestamp, records of the same time
+// keep the order of the file. Like the inputs of the commands, every chunk is merged in the order of its records, so
+// for files in the order of capture the handler sees the records in the order of the file. The chunks are processed
+// in the order of the timestamps of their first packets, which is the order of the file unless it was concatenated from
+// captures overlapping in time. The chunk boundaries found by splitPcap are verified with the end of the chunk before,
+// which was reached by walking its records. A chunk starting at a false boundary is read once more from the real end
+// of the chunk before.
+func (p *Pipeline) runChunks(ctx context.Context, chunks []*pcapChunkReader, engines []*Engine,
+	handler func(Record)) error {
+	ctx, cancel := context.WithCancel(ctx)
+	results := make([]chunkResult, len(chunks))
+	for i := range results {
+		results[i].done = make(chan struct{})
+	}
+	process := func(e *Engine, i int) {
+		result := &results[i]
+		result.err = e.Run(ctx, chunks[i], func(record Record) {
+			result.records = append(result.records, record.Clone())
+		})
+		close(result.done)
+	}
+
+	// Limit the number of chunks processed ahead of the merge, as their records are buffered
+	order := make([]int, len(chunks))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return chunks[order[a]].firstTimestamp().Before(chunks[order[b]].firstTimestamp())
+	})
+	pending := make(chan struct{}, 2*len(engines))
+	jobs := make(chan int)
+	go func() {
+		defer close(jobs)
+		for _, i := range order {
+			select {
+			case pending <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if !atomic.CompareAndSwapInt32(&results[i].claimed, 0, 1) {
+				// The merge needed the chunk earlier and processed it itself
+				<-pending
+				continue
+			}
+			results[i].token = true
+			select {
+			case jobs <- i:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	var wg sync.WaitGroup
+	for _, e := range engines {
+		wg.Add(1)
+		go func(e *Engine) {
+			defer wg.Done()
+			for i := range jobs {
+				process(e, i)
+			}
+		}(e)
+	}
+	defer func() {
+		cancel()
+		wg.Wait()
+	}()
+
+	// own processes the chunks the merge needs before a worker took them, as more chunks overlap in time than may be
+	// pending, and reads the chunks starting at false boundaries once more
+	var own *Engine
+	ownEngine := func() *Engine {
+		if own == nil {
+			own = p.newEngine()
+		}
+		return own
+	}
+	release := func(result *chunkResult) {
+		result.records = nil
+		if result.token {
+			result.token = false
+			<-pending
+		}
+	}
+
+	heads := make(chunkHeads, 0, len(chunks))
+	for i, chunk := range chunks {
+		heads = append(heads, chunkHead{ts: chunk.firstTimestamp(), chunk: i})
+	}
+	heap.Init(&heads)
+	// The chunks before verified were processed and their boundaries verified, the chunks from stop on are behind an
+	// error and not merged, as a single engine would stop at the error
+	verified, stop := 0, len(chunks)
+	var err error
+	for heads.Len() > 0 {
+		head := heap.Pop(&heads).(chunkHead)
+		result := &results[head.chunk]
+		if head.chunk >= stop || head.gen != result.gen {
+			continue
+		}
+
+		// Verify the chunks up to the chunk of the head in the order of the file, their heads move to their first records
+		for ; verified <= head.chunk && verified < stop; verified++ {
+			i, verifiedResult := verified, &results[verified]
+			if atomic.CompareAndSwapInt32(&verifiedResult.claimed, 0, 1) {
+				process(ownEngine(), i)
+			}
+			select {
+			case <-verifiedResult.done:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			if i > 0 && chunks[i].start != chunks[i-1].offset {
+				chunks[i] = &pcapChunkReader{header: chunks[i].header, data: chunks[i].data, start: chunks[i-1].offset,
+					offset: chunks[i-1].offset, end: chunks[i].end}
+				verifiedResult.records = nil
+				verifiedResult.err = ownEngine().Run(ctx, chunks[i], func(record Record) {
+					verifiedResult.records = append(verifiedResult.records, record.Clone())
+				})
+			}
+			if verifiedResult.err != nil {
+				err, stop = verifiedResult.err, i+1
+			}
+			verifiedResult.gen++
+			if len(verifiedResult.records) > 0 {
+				heap.Push(&heads, chunkHead{ts: verifiedResult.records[0].Timestamp, chunk: i, gen: verifiedResult.gen})
+			} else {
+				release(verifiedResult)
+			}
+		}
+		if head.gen != result.gen {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		handler(result.records[result.next])
+		result.next++
+		if result.next < len(result.records) {
+			heap.Push(&heads, chunkHead{ts: result.records[result.next].Timestamp, chunk: head.chunk, gen: result.gen})
+		} else {
+			release(result)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// pcapHeader holds the fields of a pcap file header needed to read its records
+type pcapHeader struct {
+	order binary.ByteOrder
+	// fractions is the number of fractions of a second the timestamps are given in
+	fractions uint32
+	snaplen   uint32
+	linkType  layers.LinkType
+}
+
+// parsePcapHeader parses the header of the pcap file and reports whether it is one
+func parsePcapHeader(data []byte) (pcapHeader, bool) {
+	if len(data) < pcapFileHeaderLen {
+		return pcapHeader{}, false
+	}
+	var h pcapHeader
+	for _, order := range []binary.ByteOrder{binary.LittleEndian, binary.BigEndian} {
+		switch order.Uint32(data) {
+		case pcapMagic:
+			h.order, h.fractions = order, uint32(time.Second/time.Microsecond)
+		case pcapNanoMagic:
+			h.order, h.fractions = order, uint32(time.Second/time.Nanosecond)
+		}
+	}
+	if h.order == nil {
+		return pcapHeader{}, false
+	}
+	h.snaplen = h.order.Uint32(data[16:])
+	h.linkType = layers.LinkType(h.order.Uint32(data[20:]))
+	return h, true
+}
+
+// timestamp returns the timestamp of the record with the header
+func (h pcapHeader) timestamp(header []byte) time.Time {
+	fraction := int64(h.order.Uint32(header[4:])) * int64(time.Second) / int64(h.fractions)
+	return time.Unix(int64(h.order.Uint32(header)), fraction).UTC()
+}
+
+// consistentRecords reports whether the data at offset starts with pcapSyncRecords records, or the records up to the
+// end of the data, whose headers are consistent with the file header and the record at the start of the chunk at
+// startSecond. Being strict only moves the chunk boundaries, while positions inside a record accepted by mistake are
+// found by runChunks.
+func (h pcapHeader) consistentRecords(data []byte, offset int, startSecond uint32) bool {
+	previous := int64(startSecond)
+	for i := 0; i < pcapSyncRecords && offset < len(data); i++ {
+		if offset+pcapRecordHeaderLen > len(data) {
+			return false
+		}
+		second := int64(h.order.Uint32(data[offset:]))
+		fraction := h.order.Uint32(data[offset+4:])
+		captureLength := h.order.Uint32(data[offset+8:])
+		length := h.order.Uint32(data[offset+12:])
+		if fraction >= h.fractions || captureLength > h.snaplen || captureLength > length || length > pcapSyncMaxLength {
+			return false
+		}
+		if second < int64(startSecond)-pcapSyncMaxSpan || second > int64(startSecond)+pcapSyncMaxSpan ||
+			(i > 0 && second < previous-pcapSyncMaxBackwards) {
+			return false
+		}
+		previous = second
+		offset += pcapRecordHeaderLen + int(captureLength)
+		if offset > len(data) {
+			return false
+		}
+	}
+	return true
+}
+
+// splitPcap splits the records of the pcap file into chunks of about chunkSize bytes. The start of the records
+// behind the nominal chunk boundaries is found by looking for consistent record headers.
+func splitPcap(data []byte, header pcapHeader, chunkSize int) []*pcapChunkReader {
+	var chunks []*pcapChunkReader
+	start := pcapFileHeaderLen
+	for start < len(data) {
+		end := len(data)
+		// Files without snap length cannot be split reliably
+		if header.snaplen > 0 && start+pcapRecordHeaderLen <= len(data) {
+			startSecond := header.order.Uint32(data[start:])
+			for offset := start + chunkSize; offset < len(data); offset++ {
+				if header.consistentRecords(data, offset, startSecond) {
+					end = offset
+					break
+				}
+			}
+		}
+		chunks = append(chunks, &pcapChunkReader{header: header, data: data, start: start, offset: start, end: end})
+		start = end
+	}
+	return chunks
+}
+
+// pcapChunkReader reads the records of a chunk of a memory mapped pcap file without copying them. It reads the records
+// starting before the end of the chunk, so if the end is not at a record boundary, the last record reaches into the
+// next chunk and offset is the real end of the chunk once it was read.
+type pcapChunkReader struct {
+	header     pcapHeader
+	data       []byte
+	start, end int
+	offset     int
+}
+
+// ZeroCopyReadPacketData returns the next record of the chunk
+func (r *pcapChunkReader) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	if r.offset >= r.end {
+		return nil, gopacket.CaptureInfo{}, io.EOF
+	}
+	if r.offset+pcapRecordHeaderLen > len(r.data) {
+		return nil, gopacket.CaptureInfo{}, io.ErrUnexpectedEOF
+	}
+	h := r.data[r.offset:]
+	order := r.header.order
+	ci := gopacket.CaptureInfo{
+		Timestamp:     r.header.timestamp(h),
+		CaptureLength: int(order.Uint32(h[8:])),
+		Length:        int(order.Uint32(h[12:])),
+	}
+	if ci.CaptureLength > int(r.header.snaplen) {
+		return nil, ci, fmt.Errorf("capture length exceeds snap length: %d > %d", ci.CaptureLength, r.header.snaplen)
+	}
+	if ci.CaptureLength > ci.Length {
+		return nil, ci, fmt.Errorf("capture length exceeds original packet length: %d > %d", ci.CaptureLength, ci.Length)
+	}
+	start := r.offset + pcapRecordHeaderLen
+	if start+ci.CaptureLength > len(r.data) {
+		return nil, ci, io.ErrUnexpectedEOF
+	}
+	r.offset = start + ci.CaptureLength
+	return r.data[start:r.offset], ci, nil
+}
+
+// firstTimestamp returns the timestamp of the record at the start of the chunk
+func (r *pcapChunkReader) firstTimestamp() time.Time {
+	if r.start+pcapRecordHeaderLen > len(r.data) {
+		return time.Time{}
+	}
+	return r.header.timestamp(r.data[r.start:])
+}
+
+// LinkType returns the link type of the pcap file
+func (r *pcapChunkReader) LinkType() layers.LinkType {
+	return r.header.linkType
+}
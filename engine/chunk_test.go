@@ -0,0 +1,207 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+)
+
+type runFileTestContainer struct {
+	data      []byte
+	chunkSize int
+	expErr    error
+}
+
+// writeTestFile writes data to a file in a temporary directory and returns its path
+func writeTestFile(t testing.TB, data []byte) string {
+	path := filepath.Join(t.TempDir(), "capture")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPipelineRunFile(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The chunks processed in parallel have to yield the same records in the same order as a single engine, no matter
+		where the chunk boundaries fall, also if a boundary was falsely found inside a record. Other formats are read
+		sequentially.
+	*/
+	capture := testCaptureData(t, uint32(layers.LinkTypeEthernet), flowPackets(30, 4, 200)...)
+	// A packet carrying a pcap file makes the records inside of it look like a chunk boundary
+	embedded := testCaptureData(t, uint32(layers.LinkTypeEthernet), flowPackets(10, 1, 0)...)[pcapFileHeaderLen:]
+	prefix := testCaptureData(t, uint32(layers.LinkTypeEthernet), flowPackets(3, 1, 0)...)
+	falseBoundary := testCaptureData(t, uint32(layers.LinkTypeEthernet),
+		concat(flowPackets(3, 1, 0), []testPacket{tcpPacket(embedded)}, flowPackets(3, 1, 0))...)
+
+	var runFileTestSet = map[string]runFileTestContainer{
+		"False boundary": {falseBoundary, len(prefix) - pcapFileHeaderLen + 1, nil},
+		"Single chunk":   {capture, 0, nil},
+		"Small chunks":   {capture, 1000, nil},
+		"Tiny chunks":    {capture, 1, nil},
+		"Truncated":      {capture[:len(capture)-10], 1000, io.ErrUnexpectedEOF},
+		"Header only":    {capture[:pcapFileHeaderLen], 1000, nil},
+		"Empty":          {nil, 1000, ErrUnknownFormat},
+		"Unknown format": {[]byte("not a capture"), 1000, ErrUnknownFormat},
+	}
+
+	// Run through all test cases
+	for name, test := range runFileTestSet {
+		var expected []Record
+		r, err := ReadFile(bytes.NewReader(test.data))
+		if err == nil {
+			err = (&Engine{}).Run(context.Background(), r, func(r Record) {
+				expected = append(expected, r.Clone())
+			})
+		}
+		if err != test.expErr {
+			t.Fatalf("%v: Expected: %v but got: %v\n", name, test.expErr, err)
+		}
+
+		for _, workers := range []int{1, 4} {
+			var records []Record
+			p := &Pipeline{Workers: workers, ChunkSize: test.chunkSize}
+			err := p.RunFile(context.Background(), writeTestFile(t, test.data), func(r Record) {
+				records = append(records, r)
+			})
+			if err != test.expErr {
+				t.Errorf("%v, workers %v: Expected: %v but got: %v\n", name, workers, test.expErr, err)
+			}
+			if len(records) != len(expected) {
+				t.Fatalf("%v, workers %v: Expected: %v records but got: %v\n", name, workers, len(expected), len(records))
+			}
+			for i, r := range records {
+				if !r.Timestamp.Equal(expected[i].Timestamp) || r.SrcPort != expected[i].SrcPort {
+					t.Errorf("%v, workers %v: Expected: %v but got: %v\n", name, workers, expected[i].Flow, r.Flow)
+				}
+			}
+		}
+	}
+}
+
+// shiftRecords returns the records of the pcap file without its file header, moved by the number of seconds in time
+func shiftRecords(t testing.TB, capture []byte, seconds int) []byte {
+	header, ok := parsePcapHeader(capture)
+	if !ok {
+		t.Fatalf("Expected: %v but got: %v\n", true, ok)
+	}
+	records := append([]byte(nil), capture[pcapFileHeaderLen:]...)
+	for offset := 0; offset < len(records); {
+		header.order.PutUint32(records[offset:], uint32(int(header.order.Uint32(records[offset:]))+seconds))
+		offset += pcapRecordHeaderLen + int(header.order.Uint32(records[offset+8:]))
+	}
+	return records
+}
+
+func TestPipelineRunFileMerge(t *testing.T) {
+	/*
+		The records of a file concatenated from two captures, the later one first, have to be merged by timestamp, with
+		the records of the same time in the order of the file.
+	*/
+	earlier := testCaptureData(t, uint32(layers.LinkTypeEthernet), flowPackets(20, 3, 200)...)
+	later := shiftRecords(t, testCaptureData(t, uint32(layers.LinkTypeEthernet), flowPackets(10, 3, 200)...), 3600)
+	capture := append(append(append([]byte(nil), earlier[:pcapFileHeaderLen]...), later...), earlier[pcapFileHeaderLen:]...)
+	path := writeTestFile(t, capture)
+
+	r, err := ReadFile(bytes.NewReader(capture))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := collect(t, &Engine{}, r)
+	sort.SliceStable(expected, func(a, b int) bool { return expected[a].Timestamp.Before(expected[b].Timestamp) })
+
+	for _, chunkSize := range []int{1, 100} {
+		for _, workers := range []int{1, 4} {
+			var records []Record
+			p := &Pipeline{Workers: workers, ChunkSize: chunkSize}
+			if err := p.RunFile(context.Background(), path, func(r Record) {
+				records = append(records, r)
+			}); err != nil {
+				t.Fatalf("Chunk size %v, workers %v: Expected: %v but got: %v\n", chunkSize, workers, nil, err)
+			}
+			if len(records) != len(expected) {
+				t.Fatalf("Chunk size %v, workers %v: Expected: %v records but got: %v\n", chunkSize, workers,
+					len(expected), len(records))
+			}
+			for i, r := range records {
+				if !r.Timestamp.Equal(expected[i].Timestamp) || r.SrcPort != expected[i].SrcPort {
+					t.Errorf("Chunk size %v, workers %v: Expected: %v at %v but got: %v at %v\n", chunkSize, workers,
+						expected[i].Flow, expected[i].Timestamp, r.Flow, r.Timestamp)
+				}
+			}
+		}
+	}
+}
+
+func TestSplitPcap(t *testing.T) {
+	/*
+		The chunks have to start at record boundaries, so all packets are read without errors. Positions inside the
+		record headers look consistent as well if the timestamps are not checked.
+	*/
+	capture := testCaptureData(t, uint32(layers.LinkTypeEthernet), flowPackets(10, 10, 500)...)
+	header, ok := parsePcapHeader(capture)
+	if !ok {
+		t.Fatalf("Expected: %v but got: %v\n", true, ok)
+	}
+	// A clock stepped back by a day after the first packet must not prevent splitting the file
+	stepped := append(capture[:pcapFileHeaderLen:pcapFileHeaderLen], shiftRecords(t, capture, -86400)...)
+	copy(stepped[pcapFileHeaderLen:], capture[pcapFileHeaderLen:pcapFileHeaderLen+4])
+	for _, data := range [][]byte{capture, stepped} {
+		splitChunks(t, data, header)
+	}
+}
+
+// splitChunks splits the capture of 100 packets with several chunk sizes and reads all packets of the chunks
+func splitChunks(t *testing.T, capture []byte, header pcapHeader) {
+	for _, chunkSize := range []int{1, 100, 1000, len(capture)} {
+		packets := 0
+		chunks := splitPcap(capture, header, chunkSize)
+		if chunkSize == 1 && len(chunks) != 100 {
+			t.Errorf("Expected: %v chunks but got: %v\n", 100, len(chunks))
+		}
+		for _, chunk := range chunks {
+			for {
+				_, _, err := chunk.ZeroCopyReadPacketData()
+				if err == io.EOF {
+					break
+				} else if err != nil {
+					t.Fatalf("Chunk size %v: Expected: %v but got: %v\n", chunkSize, nil, err)
+				}
+				packets++
+			}
+		}
+		if packets != 100 {
+			t.Errorf("Chunk size %v: Expected: %v packets but got: %v\n", chunkSize, 100, packets)
+		}
+	}
+}
+
+func BenchmarkPipelineRunFile(b *testing.B) {
+	path := writeTestFile(b, benchmarkData(b))
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("workers=%v", workers), func(b *testing.B) {
+			p := &Pipeline{Workers: workers, ChunkSize: 1 << 20}
+			b.SetBytes(int64(len(benchmarkCapture)))
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if err := p.RunFile(context.Background(), path, func(Record) {}); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,44 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"github.com/google/gopacket/layers"
+)
+
+// communityIDVersion is the version prefix of the Community ID flow hash
+const communityIDVersion = "1:"
+
+// CommunityID returns the Community ID v1 flow hash of the TCP flow with the seed, which is the same for both
+// directions. It matches the community_id field of Zeek and Suricata logs computed with the same seed, so the records
+// can be joined with them.
+func (f Flow) CommunityID(seed uint16) string {
+	src, dst := f.SrcIP.To4(), f.DstIP.To4()
+	if src == nil || dst == nil {
+		src, dst = f.SrcIP.To16(), f.DstIP.To16()
+	}
+	srcPort, dstPort := f.SrcPort, f.DstPort
+
+	// Order the endpoints, so both directions hash the same
+	if c := bytes.Compare(src, dst); c > 0 || (c == 0 && srcPort > dstPort) {
+		src, srcPort, dst, dstPort = dst, dstPort, src, srcPort
+	}
+
+	var buf [2 + 2*16 + 2 + 4]byte
+	data := binary.BigEndian.AppendUint16(buf[:0], seed)
+	data = append(data, src...)
+	data = append(data, dst...)
+	data = append(data, byte(layers.IPProtocolTCP), 0)
+	data = binary.BigEndian.AppendUint16(data, srcPort)
+	data = binary.BigEndian.AppendUint16(data, dstPort)
+	h := sha1.Sum(data)
+	return communityIDVersion + base64.StdEncoding.EncodeToString(h[:])
+}
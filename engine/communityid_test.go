@@ -0,0 +1,52 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"net"
+	"testing"
+)
+
+type communityIDTestContainer struct {
+	flow  Flow
+	seed  uint16
+	expID string
+}
+
+func TestCommunityID(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The flow hashes have to match the test vectors of the Community ID specification in both directions.
+	*/
+	var communityIDTestSet = map[string]communityIDTestContainer{
+		"IPv4": {
+			flow:  Flow{SrcIP: net.ParseIP("128.232.110.120"), DstIP: net.ParseIP("66.35.250.204"), SrcPort: 34855, DstPort: 80},
+			expID: "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		},
+		"IPv4 reversed": {
+			flow:  Flow{SrcIP: net.ParseIP("66.35.250.204"), DstIP: net.ParseIP("128.232.110.120"), SrcPort: 80, DstPort: 34855},
+			expID: "1:LQU9qZlK+B5F3KDmev6m5PMibrg=",
+		},
+		"IPv4 with seed": {
+			flow:  Flow{SrcIP: net.IP{128, 232, 110, 120}, DstIP: net.IP{66, 35, 250, 204}, SrcPort: 34855, DstPort: 80},
+			seed:  1,
+			expID: "1:3V71V58M3Ksw/yuFALMcW0LAHvc=",
+		},
+		"IPv6": {
+			flow:  Flow{SrcIP: net.ParseIP("fe80::1"), DstIP: net.ParseIP("fe80::2"), SrcPort: 1000, DstPort: 443},
+			expID: "1:ZeZ+EsiB33mYeciv6T6HzNiJHFY=",
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range communityIDTestSet {
+		if id := test.flow.CommunityID(test.seed); id != test.expID {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expID, id)
+		}
+	}
+}
@@ -0,0 +1,40 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+)
+
+// Magic numbers of the supported compression formats
+var (
+	gzipMagic = []byte{0x1f, 0x8b}
+	zstdMagic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+	xzMagic   = []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// decompress returns a reader for the decompressed file if it is compressed with gzip, zstd or xz, otherwise it
+// returns br itself
+func decompress(br *bufio.Reader) (io.Reader, error) {
+	// Files shorter than the longest magic number are detected by the bytes available
+	magic, _ := br.Peek(len(xzMagic))
+	switch {
+	case bytes.HasPrefix(magic, gzipMagic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstdMagic):
+		// A single goroutine decodes synchronously, so the decoder does not have to be closed
+		return zstd.NewReader(br, zstd.WithDecoderConcurrency(1))
+	case bytes.HasPrefix(magic, xzMagic):
+		return xz.NewReader(br)
+	}
+	return br, nil
+}
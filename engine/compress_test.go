@@ -0,0 +1,60 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/google/gopacket/layers"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+	"io"
+	"testing"
+)
+
+func TestReadFileCompressed(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Compressed captures have to be read like uncompressed ones.
+	*/
+	capture := testCaptureData(t, uint32(layers.LinkTypeEthernet), tcpPacket(googleClientHello), tcpPacket(nil))
+	var compressTestSet = map[string]func(io.Writer) (io.WriteCloser, error){
+		"gzip": func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil },
+		"zstd": func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) },
+		"xz":   func(w io.Writer) (io.WriteCloser, error) { return xz.NewWriter(w) },
+	}
+
+	// Run through all test cases
+	for name, newWriter := range compressTestSet {
+		var compressed bytes.Buffer
+		w, err := newWriter(&compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(capture); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		r, err := ReadFile(&compressed)
+		if err != nil {
+			t.Fatalf("%v: Expected: %v but got: %v\n", name, nil, err)
+		}
+		records := collect(t, &Engine{}, r)
+		if len(records) != 1 || records[0].JA3.GetJA3Hash() != googleJA3Hash {
+			t.Errorf("%v: Expected: %v records but got: %v\n", name, 1, len(records))
+		}
+	}
+
+	// Corrupt compressed data is reported
+	if _, err := ReadFile(bytes.NewReader(append(append([]byte(nil), xzMagic...), 0, 0))); err == nil {
+		t.Errorf("Expected: an error but got: %v\n", err)
+	}
+}
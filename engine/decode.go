@@ -0,0 +1,220 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+)
+
+// Link types which are missing in gopacket
+const (
+	// linkTypeRawDLT is the link type of raw IP captures reported by libpcap on most platforms
+	linkTypeRawDLT layers.LinkType = 12
+	// linkTypeRawOpenBSD is the link type of raw IP captures reported by libpcap on OpenBSD
+	linkTypeRawOpenBSD layers.LinkType = 14
+	// linkTypeLinuxSLL2 is the link type 276 of captures on the any interface of Linux since libpcap 1.10. As gopacket
+	// truncates link types to 8 bits, the readers report it as 20, which is not assigned to any other link type.
+	linkTypeLinuxSLL2 layers.LinkType = 276 & 0xff
+)
+
+// LayerTypeLinuxSLL2 is the layer type of the Linux cooked capture header v2, which gopacket does not know.
+var LayerTypeLinuxSLL2 = gopacket.RegisterLayerType(1276, gopacket.LayerTypeMetadata{Name: "LinuxSLL2", Decoder: gopacket.DecodeFunc(decodeLinuxSLL2)})
+
+// Header lengths of the layers decoded by the engine
+const (
+	linuxSLL2HeaderLen int = 20
+	mplsLabelLen       int = 4
+	pppoeHeaderLen     int = 6
+	pppHeaderLen       int = 2
+)
+
+// pppoeCodeSession is the code of PPPoE session packets, which carry the PPP frames
+const pppoeCodeSession layers.PPPoECode = 0x00
+
+// firstLayerType returns the layer type the fast path starts to decode packets of the link type with or
+// gopacket.LayerTypeZero if the link type is not supported by the fast path
+func firstLayerType(linkType layers.LinkType, packet []byte) gopacket.LayerType {
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		return layers.LayerTypeEthernet
+	case layers.LinkTypeLinuxSLL:
+		return layers.LayerTypeLinuxSLL
+	case linkTypeLinuxSLL2:
+		return LayerTypeLinuxSLL2
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		return layers.LayerTypeLoopback
+	case layers.LinkTypeRaw, linkTypeRawDLT, linkTypeRawOpenBSD, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		return ipLayerType(packet)
+	}
+	return gopacket.LayerTypeZero
+}
+
+// firstDecoder returns the gopacket decoder for packets of the link type, which is used by the compatibility mode
+func firstDecoder(linkType layers.LinkType, packet []byte) gopacket.Decoder {
+	switch linkType {
+	case linkTypeLinuxSLL2:
+		return LayerTypeLinuxSLL2
+	case layers.LinkTypeRaw, linkTypeRawDLT, linkTypeRawOpenBSD, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		return ipLayerType(packet)
+	}
+	return linkType
+}
+
+// ipLayerType returns the layer type of the IP packet by its version
+func ipLayerType(packet []byte) gopacket.LayerType {
+	if len(packet) > 0 && packet[0]>>4 == 6 {
+		return layers.LayerTypeIPv6
+	}
+	return layers.LayerTypeIPv4
+}
+
+// linuxSLL2 is the Linux cooked capture header v2 of captures on the any interface
+type linuxSLL2 struct {
+	layers.BaseLayer
+	// EthernetType of the payload
+	EthernetType layers.EthernetType
+	// InterfaceIndex of the interface the packet was captured on
+	InterfaceIndex uint32
+}
+
+// LayerType returns LayerTypeLinuxSLL2
+func (s *linuxSLL2) LayerType() gopacket.LayerType {
+	return LayerTypeLinuxSLL2
+}
+
+// CanDecode returns LayerTypeLinuxSLL2
+func (s *linuxSLL2) CanDecode() gopacket.LayerClass {
+	return LayerTypeLinuxSLL2
+}
+
+// NextLayerType returns the layer type of the payload
+func (s *linuxSLL2) NextLayerType() gopacket.LayerType {
+	return s.EthernetType.LayerType()
+}
+
+// DecodeFromBytes decodes the header
+func (s *linuxSLL2) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < linuxSLL2HeaderLen {
+		df.SetTruncated()
+		return errors.New("truncated Linux SLL2 header")
+	}
+	s.EthernetType = layers.EthernetType(binary.BigEndian.Uint16(data[0:2]))
+	s.InterfaceIndex = binary.BigEndian.Uint32(data[4:8])
+	s.BaseLayer = layers.BaseLayer{Contents: data[:linuxSLL2HeaderLen], Payload: data[linuxSLL2HeaderLen:]}
+	return nil
+}
+
+// decodeLinuxSLL2 decodes the header for the compatibility mode
+func decodeLinuxSLL2(data []byte, p gopacket.PacketBuilder) error {
+	s := &linuxSLL2{}
+	if err := s.DecodeFromBytes(data, p); err != nil {
+		return err
+	}
+	p.AddLayer(s)
+	return p.NextDecoder(s.EthernetType)
+}
+
+// mpls skips a MPLS label stack, as the MPLS layer of gopacket can not be used with a DecodingLayerParser
+type mpls struct {
+	layers.BaseLayer
+	next gopacket.LayerType
+}
+
+// LayerType returns layers.LayerTypeMPLS
+func (m *mpls) LayerType() gopacket.LayerType {
+	return layers.LayerTypeMPLS
+}
+
+// CanDecode returns layers.LayerTypeMPLS
+func (m *mpls) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeMPLS
+}
+
+// NextLayerType returns the layer type of the payload, which is guessed from the IP version
+func (m *mpls) NextLayerType() gopacket.LayerType {
+	return m.next
+}
+
+// DecodeFromBytes skips all labels up to the one with the bottom of stack bit set
+func (m *mpls) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	for i := 0; ; i += mplsLabelLen {
+		if len(data) < i+mplsLabelLen {
+			df.SetTruncated()
+			return errors.New("truncated MPLS label stack")
+		}
+		if data[i+2]&0x01 == 0 {
+			continue
+		}
+		payload := data[i+mplsLabelLen:]
+		m.BaseLayer = layers.BaseLayer{Contents: data[:i+mplsLabelLen], Payload: payload}
+		switch {
+		case len(payload) > 0 && payload[0]>>4 == 4:
+			m.next = layers.LayerTypeIPv4
+		case len(payload) > 0 && payload[0]>>4 == 6:
+			m.next = layers.LayerTypeIPv6
+		default:
+			m.next = gopacket.LayerTypePayload
+		}
+		return nil
+	}
+}
+
+// pppoe decodes the PPPoE session header together with the PPP header, as the PPPoE and PPP layers of gopacket can not
+// be used with a DecodingLayerParser
+type pppoe struct {
+	layers.BaseLayer
+	PPPType layers.PPPType
+}
+
+// LayerType returns layers.LayerTypePPPoE
+func (p *pppoe) LayerType() gopacket.LayerType {
+	return layers.LayerTypePPPoE
+}
+
+// CanDecode returns layers.LayerTypePPPoE
+func (p *pppoe) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypePPPoE
+}
+
+// NextLayerType returns the layer type of the PPP payload
+func (p *pppoe) NextLayerType() gopacket.LayerType {
+	switch p.PPPType {
+	case layers.PPPTypeIPv4:
+		return layers.LayerTypeIPv4
+	case layers.PPPTypeIPv6:
+		return layers.LayerTypeIPv6
+	case layers.PPPTypeMPLSUnicast, layers.PPPTypeMPLSMulticast:
+		return layers.LayerTypeMPLS
+	}
+	return gopacket.LayerTypePayload
+}
+
+// DecodeFromBytes decodes the PPPoE and PPP headers of a session packet
+func (p *pppoe) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < pppoeHeaderLen+pppHeaderLen {
+		df.SetTruncated()
+		return errors.New("truncated PPPoE header")
+	}
+	if layers.PPPoECode(data[1]) != pppoeCodeSession {
+		return errors.New("PPPoE packet is not a session packet")
+	}
+
+	// The PPPoE length includes the PPP header, anything beyond is Ethernet padding
+	end := pppoeHeaderLen + int(binary.BigEndian.Uint16(data[4:6]))
+	if end < pppoeHeaderLen+pppHeaderLen {
+		return errors.New("PPPoE length too short")
+	} else if end > len(data) {
+		df.SetTruncated()
+		end = len(data)
+	}
+	p.PPPType = layers.PPPType(binary.BigEndian.Uint16(data[6:8]))
+	p.BaseLayer = layers.BaseLayer{Contents: data[:pppoeHeaderLen+pppHeaderLen], Payload: data[pppoeHeaderLen+pppHeaderLen : end]}
+	return nil
+}
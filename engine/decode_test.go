@@ -0,0 +1,152 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"testing"
+)
+
+var (
+	testSrcIPv6 = net.ParseIP("2001:db8::1")
+	testDstIPv6 = net.ParseIP("2001:db8::2")
+)
+
+type linkTypeTestContainer struct {
+	linkType uint32
+	layers   []gopacket.SerializableLayer
+	ipv6     bool
+}
+
+// ipv4TCP returns the IPv4, TCP and payload layers of a packet carrying the Client Hello
+func ipv4TCP() []gopacket.SerializableLayer {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: testSrcIP, DstIP: testDstIP}
+	tcp := &layers.TCP{SrcPort: 34577, DstPort: 443, PSH: true, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return []gopacket.SerializableLayer{ip, tcp, gopacket.Payload(googleClientHello)}
+}
+
+// ipv6TCP returns the IPv6, TCP and payload layers of a packet carrying the Client Hello behind a destination options
+// extension header
+func ipv6TCP() []gopacket.SerializableLayer {
+	ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Destination, SrcIP: testSrcIPv6, DstIP: testDstIPv6}
+	destinationOptions := gopacket.Payload{byte(layers.IPProtocolTCP), 0, 1, 4, 0, 0, 0, 0}
+	tcp := &layers.TCP{SrcPort: 34577, DstPort: 443, PSH: true, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return []gopacket.SerializableLayer{ip, destinationOptions, tcp, gopacket.Payload(googleClientHello)}
+}
+
+// trailer is appended to the packet when it is serialized as outermost layer, e.g. as frame check sequence
+type trailer []byte
+
+func (t trailer) SerializeTo(b gopacket.SerializeBuffer, opts gopacket.SerializeOptions) error {
+	buf, err := b.AppendBytes(len(t))
+	if err != nil {
+		return err
+	}
+	copy(buf, t)
+	return nil
+}
+
+func (t trailer) LayerType() gopacket.LayerType {
+	return gopacket.LayerTypePayload
+}
+
+// withHeaders returns the headers followed by the packet layers
+func withHeaders(packet []gopacket.SerializableLayer, headers ...gopacket.SerializableLayer) []gopacket.SerializableLayer {
+	return append(headers, packet...)
+}
+
+func TestRunLinkTypes(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The Client Hello has to be found on all supported link types and encapsulations in both modes.
+	*/
+	var linkTypeTestSet = map[string]linkTypeTestContainer{
+		"QinQ": {
+			linkType: uint32(layers.LinkTypeEthernet),
+			layers: withHeaders(ipv4TCP(),
+				testEthernet(layers.EthernetTypeQinQ),
+				&layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeDot1Q},
+				&layers.Dot1Q{VLANIdentifier: 20, Type: layers.EthernetTypeIPv4}),
+		},
+		"MPLS": {
+			linkType: uint32(layers.LinkTypeEthernet),
+			layers: withHeaders(ipv4TCP(),
+				testEthernet(layers.EthernetTypeMPLSUnicast),
+				&layers.MPLS{Label: 16, TTL: 64},
+				&layers.MPLS{Label: 17, StackBottom: true, TTL: 64}),
+		},
+		"PPPoE": {
+			linkType: uint32(layers.LinkTypeEthernet),
+			layers: withHeaders(ipv6TCP(),
+				testEthernet(layers.EthernetTypePPPoESession),
+				&layers.PPPoE{Version: 1, Type: 1, Code: layers.PPPoECodeSession, SessionId: 1},
+				&layers.PPP{PPPType: layers.PPPTypeIPv6}),
+			ipv6: true,
+		},
+		"Linux SLL": {
+			linkType: uint32(layers.LinkTypeLinuxSLL),
+			layers: withHeaders(ipv4TCP(),
+				gopacket.Payload{0, 0, 0, 1, 0, 6, 0, 1, 2, 3, 4, 5, 0, 0, 0x08, 0x00}),
+		},
+		"Linux SLL2": {
+			linkType: 276,
+			layers: withHeaders(ipv6TCP(),
+				gopacket.Payload{0x86, 0xdd, 0, 0, 0, 0, 0, 2, 0, 1, 0, 6, 0, 1, 2, 3, 4, 5, 0, 0}),
+			ipv6: true,
+		},
+		"Loopback": {
+			linkType: uint32(layers.LinkTypeNull),
+			layers:   withHeaders(ipv4TCP(), &layers.Loopback{Family: layers.ProtocolFamilyIPv4}),
+		},
+		"Raw IPv4": {
+			linkType: uint32(layers.LinkTypeRaw),
+			layers:   ipv4TCP(),
+		},
+		"Raw IPv6": {
+			linkType: uint32(layers.LinkTypeRaw),
+			layers:   ipv6TCP(),
+			ipv6:     true,
+		},
+		"802.11": {
+			linkType: uint32(layers.LinkTypeIEEE802_11),
+			layers: withHeaders(ipv4TCP(),
+				trailer{0, 0, 0, 0},
+				gopacket.Payload{0x08, 0x01, 0, 0, 0, 1, 2, 3, 4, 6, 0, 1, 2, 3, 4, 5, 0, 1, 2, 3, 4, 6, 0, 0},
+				gopacket.Payload{0xaa, 0xaa, 0x03, 0, 0, 0, 0x08, 0x00}),
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range linkTypeTestSet {
+		for _, compat := range []bool{false, true} {
+			reader := testCaptureLinkType(t, test.linkType, testPacket{test.layers})
+			records := collect(t, &Engine{Compat: compat}, reader)
+			if len(records) != 1 {
+				t.Errorf("%v, compat %v: Expected: %v records but got: %v\n", name, compat, 1, len(records))
+				continue
+			}
+
+			r := records[0]
+			expSrcIP, expDstIP := testSrcIP, testDstIP
+			if test.ipv6 {
+				expSrcIP, expDstIP = testSrcIPv6, testDstIPv6
+			}
+			if !r.SrcIP.Equal(expSrcIP) || !r.DstIP.Equal(expDstIP) || r.SrcPort != 34577 || r.DstPort != 443 {
+				t.Errorf("%v, compat %v: Expected: %v:%v -> %v:%v but got: %v:%v -> %v:%v\n", name, compat,
+					expSrcIP, 34577, expDstIP, 443, r.SrcIP, r.SrcPort, r.DstIP, r.DstPort)
+			}
+			if r.JA3.GetJA3Hash() != googleJA3Hash {
+				t.Errorf("%v, compat %v: Expected: %v but got: %v\n", name, compat, googleJA3Hash, r.JA3.GetJA3Hash())
+			}
+		}
+	}
+}
@@ -0,0 +1,298 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"container/list"
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// Defaults of the IP fragment reassembly, which match the defaults of the Linux kernel
+const (
+	DefaultDefragMemory  = 4 << 20
+	DefaultDefragTimeout = 30 * time.Second
+)
+
+// Limits of the IP fragment reassembly
+const (
+	// maxDatagramLen is the maximum length of a reassembled IP payload
+	maxDatagramLen = 65535
+	// maxFragments is the maximum number of fragments of a datagram, which bounds the cost of the overlap check
+	maxFragments = 64
+)
+
+// ipv6FragmentHeaderLen is the length of the IPv6 fragment extension header
+const ipv6FragmentHeaderLen int = 8
+
+// DefragStats are the counters of the IP fragment reassembly of an engine.
+type DefragStats struct {
+	// Fragments is the number of received IP fragments
+	Fragments uint64
+	// Reassembled is the number of reassembled datagrams
+	Reassembled uint64
+	// Overlapping is the number of fragments overlapping a previously received fragment of their datagram, which
+	// drops the whole datagram
+	Overlapping uint64
+	// Duplicates is the number of identical copies of a previously received fragment, e.g. retransmitted by a
+	// middlebox, which are ignored
+	Duplicates uint64
+	// TimedOut is the number of incomplete datagrams dropped after the timeout
+	TimedOut uint64
+	// Dropped is the number of fragments which were not reassembled, because they were invalid, overlapping, timed out
+	// or exceeded the memory limit
+	Dropped uint64
+}
+
+// defragCounters are the counters of DefragStats, which are updated while the engine is running
+type defragCounters struct {
+	fragments   atomic.Uint64
+	reassembled atomic.Uint64
+	overlapping atomic.Uint64
+	duplicates  atomic.Uint64
+	timedOut    atomic.Uint64
+	dropped     atomic.Uint64
+}
+
+// fragmentKey identifies the datagram a fragment belongs to
+type fragmentKey struct {
+	src, dst [16]byte
+	id       uint32
+	// protocol is only part of the key for IPv4, as IPv6 carries it in the first fragment only
+	protocol layers.IPProtocol
+	ipv6     bool
+}
+
+// fragment is a buffered part of a datagram
+type fragment struct {
+	offset int
+	data   []byte
+}
+
+// datagram holds the received fragments of an incomplete datagram
+type datagram struct {
+	key       fragmentKey
+	fragments []fragment
+	// length of the datagram or -1 until the last fragment has been received
+	length int
+	// end is the highest end of the received fragments
+	end      int
+	received int
+	protocol layers.IPProtocol
+	// first is the capture time of the first received fragment
+	first   time.Time
+	element *list.Element
+}
+
+// defragmenter reassembles fragmented IPv4 and IPv6 datagrams. The memory used to buffer fragments is bounded, the
+// oldest datagrams are dropped to make room for new fragments. Incomplete datagrams are dropped after the timeout,
+// which is measured in capture time, so that reading a capture file gives the same result as capturing it live.
+type defragmenter struct {
+	memory  int
+	timeout time.Duration
+	used    int
+	// datagrams by key and ordered by the time of their first fragment, oldest first
+	datagrams map[fragmentKey]*datagram
+	order     list.List
+	// buf holds the last reassembled datagram
+	buf   []byte
+	stats *defragCounters
+}
+
+// newDefragmenter returns a defragmenter with the memory limit in bytes and the timeout, zero values select the
+// defaults. It returns nil, which does not reassemble any datagram, if memory is negative.
+func newDefragmenter(memory int, timeout time.Duration, stats *defragCounters) *defragmenter {
+	if memory < 0 {
+		return nil
+	}
+	if memory == 0 {
+		memory = DefaultDefragMemory
+	}
+	if timeout <= 0 {
+		timeout = DefaultDefragTimeout
+	}
+	return &defragmenter{
+		memory:    memory,
+		timeout:   timeout,
+		datagrams: make(map[fragmentKey]*datagram),
+		stats:     stats,
+	}
+}
+
+// ipv4 adds the payload of the IPv4 fragment and returns the reassembled payload and its protocol once the datagram is
+// complete. The returned payload is only valid until the next call.
+func (f *defragmenter) ipv4(ip *layers.IPv4, timestamp time.Time) ([]byte, layers.IPProtocol, bool) {
+	key := fragmentKey{id: uint32(ip.Id), protocol: ip.Protocol}
+	copy(key.src[:], ip.SrcIP)
+	copy(key.dst[:], ip.DstIP)
+	return f.add(key, int(ip.FragOffset)*8, ip.Flags&layers.IPv4MoreFragments != 0, ip.Protocol, ip.Payload, timestamp)
+}
+
+// ipv6 is the same as ipv4 for the fragment header following the IPv6 header
+func (f *defragmenter) ipv6(ip *layers.IPv6, offset uint16, more bool, id uint32, protocol layers.IPProtocol,
+	payload []byte, timestamp time.Time) ([]byte, layers.IPProtocol, bool) {
+	key := fragmentKey{id: id, ipv6: true}
+	copy(key.src[:], ip.SrcIP)
+	copy(key.dst[:], ip.DstIP)
+	return f.add(key, int(offset)*8, more, protocol, payload, timestamp)
+}
+
+// add a fragment at the offset in bytes to its datagram and return the reassembled payload once it is complete
+func (f *defragmenter) add(key fragmentKey, offset int, more bool, protocol layers.IPProtocol, data []byte,
+	timestamp time.Time) ([]byte, layers.IPProtocol, bool) {
+	// Atomic fragments are complete datagrams (RFC 6946)
+	if offset == 0 && !more {
+		return data, protocol, true
+	}
+	if f == nil {
+		return nil, 0, false
+	}
+	f.stats.fragments.Add(1)
+	f.expire(timestamp)
+
+	// All fragments but the last one have to carry a multiple of 8 bytes
+	end := offset + len(data)
+	if len(data) == 0 || end > maxDatagramLen || (more && len(data)%8 != 0) {
+		f.stats.dropped.Add(1)
+		return nil, 0, false
+	}
+
+	dg, ok := f.datagrams[key]
+	if !ok {
+		dg = &datagram{key: key, length: -1, first: timestamp}
+		dg.element = f.order.PushBack(dg)
+		f.datagrams[key] = dg
+	}
+
+	// Check the fragment against the length of the datagram and the fragments received so far
+	if (!more && ((dg.length >= 0 && dg.length != end) || dg.end > end)) || (more && dg.length >= 0 && end >= dg.length) {
+		f.drop(dg, 1)
+		return nil, 0, false
+	}
+	for _, frag := range dg.fragments {
+		if offset < frag.offset+len(frag.data) && frag.offset < end {
+			// Identical copies of a fragment do not change the datagram
+			if offset == frag.offset && bytes.Equal(data, frag.data) {
+				f.stats.duplicates.Add(1)
+				return nil, 0, false
+			}
+			f.stats.overlapping.Add(1)
+			f.drop(dg, 1)
+			return nil, 0, false
+		}
+	}
+	if len(dg.fragments) == maxFragments {
+		f.drop(dg, 1)
+		return nil, 0, false
+	}
+
+	// Make room by dropping the oldest datagrams
+	for f.used+len(data) > f.memory && f.order.Front().Value.(*datagram) != dg {
+		f.drop(f.order.Front().Value.(*datagram), 0)
+	}
+	if f.used+len(data) > f.memory {
+		f.drop(dg, 1)
+		return nil, 0, false
+	}
+
+	dg.fragments = append(dg.fragments, fragment{offset: offset, data: append([]byte(nil), data...)})
+	dg.received += len(data)
+	f.used += len(data)
+	if !more {
+		dg.length = end
+	}
+	if end > dg.end {
+		dg.end = end
+	}
+	if offset == 0 {
+		dg.protocol = protocol
+	}
+	if dg.length < 0 || dg.received != dg.length {
+		return nil, 0, false
+	}
+
+	// As the fragments do not overlap and cover the whole length, they are contiguous when sorted
+	sort.Slice(dg.fragments, func(a, b int) bool {
+		return dg.fragments[a].offset < dg.fragments[b].offset
+	})
+	f.buf = f.buf[:0]
+	for _, frag := range dg.fragments {
+		f.buf = append(f.buf, frag.data...)
+	}
+	f.remove(dg)
+	f.stats.reassembled.Add(1)
+	return f.buf, dg.protocol, true
+}
+
+// expire drops the datagrams whose first fragment is older than the timeout
+func (f *defragmenter) expire(timestamp time.Time) {
+	for e := f.order.Front(); e != nil; e = f.order.Front() {
+		dg := e.Value.(*datagram)
+		if timestamp.Sub(dg.first) <= f.timeout {
+			return
+		}
+		f.stats.timedOut.Add(1)
+		f.drop(dg, 0)
+	}
+}
+
+// drop the datagram and count its buffered fragments and the given number of not yet buffered ones as dropped
+func (f *defragmenter) drop(dg *datagram, pending int) {
+	f.stats.dropped.Add(uint64(len(dg.fragments) + pending))
+	f.remove(dg)
+}
+
+// remove the datagram and release its memory
+func (f *defragmenter) remove(dg *datagram) {
+	f.used -= dg.received
+	f.order.Remove(dg.element)
+	delete(f.datagrams, dg.key)
+}
+
+// ipv6Fragment decodes the IPv6 fragment extension header, as the IPv6Fragment layer of gopacket is no DecodingLayer
+type ipv6Fragment struct {
+	layers.BaseLayer
+	NextHeader     layers.IPProtocol
+	FragmentOffset uint16
+	MoreFragments  bool
+	Identification uint32
+}
+
+// LayerType returns layers.LayerTypeIPv6Fragment
+func (i *ipv6Fragment) LayerType() gopacket.LayerType {
+	return layers.LayerTypeIPv6Fragment
+}
+
+// CanDecode returns layers.LayerTypeIPv6Fragment
+func (i *ipv6Fragment) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeIPv6Fragment
+}
+
+// NextLayerType returns gopacket.LayerTypeFragment, as the payload has to be reassembled first
+func (i *ipv6Fragment) NextLayerType() gopacket.LayerType {
+	return gopacket.LayerTypeFragment
+}
+
+// DecodeFromBytes decodes the fragment header
+func (i *ipv6Fragment) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < ipv6FragmentHeaderLen {
+		df.SetTruncated()
+		return errors.New("truncated IPv6 fragment header")
+	}
+	i.NextHeader = layers.IPProtocol(data[0])
+	i.FragmentOffset = binary.BigEndian.Uint16(data[2:4]) >> 3
+	i.MoreFragments = data[3]&0x1 != 0
+	i.Identification = binary.BigEndian.Uint32(data[4:8])
+	i.BaseLayer = layers.BaseLayer{Contents: data[:ipv6FragmentHeaderLen], Payload: data[ipv6FragmentHeaderLen:]}
+	return nil
+}
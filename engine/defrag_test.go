@@ -0,0 +1,175 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"testing"
+	"time"
+)
+
+// fragmentSize is the number of payload bytes carried by the fragments in the tests
+const fragmentSize = 64
+
+type defragTestContainer struct {
+	packets       []testPacket
+	defragMemory  int
+	defragTimeout time.Duration
+	expRecords    int
+	expStats      DefragStats
+}
+
+// tcpSegment returns the serialized TCP segment carrying the Client Hello, with the checksum of the tuple of ip
+func tcpSegment(t *testing.T, ip gopacket.NetworkLayer) []byte {
+	tcp := &layers.TCP{SrcPort: 34577, DstPort: 443, PSH: true, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	buf := gopacket.NewSerializeBuffer()
+	err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, tcp, gopacket.Payload(googleClientHello))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// ipv4Fragments splits the TCP segment with the Client Hello into IPv4 fragments and returns the fragments at the
+// given indices in that order, where a negative index repeats the fragment shifted by 8 bytes
+func ipv4Fragments(t *testing.T, id uint16, indices ...int) []testPacket {
+	segment := tcpSegment(t, &layers.IPv4{Version: 4, Protocol: layers.IPProtocolTCP, SrcIP: testSrcIP, DstIP: testDstIP})
+	var packets []testPacket
+	for _, i := range indices {
+		offset, data, more := fragmentAt(segment, i)
+		ip := &layers.IPv4{Version: 4, TTL: 64, Id: id, FragOffset: uint16(offset / 8), Protocol: layers.IPProtocolTCP,
+			SrcIP: testSrcIP, DstIP: testDstIP}
+		if more {
+			ip.Flags = layers.IPv4MoreFragments
+		}
+		packets = append(packets, testPacket{[]gopacket.SerializableLayer{testEthernet(layers.EthernetTypeIPv4), ip, gopacket.Payload(data)}})
+	}
+	return packets
+}
+
+// ipv6Fragments is the same as ipv4Fragments for IPv6
+func ipv6Fragments(t *testing.T, id uint32, indices ...int) []testPacket {
+	segment := tcpSegment(t, &layers.IPv6{Version: 6, NextHeader: layers.IPProtocolTCP, SrcIP: testSrcIPv6, DstIP: testDstIPv6})
+	var packets []testPacket
+	for _, i := range indices {
+		offset, data, more := fragmentAt(segment, i)
+		ip := &layers.IPv6{Version: 6, HopLimit: 64, NextHeader: layers.IPProtocolIPv6Fragment, SrcIP: testSrcIPv6, DstIP: testDstIPv6}
+		header := gopacket.Payload{byte(layers.IPProtocolTCP), 0, byte(offset >> 8), byte(offset), byte(id >> 24), byte(id >> 16), byte(id >> 8), byte(id)}
+		if more {
+			header[3] |= 1
+		}
+		packets = append(packets, testPacket{[]gopacket.SerializableLayer{testEthernet(layers.EthernetTypeIPv6), ip, header, gopacket.Payload(data)}})
+	}
+	return packets
+}
+
+// fragmentAt returns the offset and data of the fragment at index i of the segment and whether more fragments follow
+func fragmentAt(segment []byte, i int) (int, []byte, bool) {
+	offset := i * fragmentSize
+	if i < 0 {
+		offset = -i*fragmentSize + 8
+	}
+	end := offset + fragmentSize
+	if end > len(segment) {
+		end = len(segment)
+	}
+	return offset, segment[offset:end], end < len(segment)
+}
+
+// concat returns the packets of all slices in order
+func concat(packets ...[]testPacket) []testPacket {
+	var all []testPacket
+	for _, p := range packets {
+		all = append(all, p...)
+	}
+	return all
+}
+
+func TestRunDefrag(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The TCP segment with the Client Hello is split into 4 fragments of at most 64 bytes. It has to be
+		found once all fragments arrived in any order, unless the reassembly fails.
+	*/
+	var defragTestSet = map[string]defragTestContainer{
+		"IPv4 in order": {
+			packets:    ipv4Fragments(t, 1, 0, 1, 2, 3),
+			expRecords: 1,
+			expStats:   DefragStats{Fragments: 4, Reassembled: 1},
+		},
+		"IPv4 out of order": {
+			packets:    ipv4Fragments(t, 1, 3, 1, 0, 2),
+			expRecords: 1,
+			expStats:   DefragStats{Fragments: 4, Reassembled: 1},
+		},
+		"IPv6 out of order": {
+			packets:    ipv6Fragments(t, 1, 2, 3, 0, 1),
+			expRecords: 1,
+			expStats:   DefragStats{Fragments: 4, Reassembled: 1},
+		},
+		"Interleaved datagrams": {
+			packets:    concat(ipv4Fragments(t, 1, 0, 1), ipv6Fragments(t, 1, 0, 1, 2), ipv4Fragments(t, 1, 2, 3), ipv6Fragments(t, 1, 3)),
+			expRecords: 2,
+			expStats:   DefragStats{Fragments: 8, Reassembled: 2},
+		},
+		"Overlapping": {
+			packets:  ipv4Fragments(t, 1, 0, 1, -1, 2, 3),
+			expStats: DefragStats{Fragments: 5, Overlapping: 1, Dropped: 3},
+		},
+		"IPv4 duplicate fragment": {
+			packets:    ipv4Fragments(t, 1, 0, 1, 1, 2, 3),
+			expRecords: 1,
+			expStats:   DefragStats{Fragments: 5, Reassembled: 1, Duplicates: 1},
+		},
+		"IPv6 duplicate last fragment": {
+			packets:    ipv6Fragments(t, 1, 3, 0, 3, 1, 2),
+			expRecords: 1,
+			expStats:   DefragStats{Fragments: 5, Reassembled: 1, Duplicates: 1},
+		},
+		"Incomplete": {
+			packets:  ipv4Fragments(t, 1, 0, 1, 3),
+			expStats: DefragStats{Fragments: 3},
+		},
+		"Timeout": {
+			packets:       ipv4Fragments(t, 1, 0, 1, 2, 3),
+			defragTimeout: 2 * time.Millisecond,
+			expStats:      DefragStats{Fragments: 4, TimedOut: 1, Dropped: 3},
+		},
+		"Memory limit": {
+			packets:      concat(ipv4Fragments(t, 1, 0, 1), ipv4Fragments(t, 2, 0, 1, 2, 3)),
+			defragMemory: 4 * fragmentSize,
+			expRecords:   1,
+			expStats:     DefragStats{Fragments: 6, Reassembled: 1, Dropped: 2},
+		},
+		"Disabled": {
+			packets:      ipv4Fragments(t, 1, 0, 1, 2, 3),
+			defragMemory: -1,
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range defragTestSet {
+		for _, compat := range []bool{false, true} {
+			e := &Engine{Compat: compat, DefragMemory: test.defragMemory, DefragTimeout: test.defragTimeout}
+			records := collect(t, e, testCapture(t, layers.LinkTypeEthernet, test.packets...))
+			if len(records) != test.expRecords {
+				t.Errorf("%v, compat %v: Expected: %v records but got: %v\n", name, compat, test.expRecords, len(records))
+			}
+			for _, r := range records {
+				if r.JA3.GetJA3Hash() != googleJA3Hash || r.SrcPort != 34577 || r.DstPort != 443 {
+					t.Errorf("%v, compat %v: Expected: %v but got: %v\n", name, compat, googleJA3Hash, r.JA3.GetJA3Hash())
+				}
+			}
+			if stats := e.DefragStats(); stats != test.expStats {
+				t.Errorf("%v, compat %v: Expected: %+v but got: %+v\n", name, compat, test.expStats, stats)
+			}
+		}
+	}
+}
@@ -0,0 +1,498 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+// Package engine decodes captured packets and computes the JA3 fingerprints of the Client Hellos found in them. It is
+// the engine of the ja3exporter command line tool and can be embedded into other Go programs.
+package engine
+
+import (
+	"context"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/open-ch/ja3"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+// Flow is the tuple of the TCP segment a Client Hello was found in.
+type Flow struct {
+	SrcIP   net.IP
+	DstIP   net.IP
+	SrcPort uint16
+	DstPort uint16
+}
+
+// Record describes a Client Hello found by the engine.
+type Record struct {
+	Flow
+	// Timestamp of the packet
+	Timestamp time.Time
+	// InterfaceIndex of the interface the packet was captured on
+	InterfaceIndex int
+	// Interface is the name of the interface the packet was captured on or empty if unknown
+	Interface string
+	// Tunnels the Client Hello was encapsulated in from the outermost to the innermost, the Flow of the record is the
+	// tuple of the innermost flow
+	Tunnels []Tunnel
+	// JA3 of the Client Hello
+	JA3 *ja3.JA3
+	// Payload of the TCP segment containing the Client Hello
+	Payload []byte
+	// Session describes the handshake the Client Hello started, it is only set by engines tracking sessions
+	Session *Session
+}
+
+// Clone returns a deep copy of the record, which does not reference any buffers of the engine.
+func (r Record) Clone() Record {
+	r.SrcIP = append(net.IP(nil), r.SrcIP...)
+	r.DstIP = append(net.IP(nil), r.DstIP...)
+	r.Payload = append([]byte(nil), r.Payload...)
+	if r.Tunnels != nil {
+		tunnels := make([]Tunnel, len(r.Tunnels))
+		for i, t := range r.Tunnels {
+			t.SrcIP = append(net.IP(nil), t.SrcIP...)
+			t.DstIP = append(net.IP(nil), t.DstIP...)
+			tunnels[i] = t
+		}
+		r.Tunnels = tunnels
+	}
+	if r.Session != nil {
+		session := *r.Session
+		r.Session = &session
+	}
+	// Parse the copied payload again, so the JA3 object references the copy
+	r.JA3, _ = ja3.ComputeJA3FromSegment(r.Payload)
+	return r
+}
+
+// Engine decodes packets and computes the JA3 fingerprints of the Client Hellos found in their TCP payloads. An Engine
+// must not be used by multiple goroutines at the same time.
+type Engine struct {
+	// Compat enables the compatibility mode, which supports any protocol that is supported by the gopacket library.
+	// It is much slower than the default mode, which only supports Ethernet, Linux cooked capture, loopback and raw IP
+	// links with VLAN, MPLS, PPPoE and IPv6 extension headers as well as GRE, ERSPAN, VXLAN, GENEVE, IP-in-IP and
+	// GTP-U tunnels, and therefore should not be used unless needed. Packets of other link types, e.g. 802.11, are
+	// decoded as in compatibility mode.
+	Compat bool
+	// DefragMemory limits the memory in bytes used to buffer IP fragments for reassembly, the oldest incomplete
+	// datagrams are dropped when it is exceeded. Zero selects DefaultDefragMemory, a negative value disables the
+	// reassembly.
+	DefragMemory int
+	// DefragTimeout after which incomplete datagrams are dropped, measured in capture time. Zero selects
+	// DefaultDefragTimeout.
+	DefragTimeout time.Duration
+	// Filter selects the packets to decode, it is either DefaultFilter or a BPF expression in tcpdump syntax, which
+	// needs libpcap to be compiled. Readers capturing from an interface apply the filter in the kernel, for the other
+	// readers the engine runs it before decoding a packet. An empty filter passes all packets.
+	Filter string
+	// Sessions enables the tracking of the TLS sessions, which passes the record of a Client Hello to the handler
+	// once the handshake it started completed or ended, with the Server Hello and the outcome of the handshake in its
+	// Session. With DefaultFilter, the filter passes the TLS records and TCP segments needed to follow the handshakes.
+	Sessions bool
+	// SessionTimeout after which sessions without packets end as incomplete, measured in capture time. Zero selects
+	// DefaultSessionTimeout.
+	SessionTimeout time.Duration
+	// MaxSessions limits the number of sessions tracked at the same time, the sessions without packets for the
+	// longest time end as incomplete when it is exceeded. Zero selects DefaultMaxSessions.
+	MaxSessions int
+
+	parser      ja3.Parser
+	j           ja3.JA3
+	defragStats defragCounters
+	decodeStats decodeCounters
+}
+
+// DecodeStats are the counters of the packets decoded by an engine.
+type DecodeStats struct {
+	// Packets is the number of packets passed to the engine, including the packets dropped by its filter
+	Packets uint64
+	// ClientHellos is the number of Client Hellos found
+	ClientHellos uint64
+	// ParseFailures is the number of TCP segments starting with a TLS handshake record which could not be parsed
+	// as a Client Hello, other handshake messages are not counted
+	ParseFailures uint64
+	// ParseFailuresByKind splits the parse failures by the kind of their ja3.ParseError
+	ParseFailuresByKind ParseFailureKinds
+}
+
+// ParseFailureKinds are the counters of the parse failures by the kind of their ja3.ParseError.
+type ParseFailureKinds struct {
+	// UnsupportedVersion counts the failures with ja3.ErrUnsupportedVersion
+	UnsupportedVersion uint64
+	// Truncated counts the failures with ja3.ErrTruncated
+	Truncated uint64
+	// Malformed counts the failures with ja3.ErrMalformed
+	Malformed uint64
+	// UnsupportedSNIType counts the failures with ja3.ErrUnsupportedSNIType
+	UnsupportedSNIType uint64
+}
+
+// decodeCounters are the counters of DecodeStats, which are updated while the engine is running
+type decodeCounters struct {
+	packets            atomic.Uint64
+	clientHellos       atomic.Uint64
+	parseFailures      atomic.Uint64
+	unsupportedVersion atomic.Uint64
+	truncated          atomic.Uint64
+	malformed          atomic.Uint64
+	unsupportedSNIType atomic.Uint64
+}
+
+// countParseFailure counts the error of a segment starting with a handshake record
+func (c *decodeCounters) countParseFailure(err error) {
+	c.parseFailures.Add(1)
+	switch {
+	case errors.Is(err, ja3.ErrUnsupportedVersion):
+		c.unsupportedVersion.Add(1)
+	case errors.Is(err, ja3.ErrTruncated):
+		c.truncated.Add(1)
+	case errors.Is(err, ja3.ErrMalformed):
+		c.malformed.Add(1)
+	case errors.Is(err, ja3.ErrUnsupportedSNIType):
+		c.unsupportedSNIType.Add(1)
+	}
+}
+
+// DecodeStats returns the counters of the decoded packets accumulated over all runs of the engine. It may be called
+// while the engine is running.
+func (e *Engine) DecodeStats() DecodeStats {
+	return DecodeStats{
+		Packets:       e.decodeStats.packets.Load(),
+		ClientHellos:  e.decodeStats.clientHellos.Load(),
+		ParseFailures: e.decodeStats.parseFailures.Load(),
+		ParseFailuresByKind: ParseFailureKinds{
+			UnsupportedVersion: e.decodeStats.unsupportedVersion.Load(),
+			Truncated:          e.decodeStats.truncated.Load(),
+			Malformed:          e.decodeStats.malformed.Load(),
+			UnsupportedSNIType: e.decodeStats.unsupportedSNIType.Load(),
+		},
+	}
+}
+
+// DefragStats returns the counters of the IP fragment reassembly accumulated over all runs of the engine. It may be
+// called while the engine is running.
+func (e *Engine) DefragStats() DefragStats {
+	return DefragStats{
+		Fragments:   e.defragStats.fragments.Load(),
+		Reassembled: e.defragStats.reassembled.Load(),
+		Overlapping: e.defragStats.overlapping.Load(),
+		Duplicates:  e.defragStats.duplicates.Load(),
+		TimedOut:    e.defragStats.timedOut.Load(),
+		Dropped:     e.defragStats.dropped.Load(),
+	}
+}
+
+// Run reads from reader until an io.EOF error is encountered or the context is done and calls handler for every Client
+// Hello found. The packets are decoded according to the link type of the reader, readers which do not report their link
+// type are assumed to deliver Ethernet frames. To avoid allocations, the record passed to handler references buffers of
+// the engine and the reader, which are only valid until handler returns. Use Record.Clone to keep a record. If the
+// engine tracks sessions, the sessions still open when it stops are passed to handler as incomplete.
+func (e *Engine) Run(ctx context.Context, reader Reader, handler func(Record)) error {
+	src := newSource(reader)
+	filter, err := e.installFilter(reader)
+	if err != nil {
+		return err
+	}
+	d := e.newDecoder(filter)
+
+	for {
+		// Check if we have to stop
+		select {
+		case <-ctx.Done():
+			d.flushSessions(handler)
+			return ctx.Err()
+		default:
+		}
+
+		// Read packet data
+		packet, ci, err := reader.ZeroCopyReadPacketData()
+		if err == io.EOF {
+			d.flushSessions(handler)
+			break
+		} else if isTimeout(err) {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		err = e.handle(d, packet, ci, src.packetLinkType(ci.InterfaceIndex), src.interfaceName(ci.InterfaceIndex), handler)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// installFilter applies the filter of the engine to the reader if the reader filters itself, otherwise it returns the
+// filter the engine has to run
+func (e *Engine) installFilter(reader Reader) (*packetFilter, error) {
+	if e.Filter == "" {
+		return nil, nil
+	}
+	if fs, ok := reader.(filterSetter); ok {
+		return nil, fs.setFilter(e.filterExpression())
+	}
+	return newPacketFilter(e.filterExpression()), nil
+}
+
+// filterExpression returns the filter of the engine, DefaultFilter is extended to pass the packets needed to track
+// the sessions
+func (e *Engine) filterExpression() string {
+	if e.Sessions && e.Filter == DefaultFilter {
+		return defaultSessionsFilter
+	}
+	return e.Filter
+}
+
+// newDecoder returns a decoder running the filter, which may be nil, before decoding the packets
+func (e *Engine) newDecoder(filter *packetFilter) *decoder {
+	d := &decoder{
+		filter: filter,
+		defrag: newDefragmenter(e.DefragMemory, e.DefragTimeout, &e.defragStats),
+	}
+	if e.Sessions {
+		d.sessions = newSessionTable(e.SessionTimeout, e.MaxSessions)
+	}
+	return d
+}
+
+// handle decodes the packet of the link type captured on the named interface and calls handler if it contains a
+// Client Hello
+func (e *Engine) handle(d *decoder, packet []byte, ci gopacket.CaptureInfo, linkType layers.LinkType, intf string,
+	handler func(Record)) error {
+	e.decodeStats.packets.Add(1)
+	if d.sessions != nil {
+		d.sessions.expire(ci.Timestamp, handler)
+	}
+
+	// Skip packets not passing the filter of the engine
+	if d.filter != nil {
+		if ok, err := d.filter.matches(linkType, packet); err != nil || !ok {
+			return err
+		}
+	}
+
+	// Prepare capture info for the record
+	record := Record{
+		Timestamp:      ci.Timestamp,
+		InterfaceIndex: ci.InterfaceIndex,
+		Interface:      intf,
+	}
+
+	// Fall back to the full gopacket decoders for link types not supported by the fast path
+	if first := firstLayerType(linkType, packet); !e.Compat && first != gopacket.LayerTypeZero {
+		e.handleFast(d, first, packet, record, handler)
+	} else {
+		e.handleCompat(d, linkType, packet, record, handler)
+	}
+	return nil
+}
+
+// decoder holds the layers of the fast path, which only decodes the needed layers
+type decoder struct {
+	ethernet layers.Ethernet
+	dot1q    layers.Dot1Q
+	mpls     mpls
+	pppoe    pppoe
+	sll      layers.LinuxSLL
+	sll2     linuxSLL2
+	loopback layers.Loopback
+	ipv4     layers.IPv4
+	ipv6     layers.IPv6
+	ipv6ext  layers.IPv6ExtensionSkipper
+	ipv6frag ipv6Fragment
+	udp      layers.UDP
+	gre      layers.GRE
+	erspan   layers.ERSPANII
+	vxlan    layers.VXLAN
+	geneve   geneve
+	gtpu     gtpu
+	tcp      layers.TCP
+	// layers by the layer type they decode
+	layers   gopacket.DecodingLayerContainer
+	tracker  tracker
+	defrag   *defragmenter
+	filter   *packetFilter
+	sessions *sessionTable
+}
+
+// flushSessions passes the sessions still open to handler as incomplete
+func (d *decoder) flushSessions(handler func(Record)) {
+	if d.sessions != nil {
+		d.sessions.flush(handler)
+	}
+}
+
+// decode the packet starting with the first layer type up to the TCP layer and keep track of the flow and the
+// tunnels on the way. Fragmented datagrams are decoded further once they are reassembled. It reports whether the TCP
+// layer was reached.
+func (d *decoder) decode(first gopacket.LayerType, packet []byte, timestamp time.Time) (ok bool) {
+	if d.layers == nil {
+		var container gopacket.DecodingLayerContainer = gopacket.DecodingLayerSparse(nil)
+		for _, l := range []gopacket.DecodingLayer{&d.ethernet, &d.dot1q, &d.mpls, &d.pppoe, &d.sll, &d.sll2, &d.loopback,
+			&d.ipv4, &d.ipv6, &d.ipv6ext, &d.ipv6frag, &d.udp, &d.gre, &d.erspan, &d.vxlan, &d.geneve, &d.gtpu, &d.tcp} {
+			container = container.Put(l)
+		}
+		d.layers = container
+	}
+
+	// Malformed packets can make the gopacket layers panic
+	defer func() {
+		if recover() != nil {
+			ok = false
+		}
+	}()
+
+	d.tracker.reset()
+	data := packet
+	for layerType := first; ; {
+		layer, found := d.layers.Decoder(layerType)
+		if !found || layer.DecodeFromBytes(data, gopacket.NilDecodeFeedback) != nil {
+			return false
+		}
+		// The IPv6 extension skipper is no gopacket.Layer, but it is of no interest to the tracker anyway
+		if l, isLayer := layer.(gopacket.Layer); isLayer {
+			d.tracker.observe(l)
+		}
+		if layerType == layers.LayerTypeTCP {
+			return true
+		}
+		data = layer.LayerPayload()
+		layerType = layer.NextLayerType()
+		if layerType == gopacket.LayerTypeFragment {
+			var protocol layers.IPProtocol
+			var complete bool
+			if layer == &d.ipv4 {
+				data, protocol, complete = d.defrag.ipv4(&d.ipv4, timestamp)
+			} else {
+				data, protocol, complete = d.defrag.ipv6(&d.ipv6, d.ipv6frag.FragmentOffset, d.ipv6frag.MoreFragments,
+					d.ipv6frag.Identification, d.ipv6frag.NextHeader, d.ipv6frag.Payload, timestamp)
+			}
+			if !complete {
+				return false
+			}
+			layerType = protocol.LayerType()
+		}
+	}
+}
+
+// handleFast decodes the packet with the selective decoder and calls handler if it contains a Client Hello
+func (e *Engine) handleFast(d *decoder, first gopacket.LayerType, packet []byte, record Record, handler func(Record)) {
+	// Check if we could decode up to the TCP layer
+	if !d.decode(first, packet, record.Timestamp) {
+		return
+	}
+	e.handleTCP(d, &d.tcp, record, handler)
+}
+
+// handleTCP calls handler if the TCP segment decoded by the tracker of the decoder contains a Client Hello or, if
+// sessions are tracked, once the segment ended the handshake of a session
+func (e *Engine) handleTCP(d *decoder, tcp *layers.TCP, record Record, handler func(Record)) {
+	record.Flow = d.tracker.flow
+
+	// Check if the parsing was successful, else segment is no Client Hello
+	if !e.parse(tcp.Payload) {
+		if d.sessions != nil {
+			d.sessions.segment(record.Flow, tcp, record.Timestamp, handler)
+		}
+		return
+	}
+
+	if len(d.tracker.tunnels) > 0 {
+		record.Tunnels = d.tracker.tunnels
+	}
+	record.JA3 = &e.j
+	record.Payload = tcp.Payload
+	if d.sessions != nil {
+		d.sessions.clientHello(record.Clone(), handler)
+		return
+	}
+	handler(record)
+}
+
+// parse the TCP payload into the JA3 object of the engine and report whether it is a Client Hello
+func (e *Engine) parse(payload []byte) bool {
+	_, err := e.parser.ParseInto(&e.j, payload)
+	if err == nil {
+		e.decodeStats.clientHellos.Add(1)
+		return true
+	}
+	// Most segments are no handshake records at all, which is no failure
+	if len(payload) > 0 && payload[0] == byte(tlsRecordHandshake) && !errors.Is(err, ja3.ErrNotClientHello) {
+		e.decodeStats.countParseFailure(err)
+	}
+	return false
+}
+
+// handleCompat has the same functionality as handleFast but decodes the packet with the full gopacket decoders
+func (e *Engine) handleCompat(d *decoder, linkType layers.LinkType, packetData []byte, record Record, handler func(Record)) {
+	options := gopacket.DecodeOptions{NoCopy: true, Lazy: true}
+	packet := gopacket.NewPacket(packetData, firstDecoder(linkType, packetData), options)
+
+	t := &d.tracker
+	t.reset()
+	var tcp *layers.TCP
+	var ipv6 *layers.IPv6
+	packetLayers := packet.Layers()
+	for i := 0; i < len(packetLayers) && tcp == nil; i++ {
+		layer := packetLayers[i]
+		t.observe(layer)
+
+		var data []byte
+		var protocol layers.IPProtocol
+		var fragmented, complete bool
+		switch l := layer.(type) {
+		case *layers.TCP:
+			tcp = l
+		case *layers.IPv4:
+			if l.NextLayerType() == gopacket.LayerTypeFragment {
+				data, protocol, complete = d.defrag.ipv4(l, record.Timestamp)
+				fragmented = true
+			}
+		case *layers.IPv6:
+			ipv6 = l
+		case *layers.IPv6Fragment:
+			data, protocol, complete = d.defrag.ipv6(ipv6, l.FragmentOffset, l.MoreFragments, l.Identification,
+				l.NextHeader, l.Payload, record.Timestamp)
+			fragmented = true
+		}
+
+		// Continue with the layers of the reassembled datagram
+		if fragmented {
+			if !complete {
+				return
+			}
+			packetLayers = gopacket.NewPacket(data, protocol.LayerType(), options).Layers()
+			i = -1
+		}
+	}
+	if tcp == nil {
+		return
+	}
+	e.handleTCP(d, tcp, record, handler)
+}
+
+// Records runs the engine on reader in a new goroutine and sends a clone of every record on the returned channel. The
+// record channel is closed when the engine stops, afterwards the error channel delivers the result of Run.
+func (e *Engine) Records(ctx context.Context, reader Reader) (<-chan Record, <-chan error) {
+	records := make(chan Record, 64)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(errc)
+		err := e.Run(ctx, reader, func(r Record) {
+			select {
+			case records <- r.Clone():
+			case <-ctx.Done():
+			}
+		})
+		close(records)
+		errc <- err
+	}()
+	return records, errc
+}
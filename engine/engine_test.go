@@ -0,0 +1,202 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"net"
+	"testing"
+	"time"
+)
+
+// googleClientHello is a real Client Hello segment
+var googleClientHello = []byte{22, 3, 1, 0, 201, 1, 0, 0, 197, 3, 3, 82, 50, 235, 232, 231, 181, 243, 122, 13, 113, 213, 238, 184, 242, 230, 164, 189, 148, 5, 55, 17, 170, 189, 193, 212, 189, 211, 11, 239, 192, 39, 240, 0, 0, 36, 192, 48, 192, 44, 192, 47, 192, 43, 192, 20, 192, 10, 192, 19, 192, 9, 0, 159, 0, 158, 0, 57, 0, 51, 0, 157, 0, 156, 0, 53, 0, 47, 0, 10, 0, 255, 1, 0, 0, 120, 0, 0, 0, 18, 0, 16, 0, 0, 13, 119, 119, 119, 46, 103, 111, 111, 103, 108, 101, 46, 99, 104, 0, 11, 0, 4, 3, 0, 1, 2, 0, 10, 0, 28, 0, 26, 0, 23, 0, 25, 0, 28, 0, 27, 0, 24, 0, 26, 0, 22, 0, 14, 0, 13, 0, 11, 0, 12, 0, 9, 0, 10, 0, 35, 0, 0, 0, 13, 0, 32, 0, 30, 6, 1, 6, 2, 6, 3, 5, 1, 5, 2, 5, 3, 4, 1, 4, 2, 4, 3, 3, 1, 3, 2, 3, 3, 2, 1, 2, 2, 2, 3, 0, 5, 0, 5, 1, 0, 0, 0, 0, 0, 15, 0, 1, 1, 51, 116, 0, 0}
+
+const googleJA3Hash = "5e647d60a56d199388ae462b75b3cdad"
+
+var (
+	testSrcIP = net.IP{213, 156, 236, 180}
+	testDstIP = net.IP{172, 217, 168, 67}
+	testTime  = time.Unix(1537516825, 571014000).UTC()
+)
+
+// testPacket is a packet to be written to a test capture
+type testPacket struct {
+	layers []gopacket.SerializableLayer
+}
+
+// tcpPacket returns the layers of an ETH/IPv4/TCP packet carrying payload
+func tcpPacket(payload []byte) testPacket {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolTCP, SrcIP: testSrcIP, DstIP: testDstIP}
+	tcp := &layers.TCP{SrcPort: 34577, DstPort: 443, PSH: true, ACK: true, Window: 1024}
+	tcp.SetNetworkLayerForChecksum(ip)
+	return testPacket{[]gopacket.SerializableLayer{testEthernet(layers.EthernetTypeIPv4), ip, tcp, gopacket.Payload(payload)}}
+}
+
+// testEthernet returns an Ethernet header with the given type
+func testEthernet(ethType layers.EthernetType) *layers.Ethernet {
+	return &layers.Ethernet{
+		SrcMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 5},
+		DstMAC:       net.HardwareAddr{0, 1, 2, 3, 4, 6},
+		EthernetType: ethType,
+	}
+}
+
+// testCapture serializes the packets into an in-memory pcap file of the link type and returns a reader for it. The
+// packets are one millisecond apart starting at testTime.
+func testCapture(t testing.TB, linkType layers.LinkType, packets ...testPacket) Reader {
+	return testCaptureLinkType(t, uint32(linkType), packets...)
+}
+
+// testCaptureLinkType is the same as testCapture but supports link types which do not fit into a layers.LinkType
+func testCaptureLinkType(t testing.TB, linkType uint32, packets ...testPacket) Reader {
+	r, err := ReadPcapFile(bytes.NewReader(testCaptureData(t, linkType, packets...)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// testCaptureData returns the in-memory pcap file of testCaptureLinkType
+func testCaptureData(t testing.TB, linkType uint32, packets ...testPacket) []byte {
+	var capture bytes.Buffer
+	w := pcapgo.NewWriter(&capture)
+	if err := w.WriteFileHeader(65535, layers.LinkType(linkType)); err != nil {
+		t.Fatal(err)
+	}
+	// The writer stores the link type in little endian at the end of the file header
+	binary.LittleEndian.PutUint32(capture.Bytes()[20:24], linkType)
+	for i, p := range packets {
+		buf := gopacket.NewSerializeBuffer()
+		if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, p.layers...); err != nil {
+			t.Fatal(err)
+		}
+		ci := gopacket.CaptureInfo{
+			Timestamp:     testTime.Add(time.Duration(i) * time.Millisecond),
+			CaptureLength: len(buf.Bytes()),
+			Length:        len(buf.Bytes()),
+		}
+		if err := w.WritePacket(ci, buf.Bytes()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return capture.Bytes()
+}
+
+// collect runs the engine on reader and returns clones of all records
+func collect(t testing.TB, e *Engine, reader Reader) []Record {
+	var records []Record
+	err := e.Run(context.Background(), reader, func(r Record) {
+		records = append(records, r.Clone())
+	})
+	if err != nil {
+		t.Fatalf("Expected: %v but got: %v\n", nil, err)
+	}
+	return records
+}
+
+func TestRun(t *testing.T) {
+	/*
+		Only the Client Hello has to be found in both modes, with the tuple and timestamp of its packet.
+	*/
+	for _, compat := range []bool{false, true} {
+		reader := testCapture(t, layers.LinkTypeEthernet,
+			tcpPacket(nil),
+			tcpPacket([]byte{42, 42, 42, 42, 42}),
+			tcpPacket(googleClientHello),
+		)
+		records := collect(t, &Engine{Compat: compat}, reader)
+		if len(records) != 1 {
+			t.Fatalf("Compat %v: Expected: %v records but got: %v\n", compat, 1, len(records))
+		}
+
+		r := records[0]
+		if !r.SrcIP.Equal(testSrcIP) || !r.DstIP.Equal(testDstIP) || r.SrcPort != 34577 || r.DstPort != 443 {
+			t.Errorf("Compat %v: Expected: %v:%v -> %v:%v but got: %v:%v -> %v:%v\n", compat,
+				testSrcIP, 34577, testDstIP, 443, r.SrcIP, r.SrcPort, r.DstIP, r.DstPort)
+		}
+		if expTime := testTime.Add(2 * time.Millisecond); !r.Timestamp.Equal(expTime) {
+			t.Errorf("Compat %v: Expected: %v but got: %v\n", compat, expTime, r.Timestamp)
+		}
+		if r.JA3.GetJA3Hash() != googleJA3Hash || !bytes.Equal(r.Payload, googleClientHello) {
+			t.Errorf("Compat %v: Expected: %v but got: %v\n", compat, googleJA3Hash, r.JA3.GetJA3Hash())
+		}
+	}
+}
+
+func TestRecords(t *testing.T) {
+	/*
+		The records sent on the channel must stay valid after the engine has moved on.
+	*/
+	reader := testCapture(t, layers.LinkTypeEthernet,
+		tcpPacket(googleClientHello),
+		tcpPacket([]byte{42, 42, 42, 42, 42}),
+		tcpPacket(googleClientHello),
+	)
+	e := &Engine{}
+	records, errc := e.Records(context.Background(), reader)
+
+	var n int
+	for r := range records {
+		n++
+		if r.JA3.GetJA3Hash() != googleJA3Hash || r.JA3.GetSNI() != "www.google.ch" {
+			t.Errorf("Expected: %v but got: %v\n", googleJA3Hash, r.JA3.GetJA3Hash())
+		}
+	}
+	if err := <-errc; err != nil {
+		t.Errorf("Expected: %v but got: %v\n", nil, err)
+	}
+	if n != 2 {
+		t.Errorf("Expected: %v records but got: %v\n", 2, n)
+	}
+}
+
+func TestRunCancel(t *testing.T) {
+	/*
+		A cancelled context has to stop the engine.
+	*/
+	ctx, cancel := context.WithCancel(context.Background())
+	reader := testCapture(t, layers.LinkTypeEthernet, tcpPacket(googleClientHello), tcpPacket(googleClientHello))
+
+	var n int
+	err := (&Engine{}).Run(ctx, reader, func(r Record) {
+		n++
+		cancel()
+	})
+	if err != context.Canceled || n != 1 {
+		t.Errorf("Expected: %v after %v records but got: %v after %v records\n", context.Canceled, 1, err, n)
+	}
+}
+
+func TestDecodeStats(t *testing.T) {
+	/*
+		Segments starting with a handshake record which is no valid Client Hello count as parse failures, other
+		handshake messages and application data do not.
+	*/
+	serverHello := append([]byte{22, 3, 3, 0, 40, 2, 0, 0, 36}, make([]byte, 36)...)
+	for _, compat := range []bool{false, true} {
+		e := &Engine{Compat: compat}
+		collect(t, e, testCapture(t, layers.LinkTypeEthernet,
+			tcpPacket(nil),
+			tcpPacket([]byte{23, 3, 3, 0, 1, 42}),
+			tcpPacket(googleClientHello),
+			tcpPacket(googleClientHello[:100]),
+			tcpPacket(serverHello),
+			tcpPacket([]byte{22, 127, 0, 0, 1, 1}),
+		))
+		expStats := DecodeStats{Packets: 6, ClientHellos: 1, ParseFailures: 2,
+			ParseFailuresByKind: ParseFailureKinds{UnsupportedVersion: 1, Truncated: 1}}
+		if stats := e.DecodeStats(); stats != expStats {
+			t.Errorf("Compat %v: Expected: %+v but got: %+v\n", compat, expStats, stats)
+		}
+	}
+}
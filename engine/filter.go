@@ -0,0 +1,244 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"fmt"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// DefaultFilter selects the built-in filter of the engine. It passes TCP segments whose payload starts with a TLS
+// handshake record, UDP datagrams from or to port 443 for QUIC, IP fragments and all tunnels the engine decapsulates.
+// IPv6 packets with extension headers and packets which are not IP, e.g. MPLS or PPPoE, are passed as well. The filter
+// is only available for Ethernet, Linux cooked capture and raw IP links, packets of other link types are not filtered.
+const DefaultFilter = "default"
+
+// defaultSessionsFilter selects DefaultFilter extended for engines tracking sessions. It also passes TCP segments whose
+// payload starts with a TLS change cipher spec, alert or application data record and TCP segments ending the
+// connection. It is no valid expression in tcpdump syntax, so it cannot clash with one.
+const defaultSessionsFilter = "default+sessions"
+
+// Return values of the filters
+const (
+	filterPass uint32 = 262144
+	filterDrop uint32 = 0
+)
+
+// Values checked by the default filter
+const (
+	tlsRecordHandshake uint32 = 22
+	tcpFlagsFINRST     uint32 = 0x05
+	udpPortQUIC        uint32 = 443
+	udpPortVXLAN       uint32 = 4789
+	udpPortGeneve      uint32 = 6081
+	udpPortGTPU        uint32 = 2152
+)
+
+// filterCaptureLength is the capture length expressions are compiled for
+const filterCaptureLength = 65535
+
+// filterSetter is implemented by readers which filter the packets before they reach the engine, e.g. in the kernel
+type filterSetter interface {
+	setFilter(expr string) error
+}
+
+// packetFilter runs a filter on the packets of readers which do not filter themselves. The filter is compiled for
+// every link type when the first packet of the link type arrives.
+type packetFilter struct {
+	expr string
+	// vms holds the compiled filters by link type, a nil VM passes all packets
+	vms map[layers.LinkType]*bpf.VM
+}
+
+// newPacketFilter returns the filter for the expression, which is either DefaultFilter or an expression in tcpdump
+// syntax
+func newPacketFilter(expr string) *packetFilter {
+	return &packetFilter{expr: expr, vms: make(map[layers.LinkType]*bpf.VM)}
+}
+
+// matches reports whether the packet of the link type passes the filter
+func (f *packetFilter) matches(linkType layers.LinkType, packet []byte) (bool, error) {
+	vm, ok := f.vms[linkType]
+	if !ok {
+		program, err := compileFilter(linkType, f.expr)
+		if err != nil {
+			return false, err
+		}
+		if program != nil {
+			if vm, err = bpf.NewVM(program); err != nil {
+				return false, fmt.Errorf("invalid filter %q: %v", f.expr, err)
+			}
+		}
+		f.vms[linkType] = vm
+	}
+	if vm == nil {
+		return true, nil
+	}
+	n, err := vm.Run(packet)
+	return n > 0, err
+}
+
+// compileFilter returns the program of the filter for the link type or nil if the packets are not filtered.
+// Expressions in tcpdump syntax are compiled with libpcap.
+func compileFilter(linkType layers.LinkType, expr string) ([]bpf.Instruction, error) {
+	if expr == DefaultFilter || expr == defaultSessionsFilter {
+		return defaultFilter(linkType, expr == defaultSessionsFilter), nil
+	}
+	return compileExpression(linkType, expr)
+}
+
+// defaultFilter returns the program of DefaultFilter, or of defaultSessionsFilter if sessions is set, for the link type
+// or nil if it is not supported
+func defaultFilter(linkType layers.LinkType, sessions bool) []bpf.Instruction {
+	p := &filterProgram{labels: make(map[string]int), jumps: make(map[int][2]string)}
+
+	// Load the EtherType into A and the offset of the network header into X
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		p.add(bpf.LoadAbsolute{Off: 12, Size: 2}, bpf.LoadConstant{Dst: bpf.RegX, Val: 14})
+		// Skip up to two VLAN tags
+		for i, off := range []uint32{16, 20} {
+			tag := fmt.Sprintf("vlan%d", i)
+			p.jumpIf(uint32(layers.EthernetTypeDot1Q), tag, "")
+			p.jumpIf(uint32(layers.EthernetTypeQinQ), tag, "ethernet")
+			p.label(tag)
+			p.add(bpf.LoadAbsolute{Off: off, Size: 2}, bpf.LoadConstant{Dst: bpf.RegX, Val: off + 2})
+		}
+	case layers.LinkTypeLinuxSLL:
+		p.add(bpf.LoadAbsolute{Off: 14, Size: 2}, bpf.LoadConstant{Dst: bpf.RegX, Val: 16})
+	case linkTypeLinuxSLL2:
+		p.add(bpf.LoadAbsolute{Off: 0, Size: 2}, bpf.LoadConstant{Dst: bpf.RegX, Val: 20})
+	case layers.LinkTypeRaw, linkTypeRawDLT, linkTypeRawOpenBSD:
+		// Map the IP version to the EtherType
+		p.add(bpf.LoadConstant{Dst: bpf.RegX, Val: 0}, bpf.LoadAbsolute{Off: 0, Size: 1},
+			bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0xf0})
+		p.jumpIf(0x40, "ipv4", "")
+		p.jumpIf(0x60, "ipv6", "pass")
+	default:
+		return nil
+	}
+
+	p.label("ethernet")
+	p.jumpIf(uint32(layers.EthernetTypeIPv4), "ipv4", "")
+	p.jumpIf(uint32(layers.EthernetTypeIPv6), "ipv6", "pass")
+
+	// Pass fragments, store the protocol and move X to the transport header
+	p.label("ipv4")
+	p.add(bpf.LoadIndirect{Off: 6, Size: 2})
+	p.jumpBitsSet(0x3fff, "pass", "")
+	p.add(bpf.LoadIndirect{Off: 9, Size: 1}, bpf.StoreScratch{Src: bpf.RegA, N: 0},
+		bpf.LoadIndirect{Off: 0, Size: 1}, bpf.ALUOpConstant{Op: bpf.ALUOpAnd, Val: 0x0f},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2}, bpf.ALUOpX{Op: bpf.ALUOpAdd}, bpf.TAX{},
+		bpf.LoadScratch{Dst: bpf.RegA, N: 0})
+	p.jump("protocol")
+
+	// Extension headers are not followed, so their packets pass
+	p.label("ipv6")
+	p.add(bpf.LoadIndirect{Off: 6, Size: 1}, bpf.StoreScratch{Src: bpf.RegA, N: 0}, bpf.TXA{},
+		bpf.ALUOpConstant{Op: bpf.ALUOpAdd, Val: 40}, bpf.TAX{}, bpf.LoadScratch{Dst: bpf.RegA, N: 0})
+
+	p.label("protocol")
+	p.jumpIf(uint32(layers.IPProtocolTCP), "tcp", "")
+	p.jumpIf(uint32(layers.IPProtocolUDP), "udp", "")
+	p.jumpIf(uint32(layers.IPProtocolICMPv4), "drop", "")
+	p.jumpIf(uint32(layers.IPProtocolICMPv6), "drop", "pass")
+
+	// Check the first byte of the payload for a TLS handshake record, for sessions also for the other TLS records and
+	// pass the segments with FIN or RST
+	p.label("tcp")
+	if sessions {
+		p.add(bpf.LoadIndirect{Off: 13, Size: 1})
+		p.jumpBitsSet(tcpFlagsFINRST, "pass", "")
+	}
+	p.add(bpf.LoadIndirect{Off: 12, Size: 1}, bpf.ALUOpConstant{Op: bpf.ALUOpShiftRight, Val: 4},
+		bpf.ALUOpConstant{Op: bpf.ALUOpShiftLeft, Val: 2}, bpf.ALUOpX{Op: bpf.ALUOpAdd}, bpf.TAX{},
+		bpf.LoadIndirect{Off: 0, Size: 1})
+	if sessions {
+		p.jumpIf(uint32(tlsRecordChangeCipherSpec), "pass", "")
+		p.jumpIf(uint32(tlsRecordAlert), "pass", "")
+		p.jumpIf(uint32(tlsRecordApplicationData), "pass", "")
+	}
+	p.jumpIf(tlsRecordHandshake, "pass", "drop")
+
+	// Pass QUIC and the UDP tunnels
+	p.label("udp")
+	p.add(bpf.LoadIndirect{Off: 0, Size: 2})
+	p.jumpIf(udpPortQUIC, "pass", "")
+	p.add(bpf.LoadIndirect{Off: 2, Size: 2})
+	p.jumpIf(udpPortQUIC, "pass", "")
+	p.jumpIf(udpPortVXLAN, "pass", "")
+	p.jumpIf(udpPortGeneve, "pass", "")
+	p.jumpIf(udpPortGTPU, "pass", "drop")
+
+	p.label("drop")
+	p.add(bpf.RetConstant{Val: filterDrop})
+	p.label("pass")
+	p.add(bpf.RetConstant{Val: filterPass})
+	return p.resolve()
+}
+
+// filterProgram builds a BPF program whose jumps refer to labels instead of relative offsets
+type filterProgram struct {
+	instructions []bpf.Instruction
+	labels       map[string]int
+	// jumps holds the labels of the jumps by instruction index, an empty label continues with the next instruction
+	jumps map[int][2]string
+}
+
+// add instructions to the program
+func (p *filterProgram) add(instructions ...bpf.Instruction) {
+	p.instructions = append(p.instructions, instructions...)
+}
+
+// label the next instruction
+func (p *filterProgram) label(name string) {
+	p.labels[name] = len(p.instructions)
+}
+
+// jumpIf adds a jump depending on whether A equals val
+func (p *filterProgram) jumpIf(val uint32, ifTrue, ifFalse string) {
+	p.jumps[len(p.instructions)] = [2]string{ifTrue, ifFalse}
+	p.add(bpf.JumpIf{Cond: bpf.JumpEqual, Val: val})
+}
+
+// jumpBitsSet adds a jump depending on whether any of the bits of val are set in A
+func (p *filterProgram) jumpBitsSet(val uint32, ifTrue, ifFalse string) {
+	p.jumps[len(p.instructions)] = [2]string{ifTrue, ifFalse}
+	p.add(bpf.JumpIf{Cond: bpf.JumpBitsSet, Val: val})
+}
+
+// jump adds an unconditional jump
+func (p *filterProgram) jump(label string) {
+	p.jumps[len(p.instructions)] = [2]string{label, ""}
+	p.add(bpf.Jump{})
+}
+
+// resolve the labels of the jumps and return the program
+func (p *filterProgram) resolve() []bpf.Instruction {
+	skip := func(from int, label string) uint32 {
+		if label == "" {
+			return 0
+		}
+		to, ok := p.labels[label]
+		if !ok || to <= from {
+			panic("invalid jump to " + label)
+		}
+		return uint32(to - from - 1)
+	}
+	for i, labels := range p.jumps {
+		switch ins := p.instructions[i].(type) {
+		case bpf.JumpIf:
+			ins.SkipTrue, ins.SkipFalse = uint8(skip(i, labels[0])), uint8(skip(i, labels[1]))
+			p.instructions[i] = ins
+		case bpf.Jump:
+			ins.Skip = skip(i, labels[0])
+			p.instructions[i] = ins
+		}
+	}
+	return p.instructions
+}
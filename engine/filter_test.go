@@ -0,0 +1,147 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+	"testing"
+)
+
+type filterTestContainer struct {
+	linkType layers.LinkType
+	layers   []gopacket.SerializableLayer
+	expPass  bool
+}
+
+// serialize the layers into packet data
+func serialize(t *testing.T, packetLayers ...gopacket.SerializableLayer) []byte {
+	buf := gopacket.NewSerializeBuffer()
+	if err := gopacket.SerializeLayers(buf, gopacket.SerializeOptions{FixLengths: true, ComputeChecksums: true}, packetLayers...); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// udpPacket returns the IPv4, UDP and payload layers of a datagram to the destination port
+func udpPacket(dstPort layers.UDPPort) []gopacket.SerializableLayer {
+	ip := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: testSrcIP, DstIP: testDstIP}
+	udp := &layers.UDP{SrcPort: 50000, DstPort: dstPort}
+	udp.SetNetworkLayerForChecksum(ip)
+	return []gopacket.SerializableLayer{ip, udp, gopacket.Payload{1, 2, 3, 4}}
+}
+
+func TestDefaultFilter(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Only TLS handshakes, QUIC, fragments, tunnels and packets the filter cannot look into may pass.
+	*/
+	ethernet := func(ethType layers.EthernetType, packet []gopacket.SerializableLayer) []gopacket.SerializableLayer {
+		return withHeaders(packet, testEthernet(ethType))
+	}
+	ipv4Data := tcpPacket([]byte{23, 3, 3, 0, 1, 0}).layers
+	ipv4Empty := tcpPacket(nil).layers
+	ipv6Data := ipv6TCP()
+	ipv6Data[3] = gopacket.Payload{23, 3, 3, 0, 1, 0}
+	ipv6NoExt := ipv6TCP()
+	ipv6NoExt[0].(*layers.IPv6).NextHeader = layers.IPProtocolTCP
+	ipv6NoExt = append(ipv6NoExt[:1], ipv6NoExt[2:]...)
+
+	var filterTestSet = map[string]filterTestContainer{
+		"IPv4 Client Hello":         {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv4, ipv4TCP()), true},
+		"IPv4 application data":     {layers.LinkTypeEthernet, ipv4Data, false},
+		"IPv4 without payload":      {layers.LinkTypeEthernet, ipv4Empty, false},
+		"IPv6 Client Hello":         {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv6, ipv6NoExt), true},
+		"IPv6 extension header":     {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv6, ipv6Data), true},
+		"QUIC":                      {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv4, udpPacket(443)), true},
+		"DNS":                       {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv4, udpPacket(53)), false},
+		"VXLAN":                     {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv4, udpPacket(4789)), true},
+		"GTP-U":                     {layers.LinkTypeEthernet, ethernet(layers.EthernetTypeIPv4, udpPacket(2152)), true},
+		"GRE":                       {layers.LinkTypeEthernet, withHeaders(ipv4TCP(), testEthernet(layers.EthernetTypeIPv4), outerIPv4(layers.IPProtocolGRE), &layers.GRE{Protocol: layers.EthernetTypeIPv4}), true},
+		"Fragment":                  {layers.LinkTypeEthernet, ipv4Fragments(t, 1, 1)[0].layers, true},
+		"ARP":                       {layers.LinkTypeEthernet, []gopacket.SerializableLayer{testEthernet(layers.EthernetTypeARP), gopacket.Payload(make([]byte, 28))}, true},
+		"VLAN Client Hello":         {layers.LinkTypeEthernet, withHeaders(ipv4TCP(), testEthernet(layers.EthernetTypeDot1Q), &layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeIPv4}), true},
+		"QinQ application data":     {layers.LinkTypeEthernet, withHeaders(ipv4Data[1:], testEthernet(layers.EthernetTypeQinQ), &layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeDot1Q}, &layers.Dot1Q{VLANIdentifier: 20, Type: layers.EthernetTypeIPv4}), false},
+		"SLL Client Hello":          {layers.LinkTypeLinuxSLL, withHeaders(ipv4TCP(), gopacket.Payload{0, 0, 0, 1, 0, 6, 0, 1, 2, 3, 4, 5, 0, 0, 0x08, 0x00}), true},
+		"SLL2 application data":     {linkTypeLinuxSLL2, withHeaders(ipv4Data[1:], gopacket.Payload{0x08, 0x00, 0, 0, 0, 0, 0, 2, 0, 1, 0, 6, 0, 1, 2, 3, 4, 5, 0, 0}), false},
+		"Raw IPv6 Client Hello":     {layers.LinkTypeRaw, ipv6NoExt, true},
+		"Raw IPv4 application data": {layers.LinkTypeRaw, ipv4Data[1:], false},
+	}
+
+	// Run through all test cases
+	for name, test := range filterTestSet {
+		vm, err := bpf.NewVM(defaultFilter(test.linkType, false))
+		if err != nil {
+			t.Fatalf("%v: Expected: %v but got: %v\n", name, nil, err)
+		}
+		n, err := vm.Run(serialize(t, test.layers...))
+		if err != nil || (n > 0) != test.expPass {
+			t.Errorf("%v: Expected: %v but got: %v (%v)\n", name, test.expPass, n > 0, err)
+		}
+	}
+
+	// Link types the filter cannot look into are not filtered
+	if program := defaultFilter(layers.LinkTypeIEEE802_11, false); program != nil {
+		t.Errorf("Expected: %v but got: %v\n", nil, program)
+	}
+}
+
+func TestDefaultSessionsFilter(t *testing.T) {
+	/*
+		Build container with testing data
+
+		For sessions, the other TLS records and the segments ending a connection have to pass as well.
+	*/
+	fin := tcpPacket(nil).layers
+	fin[2].(*layers.TCP).FIN = true
+	rst := tcpPacket(nil).layers
+	rst[2].(*layers.TCP).RST = true
+
+	var filterTestSet = map[string]filterTestContainer{
+		"Client Hello":       {layers.LinkTypeEthernet, withHeaders(ipv4TCP(), testEthernet(layers.EthernetTypeIPv4)), true},
+		"Change cipher spec": {layers.LinkTypeEthernet, tcpPacket([]byte{20, 3, 3, 0, 1, 1}).layers, true},
+		"Alert":              {layers.LinkTypeEthernet, tcpPacket([]byte{21, 3, 3, 0, 2, 2, 40}).layers, true},
+		"Application data":   {layers.LinkTypeEthernet, tcpPacket([]byte{23, 3, 3, 0, 1, 0}).layers, true},
+		"Other data":         {layers.LinkTypeEthernet, tcpPacket([]byte{42, 42, 42, 42, 42}).layers, false},
+		"Without payload":    {layers.LinkTypeEthernet, tcpPacket(nil).layers, false},
+		"FIN":                {layers.LinkTypeEthernet, fin, true},
+		"RST":                {layers.LinkTypeEthernet, rst, true},
+		"DNS":                {layers.LinkTypeEthernet, withHeaders(udpPacket(53), testEthernet(layers.EthernetTypeIPv4)), false},
+	}
+
+	// Run through all test cases
+	for name, test := range filterTestSet {
+		vm, err := bpf.NewVM(defaultFilter(test.linkType, true))
+		if err != nil {
+			t.Fatalf("%v: Expected: %v but got: %v\n", name, nil, err)
+		}
+		n, err := vm.Run(serialize(t, test.layers...))
+		if err != nil || (n > 0) != test.expPass {
+			t.Errorf("%v: Expected: %v but got: %v (%v)\n", name, test.expPass, n > 0, err)
+		}
+	}
+}
+
+func TestRunFilter(t *testing.T) {
+	/*
+		The default filter must not drop any Client Hello found by the engine.
+	*/
+	for _, compat := range []bool{false, true} {
+		reader := testCapture(t, layers.LinkTypeEthernet,
+			tcpPacket(nil),
+			tcpPacket([]byte{42, 42, 42, 42, 42}),
+			tcpPacket(googleClientHello),
+			testPacket{withHeaders(ipv4TCP(), udpTunnel(4789, &layers.VXLAN{ValidIDFlag: true, VNI: 100}, testEthernet(layers.EthernetTypeIPv4))...)},
+		)
+		records := collect(t, &Engine{Compat: compat, Filter: DefaultFilter}, reader)
+		if len(records) != 2 {
+			t.Errorf("Compat %v: Expected: %v records but got: %v\n", compat, 2, len(records))
+		}
+	}
+}
@@ -0,0 +1,26 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build !linux && !darwin && !freebsd && !netbsd && !openbsd
+
+package engine
+
+import (
+	"errors"
+)
+
+// errNoMmap is returned by mapFile if the file cannot be memory mapped
+var errNoMmap = errors.New("memory mapping not supported")
+
+// mapFile is not supported on this platform
+func mapFile(path string) ([]byte, error) {
+	return nil, errNoMmap
+}
+
+// unmapFile is not supported on this platform
+func unmapFile(data []byte) error {
+	return errNoMmap
+}
@@ -0,0 +1,46 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package engine
+
+import (
+	"errors"
+	"golang.org/x/sys/unix"
+	"os"
+)
+
+// errNoMmap is returned by mapFile if the file cannot be memory mapped
+var errNoMmap = errors.New("memory mapping not supported")
+
+// mapFile maps the regular file at path read-only into memory, empty files map to nil
+func mapFile(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !info.Mode().IsRegular() || int64(int(info.Size())) != info.Size() {
+		return nil, errNoMmap
+	}
+	if info.Size() == 0 {
+		return nil, nil
+	}
+	return unix.Mmap(int(f.Fd()), 0, int(info.Size()), unix.PROT_READ, unix.MAP_SHARED)
+}
+
+// unmapFile unmaps the data returned by mapFile
+func unmapFile(data []byte) error {
+	if data == nil {
+		return nil
+	}
+	return unix.Munmap(data)
+}
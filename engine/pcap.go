@@ -0,0 +1,111 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build cgo
+
+package engine
+
+import (
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcap"
+	"golang.org/x/net/bpf"
+	"sync"
+)
+
+// ReadFromInterface returns a handle to read from the specified interface. The snap length is set to 1600 and the
+// interface is in promiscuous mode. The reader implements StatsReader and io.Closer, its statistics may be read while
+// another goroutine reads the packets.
+func ReadFromInterface(device string) (Reader, error) {
+	handle, err := pcap.OpenLive(device, 1600, true, liveReadTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return &liveReader{Handle: handle, device: device}, nil
+}
+
+// liveReader reports the read timeouts of the handle as temporary errors and knows the name of its interface
+type liveReader struct {
+	*pcap.Handle
+	device string
+	// lock serializes the reads, the statistics and closing, as the handle does not guard libpcap against calling
+	// Stats during a read
+	lock sync.Mutex
+}
+
+// ZeroCopyReadPacketData reads the next packet from the interface
+func (r *liveReader) ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error) {
+	r.lock.Lock()
+	data, ci, err := r.Handle.ZeroCopyReadPacketData()
+	r.lock.Unlock()
+	if err == pcap.NextErrorTimeoutExpired {
+		err = errTimeout{}
+	}
+	return data, ci, err
+}
+
+// InterfaceName returns the name of the captured interface
+func (r *liveReader) InterfaceName(index int) string {
+	return r.device
+}
+
+// setFilter applies the filter in the kernel, which spares copying the dropped packets to user space
+func (r *liveReader) setFilter(expr string) error {
+	if expr != DefaultFilter && expr != defaultSessionsFilter {
+		return r.SetBPFFilter(expr)
+	}
+	program := defaultFilter(r.LinkType(), expr == defaultSessionsFilter)
+	if program == nil {
+		return nil
+	}
+	raw, err := bpf.Assemble(program)
+	if err != nil {
+		return err
+	}
+	instructions := make([]pcap.BPFInstruction, len(raw))
+	for i, ins := range raw {
+		instructions[i] = pcap.BPFInstruction{Code: ins.Op, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	return r.SetBPFInstructionFilter(instructions)
+}
+
+// Stats returns the number of packets received and dropped by the kernel and the interface since the handle was opened
+func (r *liveReader) Stats() (CaptureStats, error) {
+	r.lock.Lock()
+	stats, err := r.Handle.Stats()
+	r.lock.Unlock()
+	if err != nil {
+		return CaptureStats{}, err
+	}
+	return CaptureStats{
+		Packets:        uint64(stats.PacketsReceived),
+		Drops:          uint64(stats.PacketsDropped),
+		InterfaceDrops: uint64(stats.PacketsIfDropped),
+	}, nil
+}
+
+// Close closes the handle once a read in progress returned
+func (r *liveReader) Close() error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.Handle.Close()
+	return nil
+}
+
+// compileExpression compiles the expression in tcpdump syntax for the link type with libpcap
+func compileExpression(linkType layers.LinkType, expr string) ([]bpf.Instruction, error) {
+	compiled, err := pcap.CompileBPFFilter(linkType, filterCaptureLength, expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter %q: %v", expr, err)
+	}
+	raw := make([]bpf.RawInstruction, len(compiled))
+	for i, ins := range compiled {
+		raw[i] = bpf.RawInstruction{Op: ins.Code, Jt: ins.Jt, Jf: ins.Jf, K: ins.K}
+	}
+	program, _ := bpf.Disassemble(raw)
+	return program, nil
+}
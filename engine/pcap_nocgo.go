@@ -0,0 +1,29 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+//go:build !cgo
+
+package engine
+
+import (
+	"errors"
+	"github.com/google/gopacket/layers"
+	"golang.org/x/net/bpf"
+)
+
+// errNoPcap is returned by the functions needing libpcap in binaries built without cgo
+var errNoPcap = errors.New("libpcap is not available in binaries built without cgo")
+
+// ReadFromInterface needs libpcap, which is not available in binaries built without cgo. Use ReadFromAFPacket
+// instead.
+func ReadFromInterface(device string) (Reader, error) {
+	return nil, errNoPcap
+}
+
+// compileExpression needs libpcap, so only DefaultFilter is available in binaries built without cgo
+func compileExpression(linkType layers.LinkType, expr string) ([]bpf.Instruction, error) {
+	return nil, errNoPcap
+}
@@ -0,0 +1,514 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"io"
+	"runtime"
+	"sync"
+	"time"
+)
+
+// Tuning of the pipeline
+const (
+	// pipelineBatchSize is the number of packets after which the batches are handed over to the workers
+	pipelineBatchSize = 128
+	// pipelineFlushInterval is the time after which the batches are handed over even if they are not full, which
+	// keeps the latency low on quiet links
+	pipelineFlushInterval = 100 * time.Millisecond
+	// pipelineBatchesPerWorker is the number of batches in flight per worker, which bounds the memory used when the
+	// workers or the handler cannot keep up with the reader
+	pipelineBatchesPerWorker = 4
+)
+
+// Pipeline runs an engine per core on the packets of a single reader. A reader goroutine distributes the packets to the
+// workers by the hash of their flow, so all packets of a flow are decoded by the same engine. Fragments, of which only
+// the first carries the ports, are distributed by the hash of their addresses, so all fragments of a datagram are
+// reassembled by the same engine. If the engines track sessions and reassemble fragments, all packets are distributed
+// by the hash of their addresses and protocol, so the reassembled datagrams reach the engine tracking their session, at
+// the cost of spreading the traffic between few hosts over few workers. The records found by the workers are merged
+// back into the order the packets were read in, which is the order of their capture timestamps for capture files, so
+// the handler sees the same records in the same order as if a single engine had run on the reader. If the engines track
+// sessions, the sessions which timed out may be passed on in a different order, as the timeouts of every engine only
+// advance with the packets of its own flows. A Pipeline must not be used by multiple goroutines at the same time.
+type Pipeline struct {
+	// Workers is the number of engines running in parallel, zero selects runtime.GOMAXPROCS.
+	Workers int
+	// NewEngine returns the engine of a worker, all engines have to be configured the same. If nil, the workers use
+	// engines with the default configuration.
+	NewEngine func() *Engine
+	// ChunkSize is the size of the chunks RunFile splits pcap files into, zero selects DefaultChunkSize.
+	ChunkSize int
+
+	lock    sync.Mutex
+	engines []*Engine
+}
+
+// DefragStats returns the counters of the IP fragment reassembly accumulated over all workers and runs of the
+// pipeline. It may be called while the pipeline is running.
+func (p *Pipeline) DefragStats() DefragStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var stats DefragStats
+	for _, e := range p.engines {
+		s := e.DefragStats()
+		stats.Fragments += s.Fragments
+		stats.Reassembled += s.Reassembled
+		stats.Overlapping += s.Overlapping
+		stats.Duplicates += s.Duplicates
+		stats.TimedOut += s.TimedOut
+		stats.Dropped += s.Dropped
+	}
+	return stats
+}
+
+// DecodeStats returns the counters of the decoded packets accumulated over all workers and runs of the pipeline. It
+// may be called while the pipeline is running.
+func (p *Pipeline) DecodeStats() DecodeStats {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	var stats DecodeStats
+	for _, e := range p.engines {
+		s := e.DecodeStats()
+		stats.Packets += s.Packets
+		stats.ClientHellos += s.ClientHellos
+		stats.ParseFailures += s.ParseFailures
+		stats.ParseFailuresByKind.UnsupportedVersion += s.ParseFailuresByKind.UnsupportedVersion
+		stats.ParseFailuresByKind.Truncated += s.ParseFailuresByKind.Truncated
+		stats.ParseFailuresByKind.Malformed += s.ParseFailuresByKind.Malformed
+		stats.ParseFailuresByKind.UnsupportedSNIType += s.ParseFailuresByKind.UnsupportedSNIType
+	}
+	return stats
+}
+
+// newEngines returns the engines of the workers for a run
+func (p *Pipeline) newEngines() []*Engine {
+	workers := p.Workers
+	if workers <= 0 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	engines := make([]*Engine, workers)
+	for i := range engines {
+		engines[i] = p.newEngine()
+	}
+	return engines
+}
+
+// newEngine returns an engine configured like the engines of the workers, whose counters add to the ones of the
+// pipeline
+func (p *Pipeline) newEngine() *Engine {
+	e := &Engine{}
+	if p.NewEngine != nil {
+		e = p.NewEngine()
+	}
+	p.lock.Lock()
+	p.engines = append(p.engines, e)
+	p.lock.Unlock()
+	return e
+}
+
+// pipelinePacket is a packet in a batch, its data is stored in the buffer of the batch
+type pipelinePacket struct {
+	seq        uint64
+	ci         gopacket.CaptureInfo
+	linkType   layers.LinkType
+	intf       string
+	start, end int
+}
+
+// pipelineBatch is a batch of packets handed over to a worker. upTo is the sequence number of the next packet read,
+// so once a worker processed the batch, it found the records of all its packets read before.
+type pipelineBatch struct {
+	data    []byte
+	packets []pipelinePacket
+	upTo    uint64
+}
+
+// sequencedRecord is a cloned record together with the sequence number of its packet
+type sequencedRecord struct {
+	seq    uint64
+	record Record
+}
+
+// pipelineResult holds the records a worker found in a batch
+type pipelineResult struct {
+	worker  int
+	records []sequencedRecord
+	upTo    uint64
+	err     error
+}
+
+// Run reads from reader until an io.EOF error is encountered or the context is done and calls handler for every Client
+// Hello found by the workers. The handler is called in the goroutine calling Run, in the order the packets were read.
+// Unlike Engine.Run, the records passed to handler are clones and may be kept.
+func (p *Pipeline) Run(ctx context.Context, reader Reader, handler func(Record)) error {
+	return p.run(ctx, reader, p.newEngines(), handler)
+}
+
+// run runs the engines of the workers on the packets read from reader, see Run
+func (p *Pipeline) run(ctx context.Context, reader Reader, engines []*Engine, handler func(Record)) error {
+	workers := len(engines)
+
+	// Readers filtering themselves only need the filter once, otherwise every worker runs its own copy
+	filter, err := engines[0].installFilter(reader)
+	if err != nil {
+		return err
+	}
+	decoders := make([]*decoder, workers)
+	for i, e := range engines {
+		if i > 0 && filter != nil {
+			decoders[i] = e.newDecoder(newPacketFilter(e.filterExpression()))
+		} else {
+			decoders[i] = e.newDecoder(filter)
+		}
+	}
+
+	parent := ctx
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	free := make(chan *pipelineBatch, workers*pipelineBatchesPerWorker)
+	for i := 0; i < cap(free); i++ {
+		free <- &pipelineBatch{}
+	}
+	inputs := make([]chan *pipelineBatch, workers)
+	results := make(chan pipelineResult, workers*pipelineBatchesPerWorker)
+	var wg sync.WaitGroup
+	for i := range inputs {
+		inputs[i] = make(chan *pipelineBatch, pipelineBatchesPerWorker)
+		wg.Add(1)
+		go func(worker int) {
+			defer wg.Done()
+			runWorker(worker, engines[worker], decoders[worker], inputs[worker], free, results)
+		}(i)
+	}
+	// Sessions need the fragmented packets of their flow, which are hashed by address, in the same engine
+	byAddress := engines[0].Sessions && engines[0].DefragMemory >= 0
+	var readErr error
+	go func() {
+		readErr = distribute(ctx, reader, inputs, free, byAddress)
+		for _, input := range inputs {
+			close(input)
+		}
+		wg.Wait()
+		close(results)
+	}()
+
+	// The results channel is closed after the reader returned, errors of the workers cancel the reader. Records are
+	// held back until they are merged, so a context done after the last packet was read still drops some of them.
+	if err := mergeResults(ctx, workers, results, handler, cancel); err != nil {
+		return err
+	}
+	if readErr != nil {
+		return readErr
+	}
+	return parent.Err()
+}
+
+// distribute reads the packets from reader into batches per worker and hands them over until the reader or the context
+// is done. byAddress selects the flow hash by addresses, see flowHash.
+func distribute(ctx context.Context, reader Reader, inputs []chan *pipelineBatch, free chan *pipelineBatch,
+	byAddress bool) error {
+	src := newSource(reader)
+	batches := make([]*pipelineBatch, len(inputs))
+	var seq uint64
+	pending := 0
+	lastFlush := time.Now()
+
+	// flush hands over the batches to all workers, also the empty ones, so every worker learns about the progress
+	flush := func() error {
+		for i, input := range inputs {
+			b := batches[i]
+			if b == nil {
+				select {
+				case b = <-free:
+				case <-ctx.Done():
+					return ctx.Err()
+				}
+				b.data, b.packets = b.data[:0], b.packets[:0]
+			}
+			b.upTo = seq
+			select {
+			case input <- b:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			batches[i] = nil
+		}
+		pending = 0
+		lastFlush = time.Now()
+		return nil
+	}
+
+	for {
+		// Check if we have to stop
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		// Read packet data
+		packet, ci, err := reader.ZeroCopyReadPacketData()
+		if err == io.EOF {
+			return flush()
+		} else if isTimeout(err) {
+			if pending > 0 {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		// Copy the packet into the batch of its worker
+		linkType := src.packetLinkType(ci.InterfaceIndex)
+		worker := int(flowHash(linkType, packet, byAddress) % uint32(len(inputs)))
+		b := batches[worker]
+		if b == nil {
+			select {
+			case b = <-free:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+			b.data, b.packets = b.data[:0], b.packets[:0]
+			batches[worker] = b
+		}
+		start := len(b.data)
+		b.data = append(b.data, packet...)
+		b.packets = append(b.packets, pipelinePacket{
+			seq:      seq,
+			ci:       ci,
+			linkType: linkType,
+			intf:     src.interfaceName(ci.InterfaceIndex),
+			start:    start,
+			end:      len(b.data),
+		})
+		seq++
+		pending++
+
+		if len(b.packets) >= pipelineBatchSize || time.Since(lastFlush) >= pipelineFlushInterval {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// runWorker decodes the batches of the worker with its engine and sends the clones of the found records to results.
+// The sessions still open once the input is closed are sent as the last result.
+func runWorker(worker int, e *Engine, d *decoder, input <-chan *pipelineBatch, free chan<- *pipelineBatch,
+	results chan<- pipelineResult) {
+	failed := false
+	var upTo uint64
+	for b := range input {
+		upTo = b.upTo
+		result := pipelineResult{worker: worker, upTo: b.upTo}
+		for _, packet := range b.packets {
+			if failed {
+				break
+			}
+			seq := packet.seq
+			result.err = e.handle(d, b.data[packet.start:packet.end], packet.ci, packet.linkType, packet.intf,
+				func(record Record) {
+					result.records = append(result.records, sequencedRecord{seq, record.Clone()})
+				})
+			failed = result.err != nil
+		}
+		free <- b
+		results <- result
+	}
+	if !failed && d.sessions != nil {
+		result := pipelineResult{worker: worker, upTo: upTo}
+		d.flushSessions(func(record Record) {
+			result.records = append(result.records, sequencedRecord{upTo, record})
+		})
+		results <- result
+	}
+}
+
+// mergeResults calls handler for the records of the workers in the order of their sequence numbers and, for the
+// sessions flushed at the end, of their timestamps. A record is only
+// passed on once every other worker either has a record with a higher sequence number queued or processed all packets
+// up to it. The first error of a worker cancels the pipeline.
+func mergeResults(ctx context.Context, workers int, results <-chan pipelineResult, handler func(Record),
+	cancel context.CancelFunc) error {
+	queues := make([][]sequencedRecord, workers)
+	progress := make([]uint64, workers)
+	var err error
+
+	// emit passes on all records which are safe to pass on, or all records if the workers are done
+	emit := func(done bool) {
+		for {
+			next := -1
+			for w, q := range queues {
+				if len(q) > 0 && (next < 0 || q[0].seq < queues[next][0].seq ||
+					(q[0].seq == queues[next][0].seq && q[0].record.Timestamp.Before(queues[next][0].record.Timestamp))) {
+					next = w
+				}
+			}
+			if next < 0 {
+				return
+			}
+			seq := queues[next][0].seq
+			for w, q := range queues {
+				if !done && len(q) == 0 && progress[w] <= seq {
+					return
+				}
+			}
+			if err == nil && ctx.Err() == nil {
+				handler(queues[next][0].record)
+			}
+			queues[next][0] = sequencedRecord{}
+			queues[next] = queues[next][1:]
+		}
+	}
+
+	for result := range results {
+		if result.err != nil && err == nil {
+			err = result.err
+			cancel()
+		}
+		queues[result.worker] = append(queues[result.worker], result.records...)
+		progress[result.worker] = result.upTo
+		emit(false)
+	}
+	emit(true)
+	return err
+}
+
+// flowHash returns a hash of the flow of the packet, which is the same for both directions. If byAddress is set, all
+// packets are hashed by their addresses and protocol only, so the fragments of a datagram, of which only the first
+// carries the ports, end up with the other packets of their flow. Otherwise fragments are hashed by their addresses
+// only and other packets with their ports. Packets without an IP header hash to zero.
+func flowHash(linkType layers.LinkType, packet []byte, byAddress bool) uint32 {
+	// Find the IP header behind the link layer
+	offset := -1
+	switch linkType {
+	case layers.LinkTypeEthernet:
+		offset = 12
+		for offset+2 <= len(packet) {
+			ethType := layers.EthernetType(binary.BigEndian.Uint16(packet[offset:]))
+			if ethType != layers.EthernetTypeDot1Q && ethType != layers.EthernetTypeQinQ {
+				if ethType != layers.EthernetTypeIPv4 && ethType != layers.EthernetTypeIPv6 {
+					return 0
+				}
+				break
+			}
+			offset += 4
+		}
+		offset += 2
+	case layers.LinkTypeLinuxSLL:
+		offset = 16
+	case linkTypeLinuxSLL2:
+		offset = 20
+	case layers.LinkTypeNull, layers.LinkTypeLoop:
+		offset = 4
+	case layers.LinkTypeRaw, linkTypeRawDLT, linkTypeRawOpenBSD, layers.LinkTypeIPv4, layers.LinkTypeIPv6:
+		offset = 0
+	}
+	if offset < 0 || offset >= len(packet) {
+		return 0
+	}
+	ip := packet[offset:]
+
+	// Find the addresses and the transport header
+	var src, dst, transport []byte
+	var protocol layers.IPProtocol
+	switch ip[0] >> 4 {
+	case 4:
+		headerLen := int(ip[0]&0x0f) * 4
+		if headerLen < 20 || len(ip) < headerLen {
+			return 0
+		}
+		src, dst, protocol = ip[12:16], ip[16:20], layers.IPProtocol(ip[9])
+		// Only unfragmented packets are hashed with their ports
+		if binary.BigEndian.Uint16(ip[6:])&0x3fff == 0 {
+			transport = ip[headerLen:]
+		}
+	case 6:
+		if len(ip) < 40 {
+			return 0
+		}
+		src, dst, protocol = ip[8:24], ip[24:40], layers.IPProtocol(ip[6])
+		transport = ip[40:]
+		// Skip the extension headers in front of the transport header, fragments are hashed without ports
+		for protocol == layers.IPProtocolIPv6HopByHop || protocol == layers.IPProtocolIPv6Routing ||
+			protocol == layers.IPProtocolIPv6Destination {
+			if len(transport) < 8 || len(transport) < (int(transport[1])+1)*8 {
+				return 0
+			}
+			protocol = layers.IPProtocol(transport[0])
+			transport = transport[(int(transport[1])+1)*8:]
+		}
+		if protocol == layers.IPProtocolIPv6Fragment {
+			if len(transport) < 8 {
+				return 0
+			}
+			protocol, transport = layers.IPProtocol(transport[0]), nil
+		}
+	default:
+		return 0
+	}
+	if byAddress {
+		if compareEndpoints(src, nil, dst, nil) > 0 {
+			src, dst = dst, src
+		}
+		h := fnvAdd(fnvOffset, src)
+		h = fnvAdd(h, dst)
+		return fnvAdd(h, []byte{byte(protocol)})
+	}
+
+	// Order the endpoints, so both directions hash the same
+	var srcPort, dstPort []byte
+	if (protocol == layers.IPProtocolTCP || protocol == layers.IPProtocolUDP) && len(transport) >= 4 {
+		srcPort, dstPort = transport[0:2], transport[2:4]
+	}
+	if compareEndpoints(src, srcPort, dst, dstPort) > 0 {
+		src, srcPort, dst, dstPort = dst, dstPort, src, srcPort
+	}
+	h := fnvAdd(fnvOffset, src)
+	h = fnvAdd(h, srcPort)
+	h = fnvAdd(h, dst)
+	h = fnvAdd(h, dstPort)
+	return h
+}
+
+// compareEndpoints compares the endpoints (a, aPort) and (b, bPort) lexicographically
+func compareEndpoints(a, aPort, b, bPort []byte) int {
+	for i := range a {
+		if a[i] != b[i] {
+			return int(a[i]) - int(b[i])
+		}
+	}
+	for i := range aPort {
+		if aPort[i] != bPort[i] {
+			return int(aPort[i]) - int(bPort[i])
+		}
+	}
+	return 0
+}
+
+// Parameters of the 32 bit FNV-1a hash
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+// fnvAdd adds the data to the FNV-1a hash h without allocating
+func fnvAdd(h uint32, data []byte) uint32 {
+	for _, b := range data {
+		h ^= uint32(b)
+		h *= fnvPrime
+	}
+	return h
+}
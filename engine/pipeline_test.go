@@ -0,0 +1,211 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"testing"
+)
+
+// flowPacket returns the layers of an ETH/IPv4/TCP packet of the flow from the source port carrying payload
+func flowPacket(srcPort uint16, payload []byte) testPacket {
+	p := tcpPacket(payload)
+	p.layers[2].(*layers.TCP).SrcPort = layers.TCPPort(srcPort)
+	return p
+}
+
+// flowPackets returns packets of the number of flows, each starting with a Client Hello followed by segments of
+// application data of the given size. The flows are interleaved and all of them are between the same two hosts.
+func flowPackets(flows, packetsPerFlow, dataSize int) []testPacket {
+	var packets []testPacket
+	data := bytes.Repeat([]byte{23}, dataSize)
+	for i := 0; i < packetsPerFlow; i++ {
+		for flow := 0; flow < flows; flow++ {
+			if i == 0 {
+				packets = append(packets, flowPacket(uint16(40000+flow), googleClientHello))
+			} else {
+				packets = append(packets, flowPacket(uint16(40000+flow), data))
+			}
+		}
+	}
+	return packets
+}
+
+func TestPipeline(t *testing.T) {
+	/*
+		The pipeline has to find the same records in the same order as a single engine, also for fragmented packets.
+	*/
+	packets := concat(flowPackets(50, 3, 100), ipv4Fragments(t, 1, 2, 0), flowPackets(3, 1, 0),
+		ipv4Fragments(t, 1, 3, 1), ipv6Fragments(t, 2, 1, 0, 3, 2))
+	expected := collect(t, &Engine{}, testCapture(t, layers.LinkTypeEthernet, packets...))
+	if len(expected) != 55 {
+		t.Fatalf("Expected: %v records but got: %v\n", 55, len(expected))
+	}
+
+	for _, workers := range []int{0, 1, 3, 8} {
+		var records []Record
+		p := &Pipeline{Workers: workers, NewEngine: func() *Engine { return &Engine{Filter: DefaultFilter} }}
+		err := p.Run(context.Background(), testCapture(t, layers.LinkTypeEthernet, packets...), func(r Record) {
+			records = append(records, r)
+		})
+		if err != nil {
+			t.Fatalf("Workers %v: Expected: %v but got: %v\n", workers, nil, err)
+		}
+		if len(records) != len(expected) {
+			t.Fatalf("Workers %v: Expected: %v records but got: %v\n", workers, len(expected), len(records))
+		}
+		for i, r := range records {
+			if !r.Timestamp.Equal(expected[i].Timestamp) || r.SrcPort != expected[i].SrcPort ||
+				r.JA3.GetJA3Hash() != googleJA3Hash || !bytes.Equal(r.Payload, expected[i].Payload) {
+				t.Errorf("Workers %v: Expected: %v but got: %v\n", workers, expected[i].Flow, r.Flow)
+			}
+		}
+		if stats := p.DefragStats(); stats.Reassembled != 2 {
+			t.Errorf("Workers %v: Expected: %v but got: %v\n", workers, 2, stats.Reassembled)
+		}
+		if stats := p.DecodeStats(); stats.Packets != uint64(len(packets)) || stats.ClientHellos != 55 {
+			t.Errorf("Workers %v: Expected: %v packets and %v Client Hellos but got: %+v\n", workers, len(packets), 55,
+				stats)
+		}
+	}
+}
+
+func TestPipelineCancel(t *testing.T) {
+	/*
+		The pipeline stops calling the handler once the context is done.
+	*/
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	calls := 0
+	p := &Pipeline{Workers: 4}
+	err := p.Run(ctx, testCapture(t, layers.LinkTypeEthernet, flowPackets(20, 1, 0)...), func(r Record) {
+		calls++
+		cancel()
+	})
+	if err != context.Canceled || calls != 1 {
+		t.Errorf("Expected: %v after %v call but got: %v after %v\n", context.Canceled, 1, err, calls)
+	}
+}
+
+func TestFlowHash(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Both directions of a flow hash the same, fragments hash by their addresses only. Hashed by addresses, fragments
+		hash the same as the unfragmented packets of their flow.
+	*/
+	type flowHashTestContainer struct {
+		linkType  layers.LinkType
+		a, b      []gopacket.SerializableLayer
+		byAddress bool
+		expEqual  bool
+	}
+	reply := func(srcPort, dstPort layers.TCPPort) []gopacket.SerializableLayer {
+		p := tcpPacket(nil).layers
+		ip, tcp := p[1].(*layers.IPv4), p[2].(*layers.TCP)
+		ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+		tcp.SrcPort, tcp.DstPort = srcPort, dstPort
+		return p
+	}
+	vlan := withHeaders(tcpPacket(nil).layers[1:], testEthernet(layers.EthernetTypeDot1Q),
+		&layers.Dot1Q{VLANIdentifier: 10, Type: layers.EthernetTypeIPv4})
+	fragments := ipv4Fragments(t, 1, 0, 1)
+	ipv6Fragment := ipv6Fragments(t, 1, 1)[0].layers[1:]
+
+	var flowHashTestSet = map[string]flowHashTestContainer{
+		"Reply":                               {layers.LinkTypeEthernet, tcpPacket(nil).layers, reply(443, 34577), false, true},
+		"Other flow":                          {layers.LinkTypeEthernet, tcpPacket(nil).layers, reply(443, 34578), false, false},
+		"VLAN":                                {layers.LinkTypeEthernet, tcpPacket(nil).layers, vlan, false, true},
+		"Raw":                                 {layers.LinkTypeRaw, tcpPacket(nil).layers[1:], reply(443, 34577)[1:], false, true},
+		"Fragments":                           {layers.LinkTypeEthernet, fragments[0].layers, fragments[1].layers, false, true},
+		"IPv6 with header":                    {layers.LinkTypeRaw, ipv6TCP(), ipv6TCP(), false, true},
+		"Fragment and packet":                 {layers.LinkTypeEthernet, fragments[1].layers, tcpPacket(nil).layers, false, false},
+		"Fragment and packet by address":      {layers.LinkTypeEthernet, fragments[1].layers, tcpPacket(nil).layers, true, true},
+		"Reply by address":                    {layers.LinkTypeEthernet, tcpPacket(nil).layers, reply(443, 34578), true, true},
+		"IPv6 fragment and packet by address": {layers.LinkTypeRaw, ipv6Fragment, ipv6TCP(), true, true},
+	}
+
+	// Run through all test cases
+	for name, test := range flowHashTestSet {
+		a := flowHash(test.linkType, serialize(t, test.a...), test.byAddress)
+		b := flowHash(test.linkType, serialize(t, test.b...), test.byAddress)
+		if (a == b) != test.expEqual {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expEqual, a == b)
+		}
+	}
+}
+
+// benchmarkCapture is a capture with 1000 flows of 20 packets each between the same two hosts, as behind a NAT, so
+// the pipelines with the default configuration only scale if they distribute the flows by their ports
+var benchmarkCapture []byte
+
+// benchmarkData returns the benchmark capture, which is built on first use
+func benchmarkData(b *testing.B) []byte {
+	if benchmarkCapture == nil {
+		benchmarkCapture = testCaptureData(b, uint32(layers.LinkTypeEthernet), flowPackets(1000, 20, 1000)...)
+	}
+	return benchmarkCapture
+}
+
+// runBenchmark runs run on the benchmark capture and reports the throughput
+func runBenchmark(b *testing.B, run func(Reader) error) {
+	benchmarkData(b)
+	b.SetBytes(int64(len(benchmarkCapture)))
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r, err := ReadPcapFile(bytes.NewReader(benchmarkCapture))
+		if err != nil {
+			b.Fatal(err)
+		}
+		if err := run(r); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRun(b *testing.B) {
+	e := &Engine{}
+	runBenchmark(b, func(r Reader) error {
+		return e.Run(context.Background(), r, func(Record) {})
+	})
+}
+
+func BenchmarkPipeline(b *testing.B) {
+	// The share of the packets decoded by the busiest worker shows how well the flows are spread, also on machines
+	// with fewer cores than workers
+	configs := map[string]func() *Engine{
+		"default":  nil,
+		"sessions": func() *Engine { return &Engine{Sessions: true} },
+	}
+	for name, newEngine := range configs {
+		for _, workers := range []int{1, 2, 4, 8} {
+			b.Run(fmt.Sprintf("%v/workers=%v", name, workers), func(b *testing.B) {
+				p := &Pipeline{Workers: workers, NewEngine: newEngine}
+				runBenchmark(b, func(r Reader) error {
+					return p.Run(context.Background(), r, func(Record) {})
+				})
+				// Every run has its own engines, which all decode the same share of the packets
+				var busiest, total uint64
+				for _, e := range p.engines[:workers] {
+					packets := e.DecodeStats().Packets
+					total += packets
+					if packets > busiest {
+						busiest = packets
+					}
+				}
+				if total > 0 {
+					b.ReportMetric(float64(busiest)/float64(total), "busiest-share")
+				}
+			})
+		}
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"github.com/google/gopacket/pcapgo"
+	"io"
+	"time"
+)
+
+// Reader provides an uniform interface when reading from different sources. A Reader may return an error with a
+// Timeout() method reporting true to signal that no packet arrived in time, the engine then retries reading.
+type Reader interface {
+	ZeroCopyReadPacketData() ([]byte, gopacket.CaptureInfo, error)
+}
+
+// interfaceNamer is implemented by readers which know the names of the interfaces the packets were captured on
+type interfaceNamer interface {
+	InterfaceName(index int) string
+}
+
+// linkTyper is implemented by readers which know the link type of the captured packets, like pcap files and handles
+type linkTyper interface {
+	LinkType() layers.LinkType
+}
+
+// interfaceLinkTyper is implemented by readers whose interfaces can have different link types
+type interfaceLinkTyper interface {
+	InterfaceLinkType(index int) layers.LinkType
+}
+
+// source resolves the link type and the interface name of the packets of a reader
+type source struct {
+	linkType           layers.LinkType
+	interfaceLinkTypes interfaceLinkTyper
+	namer              interfaceNamer
+}
+
+// newSource returns the source of the reader, readers which do not report their link type deliver Ethernet frames
+func newSource(reader Reader) source {
+	s := source{linkType: layers.LinkTypeEthernet}
+	if lt, ok := reader.(linkTyper); ok {
+		s.linkType = lt.LinkType()
+	}
+	s.interfaceLinkTypes, _ = reader.(interfaceLinkTyper)
+	s.namer, _ = reader.(interfaceNamer)
+	return s
+}
+
+// packetLinkType returns the link type of the packets captured on the interface with the index
+func (s source) packetLinkType(index int) layers.LinkType {
+	if s.interfaceLinkTypes != nil {
+		return s.interfaceLinkTypes.InterfaceLinkType(index)
+	}
+	return s.linkType
+}
+
+// interfaceName returns the name of the interface with the index or an empty string if it is unknown
+func (s source) interfaceName(index int) string {
+	if s.namer != nil {
+		return s.namer.InterfaceName(index)
+	}
+	return ""
+}
+
+// CaptureStats are the statistics of a live capture.
+type CaptureStats struct {
+	// Packets is the number of packets received by the reader
+	Packets uint64
+	// Drops is the number of packets dropped by the kernel, because they were not read in time
+	Drops uint64
+	// InterfaceDrops is the number of packets dropped by the network interface or its driver, if reported
+	InterfaceDrops uint64
+}
+
+// StatsReader is implemented by readers capturing from an interface, which report the statistics of the capture.
+type StatsReader interface {
+	Reader
+	Stats() (CaptureStats, error)
+}
+
+// liveReadTimeout is the time after which reading from an interface returns, so the engine can react to cancellation
+const liveReadTimeout = 250 * time.Millisecond
+
+// ErrUnknownFormat is returned by ReadFile if the file is neither in pcap nor in pcapng format.
+var ErrUnknownFormat = errors.New("unknown capture file format")
+
+// Magic numbers of the supported capture file formats
+const (
+	pcapMagic      uint32 = 0xa1b2c3d4
+	pcapNanoMagic  uint32 = 0xa1b23c4d
+	pcapngBlockSHB uint32 = 0x0a0d0d0a
+)
+
+// ReadFile detects whether the supplied file is in pcap or pcapng format and returns the respective reader. Files
+// compressed with gzip, zstd or xz are decompressed transparently.
+func ReadFile(file io.Reader) (Reader, error) {
+	br := bufio.NewReader(file)
+	decompressed, err := decompress(br)
+	if err != nil {
+		return nil, err
+	}
+	if decompressed != io.Reader(br) {
+		br = bufio.NewReader(decompressed)
+	}
+	magic, err := br.Peek(4)
+	if err != nil {
+		if err == io.EOF {
+			return nil, ErrUnknownFormat
+		}
+		return nil, err
+	}
+	switch binary.BigEndian.Uint32(magic) {
+	case pcapMagic, pcapNanoMagic, swap32(pcapMagic), swap32(pcapNanoMagic):
+		return ReadPcapFile(br)
+	case pcapngBlockSHB:
+		return ReadPcapngFile(br)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// swap32 returns v with reversed byte order
+func swap32(v uint32) uint32 {
+	return v>>24 | v>>8&0xff00 | v<<8&0xff0000 | v<<24
+}
+
+// ReadPcapFile returns a reader for the supplied pcap file.
+func ReadPcapFile(file io.Reader) (Reader, error) {
+	return pcapgo.NewReader(file)
+}
+
+// ReadPcapngFile returns a reader for the supplied pcapng file.
+func ReadPcapngFile(file io.Reader) (Reader, error) {
+	r, err := pcapgo.NewNgReader(file, pcapgo.DefaultNgReaderOptions)
+	if err != nil {
+		return nil, err
+	}
+	return &ngReader{r}, nil
+}
+
+// ngReader reports the interface names stored in the pcapng file
+type ngReader struct {
+	*pcapgo.NgReader
+}
+
+// InterfaceName returns the name of the interface with the index
+func (r *ngReader) InterfaceName(index int) string {
+	intf, err := r.Interface(index)
+	if err != nil {
+		return ""
+	}
+	return intf.Name
+}
+
+// InterfaceLinkType returns the link type of the interface with the index
+func (r *ngReader) InterfaceLinkType(index int) layers.LinkType {
+	intf, err := r.Interface(index)
+	if err != nil {
+		return r.LinkType()
+	}
+	return intf.LinkType
+}
+
+// errTimeout signals that no packet arrived in time
+type errTimeout struct{}
+
+func (errTimeout) Error() string {
+	return "read timeout expired"
+}
+
+func (errTimeout) Timeout() bool {
+	return true
+}
+
+// isTimeout reports whether err only signals that no packet arrived in time
+func isTimeout(err error) bool {
+	t, ok := err.(interface{ Timeout() bool })
+	return ok && t.Timeout()
+}
@@ -0,0 +1,243 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"container/list"
+	"github.com/google/gopacket/layers"
+	"github.com/open-ch/ja3"
+	"sort"
+	"time"
+)
+
+// Defaults of the session tracking
+const (
+	DefaultSessionTimeout = 30 * time.Second
+	DefaultMaxSessions    = 1 << 16
+)
+
+// Content types of the TLS records checked by the session tracking
+const (
+	tlsRecordChangeCipherSpec uint8 = 20
+	tlsRecordAlert            uint8 = 21
+	tlsRecordApplicationData  uint8 = 23
+)
+
+// Limits of the TLS records accepted at the start of a segment
+const (
+	tlsRecordHeaderLen = 5
+	// tlsRecordMaxLen is the maximum length of an encrypted record (RFC 5246, section 6.2.3)
+	tlsRecordMaxLen = 1<<14 + 2048
+)
+
+// HandshakeStatus is the outcome of the TLS handshake of a session.
+type HandshakeStatus string
+
+// Outcomes of the TLS handshake of a session
+const (
+	// HandshakeCompleted is the status of a session in which both sides switched to the negotiated keys after the
+	// Server Hello
+	HandshakeCompleted HandshakeStatus = "completed"
+	// HandshakeAlert is the status of a session ended by an alert before the handshake completed
+	HandshakeAlert HandshakeStatus = "alert"
+	// HandshakeReset is the status of a session ended by a TCP reset before the handshake completed
+	HandshakeReset HandshakeStatus = "reset"
+	// HandshakeIncomplete is the status of a session closed, timed out or still open when the engine stopped before
+	// the handshake completed
+	HandshakeIncomplete HandshakeStatus = "incomplete"
+)
+
+// Session describes the TLS session a Client Hello started.
+type Session struct {
+	// JA3S of the Server Hello or nil if the server did not answer
+	JA3S *ja3.JA3S
+	// Status is the outcome of the handshake
+	Status HandshakeStatus
+	// Alert is the description of the alert which ended the handshake or -1 if it is unknown, e.g. because the alert
+	// was encrypted
+	Alert int
+	// End is the timestamp of the packet which completed or ended the handshake or, for sessions which timed out or
+	// were still open, of the last packet of the session
+	End time.Time
+}
+
+// sessionKey identifies the TCP flow of a session in both directions
+type sessionKey struct {
+	a, b         [16]byte
+	aPort, bPort uint16
+}
+
+// newSessionKey returns the key of the flow, which is the same for both directions
+func newSessionKey(flow Flow) sessionKey {
+	var k sessionKey
+	copy(k.a[:], flow.SrcIP.To16())
+	copy(k.b[:], flow.DstIP.To16())
+	k.aPort, k.bPort = flow.SrcPort, flow.DstPort
+	if compareEndpoints(k.a[:], []byte{byte(k.aPort >> 8), byte(k.aPort)}, k.b[:],
+		[]byte{byte(k.bPort >> 8), byte(k.bPort)}) > 0 {
+		k.a, k.aPort, k.b, k.bPort = k.b, k.bPort, k.a, k.aPort
+	}
+	return k
+}
+
+// session is a TLS session whose handshake is in progress
+type session struct {
+	key sessionKey
+	// record of the Client Hello, its Session is filled in when the handshake ends
+	record Record
+	// clientDone and serverDone are set once the side switched to the negotiated keys after the Server Hello
+	clientDone, serverDone bool
+	lastSeen               time.Time
+	element                *list.Element
+}
+
+// sessionTable correlates the Client Hellos with the rest of the handshakes of their flows. Sessions without packets
+// for the timeout, which is measured in capture time, end as incomplete. If the table is full, the session without
+// packets for the longest time ends early.
+type sessionTable struct {
+	timeout time.Duration
+	max     int
+	// sessions by key and ordered by the time of their last packet, oldest first
+	sessions map[sessionKey]*session
+	order    list.List
+}
+
+// newSessionTable returns a session table with the timeout and the maximum number of sessions, zero values select
+// the defaults
+func newSessionTable(timeout time.Duration, max int) *sessionTable {
+	if timeout <= 0 {
+		timeout = DefaultSessionTimeout
+	}
+	if max <= 0 {
+		max = DefaultMaxSessions
+	}
+	return &sessionTable{timeout: timeout, max: max, sessions: make(map[sessionKey]*session)}
+}
+
+// clientHello starts a session with the record of a Client Hello, the record has to be a clone. A session already
+// answered by the server on the same flow ends as incomplete, otherwise the new Client Hello is the answer to a
+// HelloRetryRequest or a retransmission and the session continues.
+func (t *sessionTable) clientHello(record Record, handler func(Record)) {
+	key := newSessionKey(record.Flow)
+	if s, ok := t.sessions[key]; ok {
+		if s.record.Session.JA3S == nil {
+			t.touch(s, record.Timestamp)
+			return
+		}
+		t.end(s, HandshakeIncomplete, -1, s.lastSeen, handler)
+	}
+	for len(t.sessions) >= t.max {
+		s := t.order.Front().Value.(*session)
+		t.end(s, HandshakeIncomplete, -1, s.lastSeen, handler)
+	}
+
+	record.Session = &Session{Alert: -1}
+	s := &session{key: key, record: record, lastSeen: record.Timestamp}
+	s.element = t.order.PushBack(s)
+	t.sessions[key] = s
+}
+
+// segment follows the handshake of the session of the TCP segment, if any, and calls handler once it ended
+func (t *sessionTable) segment(flow Flow, tcp *layers.TCP, timestamp time.Time, handler func(Record)) {
+	s, ok := t.sessions[newSessionKey(flow)]
+	if !ok {
+		return
+	}
+	t.touch(s, timestamp)
+	fromClient := flow.SrcPort == s.record.SrcPort && flow.SrcIP.Equal(s.record.SrcIP)
+
+	// Only the records starting at the start of the segment can be found, records spanning segments hide the
+	// records following them
+	payload := tcp.Payload
+	for len(payload) >= tlsRecordHeaderLen && payload[1] == 3 && payload[2] <= 4 {
+		contentType := payload[0]
+		length := int(payload[3])<<8 | int(payload[4])
+		if contentType < tlsRecordChangeCipherSpec || contentType > tlsRecordApplicationData || length > tlsRecordMaxLen {
+			break
+		}
+		switch contentType {
+		case uint8(tlsRecordHandshake):
+			// The Server Hello answering a HelloRetryRequest replaces it
+			if !fromClient && s.record.Session.JA3S == nil {
+				if j, err := ja3.ComputeJA3SFromSegment(payload); err == nil && !j.IsHelloRetryRequest() {
+					s.record.Session.JA3S = j
+				}
+			}
+		case tlsRecordChangeCipherSpec, tlsRecordApplicationData:
+			// Middlebox compatible TLS 1.3 sends a ChangeCipherSpec after a HelloRetryRequest as well
+			if s.record.Session.JA3S != nil {
+				s.clientDone = s.clientDone || fromClient
+				s.serverDone = s.serverDone || !fromClient
+			}
+			if s.clientDone && s.serverDone {
+				t.end(s, HandshakeCompleted, -1, timestamp, handler)
+				return
+			}
+		case tlsRecordAlert:
+			// Alerts sent after the keys were switched are encrypted
+			alert := -1
+			if length == 2 && len(payload) >= tlsRecordHeaderLen+2 {
+				alert = int(payload[tlsRecordHeaderLen+1])
+			}
+			t.end(s, HandshakeAlert, alert, timestamp, handler)
+			return
+		}
+		if len(payload) < tlsRecordHeaderLen+length {
+			break
+		}
+		payload = payload[tlsRecordHeaderLen+length:]
+	}
+
+	if tcp.RST {
+		t.end(s, HandshakeReset, -1, timestamp, handler)
+	} else if tcp.FIN {
+		t.end(s, HandshakeIncomplete, -1, timestamp, handler)
+	}
+}
+
+// touch records a packet of the session at timestamp
+func (t *sessionTable) touch(s *session, timestamp time.Time) {
+	if timestamp.After(s.lastSeen) {
+		s.lastSeen = timestamp
+	}
+	t.order.MoveToBack(s.element)
+}
+
+// end the session with the status and call handler with its record
+func (t *sessionTable) end(s *session, status HandshakeStatus, alert int, timestamp time.Time, handler func(Record)) {
+	t.order.Remove(s.element)
+	delete(t.sessions, s.key)
+	s.record.Session.Status = status
+	s.record.Session.Alert = alert
+	s.record.Session.End = timestamp
+	handler(s.record)
+}
+
+// expire ends the sessions without packets for longer than the timeout as incomplete
+func (t *sessionTable) expire(timestamp time.Time, handler func(Record)) {
+	for e := t.order.Front(); e != nil; e = t.order.Front() {
+		s := e.Value.(*session)
+		if timestamp.Sub(s.lastSeen) <= t.timeout {
+			return
+		}
+		t.end(s, HandshakeIncomplete, -1, s.lastSeen, handler)
+	}
+}
+
+// flush ends all sessions as incomplete in the order of their Client Hellos
+func (t *sessionTable) flush(handler func(Record)) {
+	open := make([]*session, 0, len(t.sessions))
+	for e := t.order.Front(); e != nil; e = e.Next() {
+		open = append(open, e.Value.(*session))
+	}
+	sort.SliceStable(open, func(a, b int) bool {
+		return open[a].record.Timestamp.Before(open[b].record.Timestamp)
+	})
+	for _, s := range open {
+		t.end(s, HandshakeIncomplete, -1, s.lastSeen, handler)
+	}
+}
@@ -0,0 +1,214 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"context"
+	"github.com/google/gopacket/layers"
+	"testing"
+	"time"
+)
+
+// serverHello returns a TLS record with a Server Hello with the random, cipher suite and extensions
+func serverHello(random byte, cipherSuite uint16, extensions []byte) []byte {
+	body := []byte{3, 3}
+	for i := 0; i < 32; i++ {
+		body = append(body, random)
+	}
+	body = append(body, 0, byte(cipherSuite>>8), byte(cipherSuite), 0, byte(len(extensions)>>8), byte(len(extensions)))
+	body = append(body, extensions...)
+	hs := append([]byte{2, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{22, 3, 3, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+// serverPacket returns the packet of the server answering the flow of tcpPacket from the source port
+func serverPacket(srcPort uint16, payload []byte) testPacket {
+	p := tcpPacket(payload)
+	ip := p.layers[1].(*layers.IPv4)
+	ip.SrcIP, ip.DstIP = ip.DstIP, ip.SrcIP
+	tcp := p.layers[2].(*layers.TCP)
+	tcp.SrcPort, tcp.DstPort = tcp.DstPort, layers.TCPPort(srcPort)
+	return p
+}
+
+// withFlags sets the FIN or RST flag of the packet
+func withFlags(p testPacket, fin, rst bool) testPacket {
+	tcp := p.layers[2].(*layers.TCP)
+	tcp.FIN, tcp.RST = fin, rst
+	return p
+}
+
+type sessionTestContainer struct {
+	engine      *Engine
+	packets     []testPacket
+	expSrcPorts []uint16
+	expStatuses []HandshakeStatus
+	expJA3S     []string
+	expAlerts   []int
+}
+
+func TestRunSessions(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Every Client Hello has to be passed on once with the Server Hello and the outcome of its handshake.
+	*/
+	tls12 := serverHello(1, 0xc02f, []byte{0xff, 0x01, 0, 1, 0})
+	tls13 := serverHello(1, 0x1301, []byte{0, 43, 0, 2, 3, 4})
+	hrr := serverHello(0, 0x1301, []byte{0, 43, 0, 2, 3, 4})
+	copy(hrr[11:43], []byte{0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e, 0x65, 0xb8,
+		0x91, 0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c})
+	ccs := []byte{20, 3, 3, 0, 1, 1}
+	appData := []byte{23, 3, 3, 0, 2, 42, 42}
+	tls12Hash := "fbe78c619e7ea20046131294ad087f05"
+	tls13Hash := "cce84e7a8b742462e40afb585a3e3ccc"
+
+	var sessionTestSet = map[string]sessionTestContainer{
+		"TLS 1.2 completed": {
+			engine: &Engine{Sessions: true},
+			packets: []testPacket{tcpPacket(googleClientHello), serverPacket(34577, tls12), tcpPacket(ccs),
+				serverPacket(34577, ccs), tcpPacket(appData)},
+			expSrcPorts: []uint16{34577},
+			expStatuses: []HandshakeStatus{HandshakeCompleted},
+			expJA3S:     []string{tls12Hash},
+			expAlerts:   []int{-1},
+		},
+		"TLS 1.3 completed after HelloRetryRequest": {
+			engine: &Engine{Sessions: true},
+			packets: []testPacket{tcpPacket(googleClientHello), serverPacket(34577, append(hrr, ccs...)),
+				tcpPacket(append(ccs, googleClientHello...)), serverPacket(34577, append(append(tls13, ccs...), appData...)),
+				tcpPacket(append(ccs, appData...))},
+			expSrcPorts: []uint16{34577},
+			expStatuses: []HandshakeStatus{HandshakeCompleted},
+			expJA3S:     []string{tls13Hash},
+			expAlerts:   []int{-1},
+		},
+		"Alert": {
+			engine:      &Engine{Sessions: true},
+			packets:     []testPacket{tcpPacket(googleClientHello), serverPacket(34577, []byte{21, 3, 3, 0, 2, 2, 40})},
+			expSrcPorts: []uint16{34577},
+			expStatuses: []HandshakeStatus{HandshakeAlert},
+			expJA3S:     []string{""},
+			expAlerts:   []int{40},
+		},
+		"Reset": {
+			engine:      &Engine{Sessions: true, Filter: DefaultFilter},
+			packets:     []testPacket{tcpPacket(googleClientHello), serverPacket(34577, tls12), withFlags(serverPacket(34577, nil), false, true)},
+			expSrcPorts: []uint16{34577},
+			expStatuses: []HandshakeStatus{HandshakeReset},
+			expJA3S:     []string{tls12Hash},
+			expAlerts:   []int{-1},
+		},
+		"Closed": {
+			engine:      &Engine{Sessions: true, Filter: DefaultFilter},
+			packets:     []testPacket{tcpPacket(googleClientHello), withFlags(tcpPacket(nil), true, false), serverPacket(34577, tls12)},
+			expSrcPorts: []uint16{34577},
+			expStatuses: []HandshakeStatus{HandshakeIncomplete},
+			expJA3S:     []string{""},
+			expAlerts:   []int{-1},
+		},
+		"Open at the end": {
+			engine:      &Engine{Sessions: true},
+			packets:     []testPacket{flowPacket(40001, googleClientHello), tcpPacket(googleClientHello), serverPacket(34577, tls12)},
+			expSrcPorts: []uint16{40001, 34577},
+			expStatuses: []HandshakeStatus{HandshakeIncomplete, HandshakeIncomplete},
+			expJA3S:     []string{"", tls12Hash},
+			expAlerts:   []int{-1, -1},
+		},
+		"Timed out": {
+			engine:      &Engine{Sessions: true, SessionTimeout: time.Nanosecond},
+			packets:     []testPacket{tcpPacket(googleClientHello), serverPacket(34577, tls12)},
+			expSrcPorts: []uint16{34577},
+			expStatuses: []HandshakeStatus{HandshakeIncomplete},
+			expJA3S:     []string{""},
+			expAlerts:   []int{-1},
+		},
+		"Table full": {
+			engine: &Engine{Sessions: true, MaxSessions: 1},
+			packets: []testPacket{tcpPacket(googleClientHello), flowPacket(40001, googleClientHello),
+				serverPacket(40001, tls12), flowPacket(40001, ccs), serverPacket(40001, ccs)},
+			expSrcPorts: []uint16{34577, 40001},
+			expStatuses: []HandshakeStatus{HandshakeIncomplete, HandshakeCompleted},
+			expJA3S:     []string{"", tls12Hash},
+			expAlerts:   []int{-1, -1},
+		},
+		"New Client Hello": {
+			engine:      &Engine{Sessions: true},
+			packets:     []testPacket{tcpPacket(googleClientHello), serverPacket(34577, tls12), tcpPacket(googleClientHello)},
+			expSrcPorts: []uint16{34577, 34577},
+			expStatuses: []HandshakeStatus{HandshakeIncomplete, HandshakeIncomplete},
+			expJA3S:     []string{tls12Hash, ""},
+			expAlerts:   []int{-1, -1},
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range sessionTestSet {
+		records := collect(t, test.engine, testCapture(t, layers.LinkTypeEthernet, test.packets...))
+		if len(records) != len(test.expStatuses) {
+			t.Errorf("%v: Expected: %v records but got: %v\n", name, len(test.expStatuses), len(records))
+			continue
+		}
+		for i, r := range records {
+			if r.Session == nil {
+				t.Errorf("%v: Expected: %v but got: %v\n", name, test.expStatuses[i], nil)
+				continue
+			}
+			ja3s := ""
+			if r.Session.JA3S != nil {
+				ja3s = r.Session.JA3S.GetJA3SHash()
+			}
+			if r.SrcPort != test.expSrcPorts[i] || r.JA3.GetJA3Hash() != googleJA3Hash {
+				t.Errorf("%v: Expected: %v but got: %v\n", name, test.expSrcPorts[i], r.SrcPort)
+			}
+			if r.Session.Status != test.expStatuses[i] || ja3s != test.expJA3S[i] || r.Session.Alert != test.expAlerts[i] {
+				t.Errorf("%v: Expected: %v %v %v but got: %v %v %v\n", name, test.expStatuses[i], test.expJA3S[i],
+					test.expAlerts[i], r.Session.Status, ja3s, r.Session.Alert)
+			}
+		}
+	}
+}
+
+func TestPipelineSessions(t *testing.T) {
+	/*
+		The pipeline has to pass on the same sessions in the same order as a single engine.
+	*/
+	var packets []testPacket
+	for i := 0; i < 20; i++ {
+		packets = append(packets, flowPacket(uint16(40000+i), googleClientHello))
+		if i%2 == 0 {
+			packets = append(packets, serverPacket(uint16(40000+i), serverHello(1, 0xc02f, nil)),
+				flowPacket(uint16(40000+i), []byte{20, 3, 3, 0, 1, 1}),
+				serverPacket(uint16(40000+i), []byte{20, 3, 3, 0, 1, 1}))
+		}
+	}
+	newEngine := func() *Engine { return &Engine{Sessions: true, Filter: DefaultFilter} }
+	expected := collect(t, newEngine(), testCapture(t, layers.LinkTypeEthernet, packets...))
+	if len(expected) != 20 {
+		t.Fatalf("Expected: %v records but got: %v\n", 20, len(expected))
+	}
+
+	for _, workers := range []int{1, 3, 8} {
+		var records []Record
+		p := &Pipeline{Workers: workers, NewEngine: newEngine}
+		err := p.Run(context.Background(), testCapture(t, layers.LinkTypeEthernet, packets...), func(r Record) {
+			records = append(records, r)
+		})
+		if err != nil {
+			t.Fatalf("Workers %v: Expected: %v but got: %v\n", workers, nil, err)
+		}
+		if len(records) != len(expected) {
+			t.Fatalf("Workers %v: Expected: %v records but got: %v\n", workers, len(expected), len(records))
+		}
+		for i, r := range records {
+			if r.SrcPort != expected[i].SrcPort || r.Session.Status != expected[i].Session.Status {
+				t.Errorf("Workers %v: Expected: %v %v but got: %v %v\n", workers, expected[i].SrcPort,
+					expected[i].Session.Status, r.SrcPort, r.Session.Status)
+			}
+		}
+	}
+}
@@ -0,0 +1,227 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"encoding/binary"
+	"errors"
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+)
+
+// TunnelType identifies the encapsulation of a tunnel.
+type TunnelType string
+
+// Tunnel types
+const (
+	TunnelGRE    TunnelType = "gre"
+	TunnelERSPAN TunnelType = "erspan"
+	TunnelVXLAN  TunnelType = "vxlan"
+	TunnelGeneve TunnelType = "geneve"
+	TunnelIPinIP TunnelType = "ipip"
+	TunnelGTPU   TunnelType = "gtpu"
+)
+
+// Tunnel describes a tunnel a Client Hello was encapsulated in.
+type Tunnel struct {
+	// Flow is the outer tuple of the tunnel, the ports are zero for tunnels which are not carried over UDP
+	Flow
+	// Type of the tunnel
+	Type TunnelType
+	// ID is the VNI for VXLAN and GENEVE, the TEID for GTP-U, the session ID for ERSPAN and the key for GRE, or zero if
+	// the tunnel has no ID
+	ID uint32
+}
+
+// Header lengths of the tunnel layers
+const (
+	geneveHeaderLen int = 8
+	gtpuHeaderLen   int = 8
+	gtpuOptionalLen int = 4
+)
+
+// GTP-U header fields
+const (
+	gtpuMessageTPDU   uint8 = 0xff
+	gtpuFlagsOptional uint8 = 0x07
+	gtpuFlagExtension uint8 = 0x04
+)
+
+// tracker follows the decoded layers of a packet and keeps the tuple of the innermost flow and the tunnels passed on
+// the way to it
+type tracker struct {
+	flow    Flow
+	tunnels []Tunnel
+	// encapsulated is set if the last layer was a tunnel header, so the next IP layer does not start an IP-in-IP tunnel
+	encapsulated bool
+}
+
+// reset the tracker for the next packet, reusing the tunnel buffer
+func (t *tracker) reset() {
+	t.flow = Flow{}
+	t.tunnels = t.tunnels[:0]
+	t.encapsulated = false
+}
+
+// observe a decoded layer, the layer may be reused for the next layer afterwards
+func (t *tracker) observe(layer gopacket.Layer) {
+	switch l := layer.(type) {
+	case *layers.IPv4:
+		t.enterIP(l.SrcIP, l.DstIP)
+	case *layers.IPv6:
+		t.enterIP(l.SrcIP, l.DstIP)
+	case *layers.UDP:
+		t.flow.SrcPort, t.flow.DstPort = uint16(l.SrcPort), uint16(l.DstPort)
+	case *layers.TCP:
+		t.flow.SrcPort, t.flow.DstPort = uint16(l.SrcPort), uint16(l.DstPort)
+	case *layers.GRE:
+		var key uint32
+		if l.KeyPresent {
+			key = l.Key
+		}
+		t.enterTunnel(TunnelGRE, key)
+	case *layers.ERSPANII:
+		// ERSPAN is carried by GRE, so the GRE tunnel is refined
+		if n := len(t.tunnels); n > 0 && t.tunnels[n-1].Type == TunnelGRE {
+			t.tunnels[n-1].Type = TunnelERSPAN
+			t.tunnels[n-1].ID = uint32(l.SessionID)
+		}
+	case *layers.VXLAN:
+		t.enterTunnel(TunnelVXLAN, l.VNI)
+	case *geneve:
+		t.enterTunnel(TunnelGeneve, l.VNI)
+	case *layers.Geneve:
+		t.enterTunnel(TunnelGeneve, l.VNI)
+	case *gtpu:
+		t.enterTunnel(TunnelGTPU, l.TEID)
+	case *layers.GTPv1U:
+		t.enterTunnel(TunnelGTPU, l.TEID)
+	}
+}
+
+// enterIP starts a new IP header, which is an IP-in-IP tunnel if it directly follows another IP header
+func (t *tracker) enterIP(src, dst net.IP) {
+	if t.flow.SrcIP != nil && !t.encapsulated {
+		t.tunnels = append(t.tunnels, Tunnel{Flow: t.flow, Type: TunnelIPinIP})
+	}
+	t.flow = Flow{SrcIP: src, DstIP: dst}
+	t.encapsulated = false
+}
+
+// enterTunnel records a tunnel with the current flow as outer tuple
+func (t *tracker) enterTunnel(tunnelType TunnelType, id uint32) {
+	t.tunnels = append(t.tunnels, Tunnel{Flow: t.flow, Type: tunnelType, ID: id})
+	t.flow = Flow{}
+	t.encapsulated = true
+}
+
+// geneve decodes the GENEVE header without its options, as the GENEVE layer of gopacket allocates the options
+type geneve struct {
+	layers.BaseLayer
+	Protocol layers.EthernetType
+	VNI      uint32
+}
+
+// LayerType returns layers.LayerTypeGeneve
+func (g *geneve) LayerType() gopacket.LayerType {
+	return layers.LayerTypeGeneve
+}
+
+// CanDecode returns layers.LayerTypeGeneve
+func (g *geneve) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeGeneve
+}
+
+// NextLayerType returns the layer type of the payload
+func (g *geneve) NextLayerType() gopacket.LayerType {
+	return g.Protocol.LayerType()
+}
+
+// DecodeFromBytes decodes the header and skips the options
+func (g *geneve) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < geneveHeaderLen {
+		df.SetTruncated()
+		return errors.New("truncated GENEVE header")
+	}
+	headerLen := geneveHeaderLen + int(data[0]&0x3f)*4
+	if len(data) < headerLen {
+		df.SetTruncated()
+		return errors.New("truncated GENEVE options")
+	}
+	g.Protocol = layers.EthernetType(binary.BigEndian.Uint16(data[2:4]))
+	g.VNI = binary.BigEndian.Uint32(data[4:8]) >> 8
+	g.BaseLayer = layers.BaseLayer{Contents: data[:headerLen], Payload: data[headerLen:]}
+	return nil
+}
+
+// gtpu decodes the GTP-U header of T-PDUs, as the GTPv1U layer of gopacket accumulates the extension headers
+type gtpu struct {
+	layers.BaseLayer
+	TEID uint32
+}
+
+// LayerType returns layers.LayerTypeGTPv1U
+func (g *gtpu) LayerType() gopacket.LayerType {
+	return layers.LayerTypeGTPv1U
+}
+
+// CanDecode returns layers.LayerTypeGTPv1U
+func (g *gtpu) CanDecode() gopacket.LayerClass {
+	return layers.LayerTypeGTPv1U
+}
+
+// NextLayerType returns the layer type of the user payload, which is guessed from the IP version
+func (g *gtpu) NextLayerType() gopacket.LayerType {
+	if len(g.Payload) == 0 {
+		return gopacket.LayerTypeZero
+	}
+	return ipLayerType(g.Payload)
+}
+
+// DecodeFromBytes decodes the header and skips the optional fields and extension headers
+func (g *gtpu) DecodeFromBytes(data []byte, df gopacket.DecodeFeedback) error {
+	if len(data) < gtpuHeaderLen {
+		df.SetTruncated()
+		return errors.New("truncated GTP-U header")
+	}
+	if data[0]>>5 != 1 || data[1] != gtpuMessageTPDU {
+		return errors.New("GTP-U packet is not a version 1 T-PDU")
+	}
+	g.TEID = binary.BigEndian.Uint32(data[4:8])
+
+	headerLen := gtpuHeaderLen
+	if data[0]&gtpuFlagsOptional != 0 {
+		headerLen += gtpuOptionalLen
+		if len(data) < headerLen {
+			df.SetTruncated()
+			return errors.New("truncated GTP-U header")
+		}
+		// Skip the extension headers, each one ends with the type of the next one
+		for data[0]&gtpuFlagExtension != 0 && data[headerLen-1] != 0 {
+			if len(data) <= headerLen || data[headerLen] == 0 {
+				df.SetTruncated()
+				return errors.New("truncated GTP-U extension header")
+			}
+			headerLen += int(data[headerLen]) * 4
+			if len(data) < headerLen {
+				df.SetTruncated()
+				return errors.New("truncated GTP-U extension header")
+			}
+		}
+	}
+
+	end := gtpuHeaderLen + int(binary.BigEndian.Uint16(data[2:4]))
+	if end < headerLen {
+		return errors.New("GTP-U length too short")
+	} else if end > len(data) {
+		df.SetTruncated()
+		end = len(data)
+	}
+	g.BaseLayer = layers.BaseLayer{Contents: data[:headerLen], Payload: data[headerLen:end]}
+	return nil
+}
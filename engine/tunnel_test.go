@@ -0,0 +1,124 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package engine
+
+import (
+	"github.com/google/gopacket"
+	"github.com/google/gopacket/layers"
+	"net"
+	"reflect"
+	"testing"
+)
+
+var (
+	testOuterSrcIP = net.IP{10, 0, 0, 1}
+	testOuterDstIP = net.IP{10, 0, 0, 2}
+)
+
+type tunnelTestContainer struct {
+	layers     []gopacket.SerializableLayer
+	expTunnels []Tunnel
+}
+
+// outerIPv4 returns the outer IPv4 header of a tunnel carrying the protocol
+func outerIPv4(protocol layers.IPProtocol) *layers.IPv4 {
+	return &layers.IPv4{Version: 4, TTL: 64, Protocol: protocol, SrcIP: testOuterSrcIP, DstIP: testOuterDstIP}
+}
+
+// udpTunnel returns the outer Ethernet, IPv4 and UDP headers of a tunnel to the destination port followed by the
+// tunnel headers
+func udpTunnel(dstPort layers.UDPPort, headers ...gopacket.SerializableLayer) []gopacket.SerializableLayer {
+	ip := outerIPv4(layers.IPProtocolUDP)
+	udp := &layers.UDP{SrcPort: 50000, DstPort: dstPort}
+	udp.SetNetworkLayerForChecksum(ip)
+	return append([]gopacket.SerializableLayer{testEthernet(layers.EthernetTypeIPv4), ip, udp}, headers...)
+}
+
+func TestRunTunnels(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The Client Hello has to be found in every tunnel in both modes, with the inner tuple and the outer tuples and IDs
+		of all tunnels.
+	*/
+	greFlow := Flow{SrcIP: testOuterSrcIP, DstIP: testOuterDstIP}
+	udpFlow := func(dstPort uint16) Flow {
+		return Flow{SrcIP: testOuterSrcIP, DstIP: testOuterDstIP, SrcPort: 50000, DstPort: dstPort}
+	}
+	nestedIP := &layers.IPv4{Version: 4, TTL: 64, Protocol: layers.IPProtocolUDP, SrcIP: testOuterDstIP, DstIP: testOuterSrcIP}
+	nestedUDP := &layers.UDP{SrcPort: 50000, DstPort: 4789}
+	nestedUDP.SetNetworkLayerForChecksum(nestedIP)
+
+	var tunnelTestSet = map[string]tunnelTestContainer{
+		"GRE": {
+			layers: withHeaders(ipv4TCP(),
+				testEthernet(layers.EthernetTypeIPv4),
+				outerIPv4(layers.IPProtocolGRE),
+				&layers.GRE{Protocol: layers.EthernetTypeIPv4, KeyPresent: true, Key: 42}),
+			expTunnels: []Tunnel{{Flow: greFlow, Type: TunnelGRE, ID: 42}},
+		},
+		"ERSPAN": {
+			layers: withHeaders(ipv4TCP(),
+				testEthernet(layers.EthernetTypeIPv4),
+				outerIPv4(layers.IPProtocolGRE),
+				&layers.GRE{Protocol: layers.EthernetTypeERSPAN, SeqPresent: true, Seq: 1},
+				&layers.ERSPANII{Version: 1, SessionID: 7},
+				testEthernet(layers.EthernetTypeIPv4)),
+			expTunnels: []Tunnel{{Flow: greFlow, Type: TunnelERSPAN, ID: 7}},
+		},
+		"VXLAN": {
+			layers: withHeaders(ipv4TCP(),
+				udpTunnel(4789, &layers.VXLAN{ValidIDFlag: true, VNI: 100}, testEthernet(layers.EthernetTypeIPv4))...),
+			expTunnels: []Tunnel{{Flow: udpFlow(4789), Type: TunnelVXLAN, ID: 100}},
+		},
+		"GENEVE": {
+			layers: withHeaders(ipv6TCP(),
+				udpTunnel(6081, gopacket.Payload{0x01, 0, 0x65, 0x58, 0, 0, 200, 0, 0, 0, 0, 0}, testEthernet(layers.EthernetTypeIPv6))...),
+			expTunnels: []Tunnel{{Flow: udpFlow(6081), Type: TunnelGeneve, ID: 200}},
+		},
+		"GTP-U": {
+			// The message length covers the inner IPv4 and TCP headers and the Client Hello
+			layers: withHeaders(ipv4TCP(),
+				udpTunnel(2152, &layers.GTPv1U{Version: 1, ProtocolType: 1, MessageType: 0xff, MessageLength: uint16(40 + len(googleClientHello)), TEID: 0x1234})...),
+			expTunnels: []Tunnel{{Flow: udpFlow(2152), Type: TunnelGTPU, ID: 0x1234}},
+		},
+		"VXLAN in IP-in-IP": {
+			layers: withHeaders(ipv4TCP(),
+				testEthernet(layers.EthernetTypeIPv4),
+				outerIPv4(layers.IPProtocolIPv4),
+				nestedIP,
+				nestedUDP,
+				&layers.VXLAN{ValidIDFlag: true, VNI: 300},
+				testEthernet(layers.EthernetTypeIPv4)),
+			expTunnels: []Tunnel{
+				{Flow: greFlow, Type: TunnelIPinIP},
+				{Flow: Flow{SrcIP: testOuterDstIP, DstIP: testOuterSrcIP, SrcPort: 50000, DstPort: 4789}, Type: TunnelVXLAN, ID: 300},
+			},
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range tunnelTestSet {
+		for _, compat := range []bool{false, true} {
+			reader := testCapture(t, layers.LinkTypeEthernet, testPacket{test.layers})
+			records := collect(t, &Engine{Compat: compat}, reader)
+			if len(records) != 1 {
+				t.Errorf("%v, compat %v: Expected: %v records but got: %v\n", name, compat, 1, len(records))
+				continue
+			}
+
+			r := records[0]
+			if r.SrcPort != 34577 || r.DstPort != 443 || (!r.SrcIP.Equal(testSrcIP) && !r.SrcIP.Equal(testSrcIPv6)) {
+				t.Errorf("%v, compat %v: Expected inner tuple but got: %v:%v -> %v:%v\n", name, compat,
+					r.SrcIP, r.SrcPort, r.DstIP, r.DstPort)
+			}
+			if !reflect.DeepEqual(r.Tunnels, test.expTunnels) {
+				t.Errorf("%v, compat %v: Expected: %v but got: %v\n", name, compat, test.expTunnels, r.Tunnels)
+			}
+		}
+	}
+}
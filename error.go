@@ -6,7 +6,10 @@
 
 package ja3
 
-import "fmt"
+import (
+	"errors"
+	"fmt"
+)
 
 // Error types
 const (
@@ -17,10 +20,39 @@ const (
 	SNITypeErr       string = "SNI type not supported"
 )
 
+// Error kinds which every ParseError wraps, use errors.Is to check for them
+var (
+	// ErrNotHandshake is returned if the segment does not contain a TLS handshake record
+	ErrNotHandshake = errors.New("not a TLS handshake record")
+	// ErrNotClientHello is returned if the handshake message is not a Client Hello
+	ErrNotClientHello = errors.New("not a Client Hello")
+	// ErrNotServerHello is returned by ComputeJA3SFromSegment if the handshake message is not a Server Hello
+	ErrNotServerHello = errors.New("not a Server Hello")
+	// ErrUnsupportedVersion is returned if the record or Client Hello version is not in the range of SSL 3.0 to TLS 1.3
+	ErrUnsupportedVersion = errors.New("unsupported TLS version")
+	// ErrTruncated is returned if the segment ends before the record or the handshake of the Client or Server Hello is
+	// complete
+	ErrTruncated = errors.New("truncated handshake")
+	// ErrMalformed is returned if the length fields within the Client or Server Hello are inconsistent
+	ErrMalformed = errors.New("malformed handshake")
+	// ErrUnsupportedSNIType is returned if the server_name extension contains a name other than a DNS hostname
+	ErrUnsupportedSNIType = errors.New("unsupported SNI type")
+)
+
 // ParseError can be encountered while parsing a segment
 type ParseError struct {
 	errType string
 	check   int
+	// Offset is the byte offset in the segment of the field which could not be parsed
+	Offset int
+	// Field is the path of the field which could not be parsed, e.g. "client_hello.extensions[2].server_name"
+	Field string
+	kind  error
+}
+
+// newParseError returns a ParseError of the given kind for the field at offset in the segment
+func newParseError(kind error, errType string, check int, offset int, field string) *ParseError {
+	return &ParseError{errType: errType, check: check, Offset: offset, Field: field, kind: kind}
 }
 
 func (e *ParseError) Error() string {
@@ -29,3 +61,8 @@ func (e *ParseError) Error() string {
 	}
 	return fmt.Sprint(e.errType)
 }
+
+// Unwrap returns the kind of the error, which is one of the Err* variables of this package
+func (e *ParseError) Unwrap() error {
+	return e.kind
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"errors"
+	"testing"
+)
+
+type errorTestContainer struct {
+	testPayload []byte
+	expKind     error
+	expOffset   int
+	expField    string
+}
+
+func TestParseErrorKinds(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Check that the returned errors wrap the expected kind and point to the field which could not be parsed.
+	*/
+	var parseErrorTestSet = []errorTestContainer{
+		{
+			testPayload: []byte{42},
+			expKind:     ErrTruncated,
+			expOffset:   0,
+			expField:    "record",
+		},
+		{
+			testPayload: []byte{42, 42, 42, 42, 42},
+			expKind:     ErrNotHandshake,
+			expOffset:   0,
+			expField:    "record.content_type",
+		},
+		{
+			testPayload: []byte{22, 42, 42, 42, 42},
+			expKind:     ErrUnsupportedVersion,
+			expOffset:   1,
+			expField:    "record.version",
+		},
+		{
+			testPayload: []byte{22, 3, 0, 42, 42},
+			expKind:     ErrTruncated,
+			expOffset:   3,
+			expField:    "record.length",
+		},
+		{
+			testPayload: []byte{22, 3, 0, 0, 39, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42},
+			expKind:     ErrNotClientHello,
+			expOffset:   5,
+			expField:    "handshake.msg_type",
+		},
+		{
+			testPayload: []byte{22, 3, 0, 0, 39, 1, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42},
+			expKind:     ErrTruncated,
+			expOffset:   6,
+			expField:    "handshake.length",
+		},
+		{
+			testPayload: []byte{22, 3, 0, 0, 44, 1, 0, 0, 40, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 42},
+			expKind:     ErrMalformed,
+			expOffset:   48,
+			expField:    "client_hello.compression_methods",
+		},
+		{
+			testPayload: []byte{22, 3, 0, 0, 55, 1, 0, 0, 51, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 9, 0, 0, 0, 5, 42, 42, 42, 42, 42},
+			expKind:     ErrMalformed,
+			expOffset:   58,
+			expField:    "client_hello.extensions[0].server_name",
+		},
+		{
+			testPayload: []byte{22, 3, 0, 0, 55, 1, 0, 0, 51, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 9, 0, 0, 0, 5, 42, 42, 42, 0, 0},
+			expKind:     ErrUnsupportedSNIType,
+			expOffset:   57,
+			expField:    "client_hello.extensions[0].server_name",
+		},
+	}
+
+	// Run through all test cases
+	for _, test := range parseErrorTestSet {
+		_, err := ComputeJA3FromSegment(test.testPayload)
+		if !errors.Is(err, test.expKind) {
+			t.Errorf("Expected: %v but got: %v\n", test.expKind, err)
+		}
+		var parseErr *ParseError
+		if !errors.As(err, &parseErr) {
+			t.Errorf("Expected: *ParseError but got: %T\n", err)
+			continue
+		}
+		if parseErr.Offset != test.expOffset || parseErr.Field != test.expField {
+			t.Errorf("Expected: %v at %v but got: %v at %v\n", test.expField, test.expOffset, parseErr.Field, parseErr.Offset)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+// Fingerprint is an immutable and fully computed JA3 fingerprint. As opposed to the JA3 object, whose getters lazily
+// compute and cache their values, it is safe to share between goroutines. It is comparable, so it can be used as map
+// key.
+type Fingerprint struct {
+	ja3String string
+	ja3Hash   string
+	digest    Digest
+	sni       string
+}
+
+// ComputeFingerprintFromSegment parses the segment and returns the computed fingerprint or the encountered parsing
+// error.
+func ComputeFingerprintFromSegment(payload []byte) (Fingerprint, error) {
+	j, err := ComputeJA3FromSegment(payload)
+	if err != nil {
+		return Fingerprint{}, err
+	}
+	return j.Fingerprint(), nil
+}
+
+// Fingerprint computes all values of the JA3 object and returns them as immutable Fingerprint. The returned value does
+// not reference the parsed segment.
+func (j *JA3) Fingerprint() Fingerprint {
+	digest := j.GetJA3Digest()
+	return Fingerprint{
+		ja3String: j.GetJA3String(),
+		ja3Hash:   digest.String(),
+		digest:    digest,
+		sni:       j.GetSNI(),
+	}
+}
+
+// JA3String returns the JA3 string.
+func (f Fingerprint) JA3String() string {
+	return f.ja3String
+}
+
+// JA3Hash returns the MD5 digest of the JA3 string in hexadecimal representation.
+func (f Fingerprint) JA3Hash() string {
+	return f.ja3Hash
+}
+
+// Digest returns the MD5 digest of the JA3 string.
+func (f Fingerprint) Digest() Digest {
+	return f.digest
+}
+
+// SNI returns the SNI of the Client Hello or an empty string if no SNI extension was found.
+func (f Fingerprint) SNI() string {
+	return f.sni
+}
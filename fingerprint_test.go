@@ -0,0 +1,87 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestComputeFingerprintFromSegment(t *testing.T) {
+	/*
+		The fingerprint has to contain all values and must not reference the parsed segment.
+	*/
+	payload := append([]byte(nil), googleClientHello...)
+	f, err := ComputeFingerprintFromSegment(payload)
+	if err != nil {
+		t.Fatalf("Expected: %v but got: %v\n", nil, err)
+	}
+
+	// Overwrite the segment, e.g. like a zero copy packet source
+	for i := range payload {
+		payload[i] = 42
+	}
+
+	expJA3String := "771,49200-49196-49199-49195-49172-49162-49171-49161-159-158-57-51-157-156-53-47-10-255,0-11-10-35-13-5-15-13172,23-25-28-27-24-26-22-14-13-11-12-9-10,0-1-2"
+	expJA3Hash := "5e647d60a56d199388ae462b75b3cdad"
+	expSNI := "www.google.ch"
+	if f.JA3String() != expJA3String || f.JA3Hash() != expJA3Hash || f.Digest().String() != expJA3Hash || f.SNI() != expSNI {
+		t.Errorf("Expected: %v, %v, %v but got: %v, %v, %v\n",
+			expJA3String,
+			expJA3Hash,
+			expSNI,
+			f.JA3String(),
+			f.JA3Hash(),
+			f.SNI())
+	}
+
+	if _, err := ComputeFingerprintFromSegment(payload); err == nil {
+		t.Errorf("Expected: %v but got: %v\n", &ParseError{errType: ContentTypeErr}, err)
+	}
+}
+
+func TestFingerprintConcurrentUse(t *testing.T) {
+	/*
+		Share one fingerprint between goroutines, which read its values and use it as map key. Run with -race to detect
+		any data races.
+	*/
+	j, err := ComputeJA3FromSegment(googleClientHello)
+	if err != nil {
+		t.Fatalf("Expected: %v but got: %v\n", nil, err)
+	}
+	shared := j.Fingerprint()
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	counts := make(map[Fingerprint]int)
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			local := make(map[Fingerprint]int)
+			for n := 0; n < 100; n++ {
+				f, err := ComputeFingerprintFromSegment(googleClientHello)
+				if err != nil || f != shared || f.JA3Hash() != shared.JA3Hash() || f.SNI() != shared.SNI() {
+					t.Errorf("Expected: %v but got: %v, %v\n", shared, f, err)
+					return
+				}
+				local[shared]++
+				local[f]++
+			}
+			mu.Lock()
+			for f, c := range local {
+				counts[f] += c
+			}
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if len(counts) != 1 || counts[shared] != 8*200 {
+		t.Errorf("Expected: %v but got: %v\n", map[Fingerprint]int{shared: 8 * 200}, counts)
+	}
+}
@@ -9,18 +9,40 @@ package ja3
 import (
 	"crypto/md5"
 	"encoding/hex"
+	"hash"
 )
 
-// JA3 stores the parsed fields from the Client Hello. To access the values use the respective getter methods.
+// Digest is the MD5 digest of a JA3 string. As opposed to the hexadecimal representation it can be computed without
+// allocations and is comparable, so it can be used as map key.
+type Digest [16]byte
+
+// String returns the digest in hexadecimal representation.
+func (d Digest) String() string {
+	return hex.EncodeToString(d[:])
+}
+
+// JA3 stores the parsed fields from the Client Hello. To access the values use the respective getter methods. As the
+// getters cache their results, a JA3 object must not be shared between goroutines, use its Fingerprint instead.
 type JA3 struct {
-	version         uint16
-	cipherSuites    []uint16
-	extensions      []uint16
-	ellipticCurves  []uint16
-	ellipticCurvePF []uint8
-	sni             []byte
-	ja3ByteString   []byte
-	ja3Hash         string
+	version            uint16
+	cipherSuites       []uint16
+	extensions         []uint16
+	ellipticCurves     []uint16
+	ellipticCurvePF    []uint8
+	sni                []byte
+	sessionIDLen       uint8
+	compressionMethods []uint8
+	rawExtensions      []byte
+	ja3ByteString      []byte
+	ja3Hash            string
+	ja4                string
+	lenient            bool
+	anomalies          []*ParseError
+	// ja3MD5 is the MD5 state a Parser feeds with the JA3 string while it is built, hashed the length of the JA3
+	// string fed to it so far and digest the buffer its sum is written to
+	ja3MD5 hash.Hash
+	hashed int
+	digest Digest
 }
 
 // ComputeJA3FromSegment parses the segment and returns the populated JA3 object or the encountered parsing error.
@@ -30,10 +52,69 @@ func ComputeJA3FromSegment(payload []byte) (*JA3, error) {
 	return &ja3, err
 }
 
+// ComputeJA3FromSegmentLenient parses the segment like ComputeJA3FromSegment, but tolerates malformed Client Hellos
+// (e.g. bad extension lengths, trailing data or a truncated extension block) as far as possible. It returns the
+// populated JA3 object with a best-effort fingerprint together with the list of anomalies encountered while parsing.
+// An error is only returned if the segment could not be recognized as Client Hello at all.
+func ComputeJA3FromSegmentLenient(payload []byte) (*JA3, []*ParseError, error) {
+	ja3 := JA3{lenient: true}
+	err := ja3.parseSegment(payload)
+	return &ja3, ja3.anomalies, err
+}
+
+// Parser parses segments into caller owned JA3 objects. Once the buffers of a JA3 object have grown to the size of the
+// parsed Client Hellos, successfully parsing a segment into it does not allocate any memory, which keeps the GC
+// pressure low when processing high packet rates. A Parser can be shared, but a JA3 object must not be used by
+// multiple goroutines while parsing into it.
+type Parser struct {
+	// Lenient enables the lenient parsing mode as described for ComputeJA3FromSegmentLenient. The encountered
+	// anomalies are available through the GetAnomalies method of the JA3 object.
+	Lenient bool
+}
+
+// ParseInto resets the JA3 object and parses the segment into it. The JA3 string is built in the reused buffer of the
+// JA3 object while parsing and every completed field of it is fed to the MD5 state of the JA3 object right away, so the
+// parsed fields are not walked a second time. It returns the MD5 digest of the JA3 string or the encountered parsing
+// error. The JA3 object keeps references to the segment, so the segment must not be modified as long as the JA3
+// object is in use.
+func (p *Parser) ParseInto(j *JA3, segment []byte) (Digest, error) {
+	j.Reset()
+	j.lenient = p.Lenient
+	if j.ja3MD5 == nil {
+		j.ja3MD5 = md5.New()
+	}
+	if err := j.parseSegment(segment); err != nil {
+		return Digest{}, err
+	}
+	j.ja3MD5.Sum(j.digest[:0])
+	return j.digest, nil
+}
+
+// Reset clears all fields of the JA3 object but keeps the allocated buffers, so it can be reused with a Parser.
+func (j *JA3) Reset() {
+	*j = JA3{
+		cipherSuites:    j.cipherSuites[:0],
+		extensions:      j.extensions[:0],
+		ellipticCurves:  j.ellipticCurves[:0],
+		ellipticCurvePF: j.ellipticCurvePF[:0],
+		ja3ByteString:   j.ja3ByteString[:0],
+		anomalies:       j.anomalies[:0],
+		ja3MD5:          j.ja3MD5,
+	}
+	if j.ja3MD5 != nil {
+		j.ja3MD5.Reset()
+	}
+}
+
+// GetAnomalies returns the anomalies encountered while parsing in lenient mode.
+func (j *JA3) GetAnomalies() []*ParseError {
+	return j.anomalies
+}
+
 // GetJA3ByteString returns the JA3 string as a byte slice for more efficient handling. This function uses caching, so
 // repeated calls to this function on the same JA3 object will not trigger any new calculations.
 func (j *JA3) GetJA3ByteString() []byte {
-	if j.ja3ByteString == nil {
+	if len(j.ja3ByteString) == 0 {
 		j.marshalJA3()
 	}
 	return j.ja3ByteString
@@ -55,6 +136,11 @@ func (j *JA3) GetJA3Hash() string {
 	return j.ja3Hash
 }
 
+// GetJA3Digest returns the MD5 digest of the JA3 string. This function does not allocate any memory.
+func (j *JA3) GetJA3Digest() Digest {
+	return md5.Sum(j.GetJA3ByteString())
+}
+
 // GetSNI returns the set SNI in the Client Hello or an empty string if no SNI extension is found. This function uses
 // caching, so repeated calls to this function on the same JA3 object will not trigger any new calculations.
 func (j *JA3) GetSNI() string {
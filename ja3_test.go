@@ -17,8 +17,12 @@ type testContainer struct {
 	expJA3Hash   string
 	expSNI       string
 	expErr       error
+	expAnomalies []*ParseError
 }
 
+// googleClientHello is a real Client Hello segment shared by multiple tests
+var googleClientHello = []byte{22, 3, 1, 0, 201, 1, 0, 0, 197, 3, 3, 82, 50, 235, 232, 231, 181, 243, 122, 13, 113, 213, 238, 184, 242, 230, 164, 189, 148, 5, 55, 17, 170, 189, 193, 212, 189, 211, 11, 239, 192, 39, 240, 0, 0, 36, 192, 48, 192, 44, 192, 47, 192, 43, 192, 20, 192, 10, 192, 19, 192, 9, 0, 159, 0, 158, 0, 57, 0, 51, 0, 157, 0, 156, 0, 53, 0, 47, 0, 10, 0, 255, 1, 0, 0, 120, 0, 0, 0, 18, 0, 16, 0, 0, 13, 119, 119, 119, 46, 103, 111, 111, 103, 108, 101, 46, 99, 104, 0, 11, 0, 4, 3, 0, 1, 2, 0, 10, 0, 28, 0, 26, 0, 23, 0, 25, 0, 28, 0, 27, 0, 24, 0, 26, 0, 22, 0, 14, 0, 13, 0, 11, 0, 12, 0, 9, 0, 10, 0, 35, 0, 0, 0, 13, 0, 32, 0, 30, 6, 1, 6, 2, 6, 3, 5, 1, 5, 2, 5, 3, 4, 1, 4, 2, 4, 3, 3, 1, 3, 2, 3, 3, 2, 1, 2, 2, 2, 3, 0, 5, 0, 5, 1, 0, 0, 0, 0, 0, 15, 0, 1, 1, 51, 116, 0, 0}
+
 func TestComputeJA3FromSegment(t *testing.T) {
 	/*
 		Build container with testing data
@@ -70,6 +74,189 @@ func TestComputeJA3FromSegment(t *testing.T) {
 	}
 }
 
+func TestComputeJA3FromSegmentLenient(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Check that malformed segments, which are rejected by ComputeJA3FromSegment, still result in a best-effort
+		fingerprint and that the encountered anomalies are reported.
+	*/
+	var computeJA3FromSegmentLenientTestSet = []testContainer{
+		{ // Sanity check (no anomalies)
+			testPayload:  []byte{22, 3, 0, 0, 57, 1, 0, 0, 53, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0, 0, 11, 0, 0, 0, 7, 42, 42, 0, 0, 2, 52, 50},
+			expJA3String: "768,5397,0,,",
+			expJA3Hash:   "7b871a8d50bdac2c9186af16af86a0f4",
+			expSNI:       "42",
+		},
+		{ // Truncated extension block
+			testPayload:  []byte{22, 3, 0, 0, 57, 1, 0, 0, 53, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0, 0, 20, 0, 0, 0, 7, 42, 42, 0, 0, 2, 52, 50},
+			expJA3String: "768,5397,0,,",
+			expJA3Hash:   "7b871a8d50bdac2c9186af16af86a0f4",
+			expSNI:       "42",
+			expAnomalies: []*ParseError{{errType: LengthErr, check: 10}},
+		},
+		{ // Trailing data after the extensions
+			testPayload:  []byte{22, 3, 0, 0, 59, 1, 0, 0, 55, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0, 0, 11, 0, 0, 0, 7, 42, 42, 0, 0, 2, 52, 50, 42, 42},
+			expJA3String: "768,5397,0,,",
+			expJA3Hash:   "7b871a8d50bdac2c9186af16af86a0f4",
+			expSNI:       "42",
+			expAnomalies: []*ParseError{{errType: LengthErr, check: 11}},
+		},
+		{ // Bad extension length
+			testPayload:  []byte{22, 3, 0, 0, 57, 1, 0, 0, 53, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0, 0, 11, 0, 0, 0, 9, 42, 42, 0, 0, 2, 52, 50},
+			expJA3String: "768,5397,0,,",
+			expJA3Hash:   "7b871a8d50bdac2c9186af16af86a0f4",
+			expSNI:       "",
+			expAnomalies: []*ParseError{{errType: LengthErr, check: 12}},
+		},
+		{ // Trailing data after the handshake
+			testPayload:  []byte{22, 3, 0, 0, 59, 1, 0, 0, 53, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0, 0, 11, 0, 0, 0, 7, 42, 42, 0, 0, 2, 52, 50, 42, 42},
+			expJA3String: "768,5397,0,,",
+			expJA3Hash:   "7b871a8d50bdac2c9186af16af86a0f4",
+			expSNI:       "42",
+			expAnomalies: []*ParseError{{errType: LengthErr, check: 4}},
+		},
+		{ // Unsupported SNI type
+			testPayload:  []byte{22, 3, 0, 0, 55, 1, 0, 0, 51, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 9, 0, 0, 0, 5, 42, 42, 42, 0, 0},
+			expJA3String: "768,,0,,",
+			expJA3Hash:   "633682cdbaaa3594417b8a6514f56ac7",
+			expSNI:       "",
+			expAnomalies: []*ParseError{{errType: SNITypeErr}},
+		},
+		{ // No Client Hello at all
+			testPayload: []byte{42, 42, 42, 42, 42},
+			expErr:      &ParseError{errType: ContentTypeErr},
+		},
+	}
+
+	// Run through all test cases
+	for _, test := range computeJA3FromSegmentLenientTestSet {
+		ja3, anomalies, err := ComputeJA3FromSegmentLenient(test.testPayload)
+		if test.expErr != nil {
+			if err == nil || err.Error() != test.expErr.Error() {
+				t.Errorf("Expected: %v but got: %v\n", test.expErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected: %v but got: %v\n", test.expErr, err)
+			continue
+		}
+		if ja3.GetJA3String() != test.expJA3String || ja3.GetJA3Hash() != test.expJA3Hash || ja3.GetSNI() != test.expSNI {
+			t.Errorf("Expected: %v, %v, %v but got: %v, %v, %v\n",
+				test.expJA3String,
+				test.expJA3Hash,
+				test.expSNI,
+				ja3.GetJA3String(),
+				ja3.GetJA3Hash(),
+				ja3.GetSNI())
+		}
+		if len(anomalies) != len(test.expAnomalies) {
+			t.Errorf("Expected anomalies: %v but got: %v\n", test.expAnomalies, anomalies)
+			continue
+		}
+		for i := range anomalies {
+			if anomalies[i].Error() != test.expAnomalies[i].Error() {
+				t.Errorf("Expected anomaly: %v but got: %v\n", test.expAnomalies[i], anomalies[i])
+			}
+		}
+
+		// The same segments have to be rejected by the strict parser
+		if len(test.expAnomalies) != 0 {
+			if _, err := ComputeJA3FromSegment(test.testPayload); err == nil || err.Error() != test.expAnomalies[0].Error() {
+				t.Errorf("Expected: %v but got: %v\n", test.expAnomalies[0], err)
+			}
+		}
+
+		// The JA3 string and digest built while parsing by a lenient Parser have to match as well
+		p := Parser{Lenient: true}
+		var parsed JA3
+		if digest, err := p.ParseInto(&parsed, test.testPayload); err != nil || string(parsed.ja3ByteString) != test.expJA3String || digest.String() != test.expJA3Hash {
+			t.Errorf("Expected: %v, %v but got: %v, %v (%v)\n", test.expJA3String, test.expJA3Hash,
+				string(parsed.ja3ByteString), digest, err)
+		}
+	}
+}
+
+func TestParseInto(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Parse all segments into the same JA3 object, so that the reused buffers shrink and grow between the segments.
+	*/
+	var parseIntoTestSet = []testContainer{
+		{ // Sanity check
+			testPayload:  googleClientHello,
+			expJA3String: "771,49200-49196-49199-49195-49172-49162-49171-49161-159-158-57-51-157-156-53-47-10-255,0-11-10-35-13-5-15-13172,23-25-28-27-24-26-22-14-13-11-12-9-10,0-1-2",
+			expJA3Hash:   "5e647d60a56d199388ae462b75b3cdad",
+			expSNI:       "www.google.ch",
+		},
+		{ // Dummy segment (no extensions)
+			testPayload:  []byte{22, 3, 0, 0, 44, 1, 0, 0, 40, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0},
+			expJA3String: "768,5397,,,",
+			expJA3Hash:   "185477c6143146afd64ba7bc72210566",
+			expSNI:       "",
+		},
+		{ // No Client Hello
+			testPayload: []byte{42, 42, 42, 42, 42},
+			expErr:      &ParseError{errType: ContentTypeErr},
+		},
+		{ // Sanity check again
+			testPayload:  googleClientHello,
+			expJA3String: "771,49200-49196-49199-49195-49172-49162-49171-49161-159-158-57-51-157-156-53-47-10-255,0-11-10-35-13-5-15-13172,23-25-28-27-24-26-22-14-13-11-12-9-10,0-1-2",
+			expJA3Hash:   "5e647d60a56d199388ae462b75b3cdad",
+			expSNI:       "www.google.ch",
+		},
+	}
+
+	// Run through all test cases
+	var p Parser
+	var ja3 JA3
+	for _, test := range parseIntoTestSet {
+		digest, err := p.ParseInto(&ja3, test.testPayload)
+		if test.expErr != nil {
+			if err == nil || err.Error() != test.expErr.Error() {
+				t.Errorf("Expected: %v but got: %v\n", test.expErr, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected: %v but got: %v\n", test.expErr, err)
+			continue
+		}
+		if ja3.GetJA3String() != test.expJA3String || digest.String() != test.expJA3Hash || ja3.GetJA3Hash() != test.expJA3Hash || ja3.GetSNI() != test.expSNI {
+			t.Errorf("Expected: %v, %v, %v but got: %v, %v, %v\n",
+				test.expJA3String,
+				test.expJA3Hash,
+				test.expSNI,
+				ja3.GetJA3String(),
+				digest,
+				ja3.GetSNI())
+		}
+		if ja3.GetJA3Digest() != digest {
+			t.Errorf("Expected: %v but got: %v\n", digest, ja3.GetJA3Digest())
+		}
+	}
+}
+
+func TestParseIntoAllocs(t *testing.T) {
+	/*
+		Once the buffers of the JA3 object have grown, parsing a Client Hello must not allocate.
+	*/
+	var p Parser
+	var ja3 JA3
+	if _, err := p.ParseInto(&ja3, googleClientHello); err != nil {
+		t.Fatalf("Expected: %v but got: %v\n", nil, err)
+	}
+
+	allocs := testing.AllocsPerRun(100, func() {
+		p.ParseInto(&ja3, googleClientHello)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected: %v allocations but got: %v\n", 0, allocs)
+	}
+}
+
 func TestGetJA3ByteString(t *testing.T) {
 	/*
 		Build container with testing data
@@ -176,7 +363,7 @@ func TestParseSegment(t *testing.T) {
 	var parseSegmentTestSet = []testContainer{
 		{ //					CT
 			testPayload: []byte{42},
-			expErr:      &ParseError{LengthErr, 1},
+			expErr:      &ParseError{errType: LengthErr, check: 1},
 		},
 		{ //					CT  Ver---  Len---
 			testPayload: []byte{42, 42, 42, 42, 42},
@@ -184,7 +371,7 @@ func TestParseSegment(t *testing.T) {
 		},
 		{ //					CT  Ver---  Len---
 			testPayload: []byte{22, 42, 42, 42, 42},
-			expErr:      &ParseError{VersionErr, 1},
+			expErr:      &ParseError{errType: VersionErr, check: 1},
 		},
 	}
 
@@ -218,11 +405,11 @@ func TestParseTLSHandshake(t *testing.T) {
 	var parseTLSHandshakeTestSet = []testContainer{
 		{ //					CT  Ver-  Len---
 			testPayload: []byte{22, 3, 0, 42, 42},
-			expErr:      &ParseError{LengthErr, 2},
+			expErr:      &ParseError{errType: LengthErr, check: 2},
 		},
 		{ //					CT  Ver-  Len-
 			testPayload: []byte{22, 3, 0, 0, 0},
-			expErr:      &ParseError{LengthErr, 3},
+			expErr:      &ParseError{errType: LengthErr, check: 3},
 		},
 		{ //					CT  Ver-  Len--  HT  Len-------  Ver---  Ran---------------------------------------------------------------------------------------------------------------------------  SI
 			testPayload: []byte{22, 3, 0, 0, 39, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42},
@@ -230,27 +417,27 @@ func TestParseTLSHandshake(t *testing.T) {
 		},
 		{ //					CT  Ver-  Len--  HT Len-------  Ver---  Ran---------------------------------------------------------------------------------------------------------------------------  SI
 			testPayload: []byte{22, 3, 0, 0, 39, 1, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42},
-			expErr:      &ParseError{LengthErr, 4},
+			expErr:      &ParseError{errType: LengthErr, check: 4},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver---  Ran---------------------------------------------------------------------------------------------------------------------------  SI
 			testPayload: []byte{22, 3, 0, 0, 39, 1, 0, 0, 35, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42},
-			expErr:      &ParseError{VersionErr, 2},
+			expErr:      &ParseError{errType: VersionErr, check: 2},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI
 			testPayload: []byte{22, 3, 0, 0, 39, 1, 0, 0, 35, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42},
-			expErr:      &ParseError{LengthErr, 5},
+			expErr:      &ParseError{errType: LengthErr, check: 5},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI
 			testPayload: []byte{22, 3, 0, 0, 39, 1, 0, 0, 35, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0},
-			expErr:      &ParseError{LengthErr, 6},
+			expErr:      &ParseError{errType: LengthErr, check: 6},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL----
 			testPayload: []byte{22, 3, 0, 0, 41, 1, 0, 0, 37, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 42, 42},
-			expErr:      &ParseError{LengthErr, 7},
+			expErr:      &ParseError{errType: LengthErr, check: 7},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML
 			testPayload: []byte{22, 3, 0, 0, 44, 1, 0, 0, 40, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 42},
-			expErr:      &ParseError{LengthErr, 8},
+			expErr:      &ParseError{errType: LengthErr, check: 8},
 		},
 	}
 
@@ -292,27 +479,27 @@ func TestParseExtensions(t *testing.T) {
 	var parseTLSHandshakeTestSet = []testContainer{
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL
 			testPayload: []byte{22, 3, 0, 0, 45, 1, 0, 0, 41, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 42},
-			expErr:      &ParseError{LengthErr, 9},
+			expErr:      &ParseError{errType: LengthErr, check: 9},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL----
 			testPayload: []byte{22, 3, 0, 0, 46, 1, 0, 0, 42, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 42, 42},
-			expErr:      &ParseError{LengthErr, 10},
+			expErr:      &ParseError{errType: LengthErr, check: 10},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET
 			testPayload: []byte{22, 3, 0, 0, 47, 1, 0, 0, 43, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 1, 42},
-			expErr:      &ParseError{LengthErr, 11},
+			expErr:      &ParseError{errType: LengthErr, check: 11},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET----  ExL---
 			testPayload: []byte{22, 3, 0, 0, 50, 1, 0, 0, 46, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 4, 42, 42, 42, 42},
-			expErr:      &ParseError{LengthErr, 12},
+			expErr:      &ParseError{errType: LengthErr, check: 12},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET--  ExL-
 			testPayload: []byte{22, 3, 0, 0, 50, 1, 0, 0, 46, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 4, 0, 0, 0, 0},
-			expErr:      &ParseError{LengthErr, 13},
+			expErr:      &ParseError{errType: LengthErr, check: 13},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET--  ExL-  SN----  ST  SL----
 			testPayload: []byte{22, 3, 0, 0, 55, 1, 0, 0, 51, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 9, 0, 0, 0, 5, 42, 42, 42, 42, 42},
-			expErr:      &ParseError{LengthErr, 14},
+			expErr:      &ParseError{errType: LengthErr, check: 14},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET--  ExL-  SN----  ST  SL--
 			testPayload: []byte{22, 3, 0, 0, 55, 1, 0, 0, 51, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 9, 0, 0, 0, 5, 42, 42, 42, 0, 0},
@@ -320,19 +507,19 @@ func TestParseExtensions(t *testing.T) {
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET---  ExL-
 			testPayload: []byte{22, 3, 0, 0, 50, 1, 0, 0, 46, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 4, 0, 10, 0, 0},
-			expErr:      &ParseError{LengthErr, 15},
+			expErr:      &ParseError{errType: LengthErr, check: 15},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET---  ExL-  EC----
 			testPayload: []byte{22, 3, 0, 0, 52, 1, 0, 0, 48, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 6, 0, 10, 0, 2, 42, 42},
-			expErr:      &ParseError{LengthErr, 16},
+			expErr:      &ParseError{errType: LengthErr, check: 16},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET---  ExL-
 			testPayload: []byte{22, 3, 0, 0, 50, 1, 0, 0, 46, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 4, 0, 11, 0, 0},
-			expErr:      &ParseError{LengthErr, 17},
+			expErr:      &ParseError{errType: LengthErr, check: 17},
 		},
 		{ //					CT  Ver-  Len--  HT Len-----  Ver-  Ran---------------------------------------------------------------------------------------------------------------------------  SI CL--  CS----  ML EL--  ET---  ExL-  FL
 			testPayload: []byte{22, 3, 0, 0, 51, 1, 0, 0, 47, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 42, 42, 0, 0, 5, 0, 11, 0, 1, 42},
-			expErr:      &ParseError{LengthErr, 18},
+			expErr:      &ParseError{errType: LengthErr, check: 18},
 		},
 	}
 
@@ -399,6 +586,17 @@ func BenchmarkComputeJA3FromSegment(b *testing.B) {
 	}
 }
 
+func BenchmarkParseInto(b *testing.B) {
+	var p Parser
+	var ja3 JA3
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for n := 0; n < b.N; n++ {
+		p.ParseInto(&ja3, googleClientHello)
+	}
+}
+
 func BenchmarkGetJA3ByteString(b *testing.B) {
 	/*
 		Build container with benchmarking data
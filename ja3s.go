@@ -0,0 +1,228 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+)
+
+const (
+	// Constants used for parsing Server Hellos
+	serverHelloType                uint8  = 2
+	cipherSuiteLen                 int    = 2
+	compressMethodLen              int    = 1
+	alpnExtensionType              uint16 = 16
+	serverVersionsExtensionType    uint16 = 43
+	serverVersionsExtensionDataLen int    = 2
+)
+
+// helloRetryRequestRandom is the random of a Server Hello which is a HelloRetryRequest (RFC 8446, section 4.1.3)
+var helloRetryRequestRandom = []byte{0xcf, 0x21, 0xad, 0x74, 0xe5, 0x9a, 0x61, 0x11, 0xbe, 0x1d, 0x8c, 0x02, 0x1e,
+	0x65, 0xb8, 0x91, 0xc2, 0xa2, 0x11, 0x16, 0x7a, 0xbb, 0x8c, 0x5e, 0x07, 0x9e, 0x09, 0xe2, 0xc8, 0xa8, 0x33, 0x9c}
+
+// JA3S stores the parsed fields of a Server Hello and its JA3S fingerprint, which is computed from the version, the
+// selected cipher suite and the extensions of the Server Hello. As opposed to the JA3 object, it is fully computed
+// when it is returned, so it is safe to share between goroutines.
+type JA3S struct {
+	version           uint16
+	negotiatedVersion uint16
+	cipherSuite       uint16
+	extensions        []uint16
+	alpn              string
+	helloRetryRequest bool
+	ja3sString        string
+	ja3sHash          string
+}
+
+// ComputeJA3SFromSegment parses the Server Hello at the start of the segment and returns the populated JA3S object or
+// the encountered parsing error. The segment may contain further handshake messages after the Server Hello.
+func ComputeJA3SFromSegment(payload []byte) (*JA3S, error) {
+	j := &JA3S{}
+	if err := j.parseSegment(payload); err != nil {
+		return nil, err
+	}
+	j.marshalJA3S()
+	return j, nil
+}
+
+// GetJA3SString returns the JA3S string.
+func (j *JA3S) GetJA3SString() string {
+	return j.ja3sString
+}
+
+// GetJA3SHash returns the MD5 digest of the JA3S string in hexadecimal representation.
+func (j *JA3S) GetJA3SHash() string {
+	return j.ja3sHash
+}
+
+// GetVersion returns the negotiated TLS version, which is taken from the supported_versions extension for TLS 1.3.
+func (j *JA3S) GetVersion() uint16 {
+	return j.negotiatedVersion
+}
+
+// GetCipherSuite returns the cipher suite selected by the server.
+func (j *JA3S) GetCipherSuite() uint16 {
+	return j.cipherSuite
+}
+
+// GetALPN returns the application protocol selected by the server or an empty string if ALPN was not negotiated.
+func (j *JA3S) GetALPN() string {
+	return j.alpn
+}
+
+// IsHelloRetryRequest reports whether the Server Hello is a TLS 1.3 HelloRetryRequest, which asks the client for a
+// second Client Hello instead of completing the handshake.
+func (j *JA3S) IsHelloRetryRequest() bool {
+	return j.helloRetryRequest
+}
+
+// parseSegment to populate the JA3S object or return an error
+func (j *JA3S) parseSegment(segment []byte) error {
+
+	// Check if we can decode the next fields
+	if len(segment) < recordLayerHeaderLen {
+		return newParseError(ErrTruncated, LengthErr, 1, 0, "record")
+	}
+
+	// Check if we have "Content Type: Handshake (22)"
+	if uint8(segment[0]) != contentType {
+		return newParseError(ErrNotHandshake, ContentTypeErr, 0, 0, "record.content_type")
+	}
+
+	// Check if TLS record layer version is supported
+	tlsRecordVersion := uint16(segment[1])<<8 | uint16(segment[2])
+	if tlsRecordVersion&tlsVersionBitmask != 0x0300 && tlsRecordVersion != tls13 {
+		return newParseError(ErrUnsupportedVersion, VersionErr, 1, 1, "record.version")
+	}
+
+	// The record may continue in the next segments, e.g. with the certificate of the server
+	hs := segment[recordLayerHeaderLen:]
+	if recordLen := int(uint16(segment[3])<<8 | uint16(segment[4])); len(hs) > recordLen {
+		hs = hs[:recordLen]
+	}
+
+	// Check if we can decode the next fields
+	if len(hs) < handshakeHeaderLen-2 {
+		return newParseError(ErrTruncated, LengthErr, 3, recordLayerHeaderLen, "handshake")
+	}
+
+	// Check if we have "Handshake Type: Server Hello (2)"
+	if uint8(hs[0]) != serverHelloType {
+		return newParseError(ErrNotServerHello, HandshakeTypeErr, 0, recordLayerHeaderLen, "handshake.msg_type")
+	}
+
+	// Further handshake messages may follow the Server Hello in the same record
+	handshakeLen := int(uint32(hs[1])<<16 | uint32(hs[2])<<8 | uint32(hs[3]))
+	if len(hs[4:]) < handshakeLen {
+		return newParseError(ErrTruncated, LengthErr, 4, recordLayerHeaderLen+1, "handshake.length")
+	}
+	hs = hs[:4+handshakeLen]
+
+	// Check if we can decode the next fields
+	if len(hs) < handshakeHeaderLen+randomDataLen+sessionIDHeaderLen {
+		return newParseError(ErrMalformed, LengthErr, 21, recordLayerHeaderLen+4, "server_hello")
+	}
+
+	// Check if Server Hello version is supported, TLS 1.3 is negotiated with the supported_versions extension
+	tlsVersion := uint16(hs[4])<<8 | uint16(hs[5])
+	if tlsVersion&tlsVersionBitmask != 0x0300 {
+		return newParseError(ErrUnsupportedVersion, VersionErr, 2, recordLayerHeaderLen+4, "server_hello.version")
+	}
+	j.version = tlsVersion
+	j.negotiatedVersion = tlsVersion
+	j.helloRetryRequest = bytes.Equal(hs[handshakeHeaderLen:handshakeHeaderLen+randomDataLen], helloRetryRequestRandom)
+
+	// Check if we can decode the next fields
+	sessionIDLen := int(hs[handshakeHeaderLen+randomDataLen])
+	csOffset := handshakeHeaderLen + randomDataLen + sessionIDHeaderLen + sessionIDLen
+	if len(hs) < csOffset+cipherSuiteLen+compressMethodLen {
+		return newParseError(ErrMalformed, LengthErr, 22, recordLayerHeaderLen+handshakeHeaderLen+randomDataLen, "server_hello.session_id")
+	}
+
+	j.cipherSuite = uint16(hs[csOffset])<<8 | uint16(hs[csOffset+1])
+
+	// Extensions
+	exsOffset := csOffset + cipherSuiteLen + compressMethodLen
+	return j.parseExtensions(hs[exsOffset:], recordLayerHeaderLen+exsOffset)
+}
+
+// parseExtensions of the Server Hello, which start at offset in the segment
+func (j *JA3S) parseExtensions(exs []byte, offset int) error {
+
+	// Check for no extensions, this fields header is nonexistent if no body is used
+	if len(exs) == 0 {
+		return nil
+	}
+
+	// Check if we can decode the next fields
+	if len(exs) < extensionsHeaderLen {
+		return newParseError(ErrMalformed, LengthErr, 23, offset, "server_hello.extensions")
+	}
+	exsLen := int(uint16(exs[0])<<8 | uint16(exs[1]))
+	exs = exs[extensionsHeaderLen:]
+	if len(exs) != exsLen {
+		return newParseError(ErrMalformed, LengthErr, 24, offset, "server_hello.extensions")
+	}
+	offset += extensionsHeaderLen
+
+	for i := 0; len(exs) > 0; i++ {
+		field := "server_hello.extensions[" + strconv.Itoa(i) + "]"
+
+		// Check if we can decode the next fields
+		if len(exs) < extensionHeaderLen {
+			return newParseError(ErrMalformed, LengthErr, 25, offset, field)
+		}
+		exType := uint16(exs[0])<<8 | uint16(exs[1])
+		exLen := int(uint16(exs[2])<<8 | uint16(exs[3]))
+		if len(exs) < extensionHeaderLen+exLen {
+			return newParseError(ErrMalformed, LengthErr, 26, offset+2, field)
+		}
+		sex := exs[extensionHeaderLen : extensionHeaderLen+exLen]
+
+		// Ignore any GREASE extensions
+		if exType&greaseBitmask != 0x0A0A {
+			j.extensions = append(j.extensions, exType)
+		}
+
+		switch exType {
+		case serverVersionsExtensionType: // Extensions: supported_versions
+			if len(sex) != serverVersionsExtensionDataLen {
+				return newParseError(ErrMalformed, LengthErr, 27, offset+extensionHeaderLen, field+".supported_versions")
+			}
+			j.negotiatedVersion = uint16(sex[0])<<8 | uint16(sex[1])
+		case alpnExtensionType: // Extensions: application_layer_protocol_negotiation
+			// The server selects exactly one protocol
+			if len(sex) < 3 || int(uint16(sex[0])<<8|uint16(sex[1])) != len(sex)-2 || int(sex[2]) != len(sex)-3 {
+				return newParseError(ErrMalformed, LengthErr, 28, offset+extensionHeaderLen, field+".application_layer_protocol_negotiation")
+			}
+			j.alpn = string(sex[3:])
+		}
+		exs = exs[extensionHeaderLen+exLen:]
+		offset += extensionHeaderLen + exLen
+	}
+	return nil
+}
+
+// marshalJA3S computes the JA3S string and its hash
+func (j *JA3S) marshalJA3S() {
+	byteString := strconv.AppendUint(nil, uint64(j.version), 10)
+	byteString = append(byteString, commaByte)
+	byteString = strconv.AppendUint(byteString, uint64(j.cipherSuite), 10)
+	byteString = append(byteString, commaByte)
+	for i, val := range j.extensions {
+		if i > 0 {
+			byteString = append(byteString, dashByte)
+		}
+		byteString = strconv.AppendUint(byteString, uint64(val), 10)
+	}
+	h := md5.Sum(byteString)
+	j.ja3sString = string(byteString)
+	j.ja3sHash = hex.EncodeToString(h[:])
+}
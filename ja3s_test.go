@@ -0,0 +1,141 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"errors"
+	"testing"
+)
+
+// buildServerHello assembles a TLS record containing a Server Hello followed by the trailing bytes
+func buildServerHello(random []byte, cipherSuite uint16, extensions []testExtension, trailing []byte) []byte {
+	var exs []byte
+	for _, ex := range extensions {
+		exs = append(exs, byte(ex.exType>>8), byte(ex.exType), byte(len(ex.data)>>8), byte(len(ex.data)))
+		exs = append(exs, ex.data...)
+	}
+
+	body := []byte{3, 3}
+	body = append(body, random...)
+	body = append(body, 32)
+	body = append(body, make([]byte, 32)...)
+	body = append(body, byte(cipherSuite>>8), byte(cipherSuite), 0)
+	if extensions != nil {
+		body = append(body, byte(len(exs)>>8), byte(len(exs)))
+		body = append(body, exs...)
+	}
+
+	hs := append([]byte{serverHelloType, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	hs = append(hs, trailing...)
+	return append([]byte{contentType, 3, 3, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+type ja3sTestContainer struct {
+	segment    []byte
+	expString  string
+	expHash    string
+	expVersion uint16
+	expCipher  uint16
+	expALPN    string
+	expHRR     bool
+	expErr     error
+}
+
+func TestComputeJA3SFromSegment(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Check the JA3S fingerprints and negotiated parameters of TLS 1.2 and TLS 1.3 Server Hellos, of a
+		HelloRetryRequest and of segments which do not contain a Server Hello.
+	*/
+	random := make([]byte, randomDataLen)
+	renegotiationInfo := testExtension{0xff01, []byte{0}}
+	alpn := testExtension{alpnExtensionType, []byte{0, 3, 2, 'h', '2'}}
+	ecPointFormats := testExtension{0x000b, []byte{1, 0}}
+	supportedVersions := testExtension{serverVersionsExtensionType, []byte{3, 4}}
+	keyShare := testExtension{keyShareExtensionType, []byte{0, 29, 0, 2, 42, 42}}
+	certificate := []byte{11, 0, 0, 0}
+
+	var ja3sTestSet = []ja3sTestContainer{
+		{ // TLS 1.2 Server Hello with ALPN followed by a Certificate
+			segment:    buildServerHello(random, 0xc02f, []testExtension{renegotiationInfo, alpn, ecPointFormats}, certificate),
+			expString:  "771,49199,65281-16-11",
+			expHash:    "2de81c22ea32a57162df5cb08d4a2795",
+			expVersion: tls12,
+			expCipher:  0xc02f,
+			expALPN:    "h2",
+		},
+		{ // TLS 1.3 Server Hello
+			segment:    buildServerHello(random, 0x1301, []testExtension{supportedVersions, keyShare}, nil),
+			expString:  "771,4865,43-51",
+			expHash:    "f4febc55ea12b31ae17cfb7e614afda8",
+			expVersion: tls13,
+			expCipher:  0x1301,
+		},
+		{ // HelloRetryRequest
+			segment:    buildServerHello(helloRetryRequestRandom, 0x1301, []testExtension{supportedVersions, keyShare}, nil),
+			expString:  "771,4865,43-51",
+			expHash:    "f4febc55ea12b31ae17cfb7e614afda8",
+			expVersion: tls13,
+			expCipher:  0x1301,
+			expHRR:     true,
+		},
+		{ // Server Hello without extensions
+			segment:    buildServerHello(random, 0x002f, nil, nil),
+			expString:  "771,47,",
+			expHash:    "5397c414a9ebeaff1bf18b70ca22eaa0",
+			expVersion: tls12,
+			expCipher:  0x002f,
+		},
+		{ // Certificate instead of a Server Hello
+			segment: append([]byte{contentType, 3, 3, 0, 4}, certificate...),
+			expErr:  ErrNotServerHello,
+		},
+		{ // Application data
+			segment: []byte{23, 3, 3, 0, 1, 0},
+			expErr:  ErrNotHandshake,
+		},
+		{ // Server Hello continued in the next segment
+			segment: buildServerHello(random, 0xc02f, []testExtension{alpn}, nil)[:50],
+			expErr:  ErrTruncated,
+		},
+		{ // Malformed ALPN extension
+			segment: buildServerHello(random, 0xc02f, []testExtension{{alpnExtensionType, []byte{0, 3, 3, 'h', '2'}}}, nil),
+			expErr:  ErrMalformed,
+		},
+	}
+
+	// Run through all test cases
+	for _, test := range ja3sTestSet {
+		j, err := ComputeJA3SFromSegment(test.segment)
+		if !errors.Is(err, test.expErr) {
+			t.Errorf("Expected: %v but got: %v\n", test.expErr, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if j.GetJA3SString() != test.expString {
+			t.Errorf("Expected: %v but got: %v\n", test.expString, j.GetJA3SString())
+		}
+		if j.GetJA3SHash() != test.expHash {
+			t.Errorf("Expected: %v but got: %v\n", test.expHash, j.GetJA3SHash())
+		}
+		if j.GetVersion() != test.expVersion {
+			t.Errorf("Expected: %v but got: %v\n", test.expVersion, j.GetVersion())
+		}
+		if j.GetCipherSuite() != test.expCipher {
+			t.Errorf("Expected: %v but got: %v\n", test.expCipher, j.GetCipherSuite())
+		}
+		if j.GetALPN() != test.expALPN {
+			t.Errorf("Expected: %v but got: %v\n", test.expALPN, j.GetALPN())
+		}
+		if j.IsHelloRetryRequest() != test.expHRR {
+			t.Errorf("Expected: %v but got: %v\n", test.expHRR, j.IsHelloRetryRequest())
+		}
+	}
+}
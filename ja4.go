@@ -0,0 +1,185 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Constants used for JA4
+	signatureAlgorithmsExtensionType uint16 = 13
+
+	// ja4HashLen is the number of hexadecimal characters of the truncated SHA256 hashes of JA4
+	ja4HashLen int = 12
+	// ja4MaxCount is the maximum number of cipher suites and extensions counted in the first part of JA4
+	ja4MaxCount int = 99
+)
+
+// ja4Versions maps the TLS versions to their representation in JA4
+var ja4Versions = map[uint16]string{
+	0x0304: "13",
+	0x0303: "12",
+	0x0302: "11",
+	0x0301: "10",
+	0x0300: "s3",
+	0x0002: "s2",
+}
+
+// GetJA4 returns the JA4 fingerprint (ja4_a_b_c) of the Client Hello, assuming it was sent over TCP. This function uses
+// caching, so repeated calls to this function on the same JA3 object will not trigger any new calculations. As the
+// ALPN and signature algorithms extensions are read from the parsed segment, the segment must not be modified before
+// calling this function. JA3 objects with extensions which were not parsed from a segment, e.g. by
+// ComputeJA3FromClientHelloInfo, have no JA4 fingerprint and return an empty string.
+func (j *JA3) GetJA4() string {
+	if j.ja4 != "" || (j.rawExtensions == nil && len(j.extensions) > 0) {
+		return j.ja4
+	}
+
+	// Walk the extensions once more for the values not kept in the JA3 object
+	version := j.version
+	var alpn, signatureAlgorithms []byte
+	exs := j.rawExtensions
+	for len(exs) >= extensionHeaderLen {
+		exType := uint16(exs[0])<<8 | uint16(exs[1])
+		exLen := int(uint16(exs[2])<<8 | uint16(exs[3]))
+		if len(exs) < extensionHeaderLen+exLen {
+			break
+		}
+		sex := exs[extensionHeaderLen : extensionHeaderLen+exLen]
+
+		switch exType {
+		case supportedVersionsExtensionType:
+			// The highest version offered counts
+			if len(sex) >= 1 {
+				versions := sex[1:]
+				if int(sex[0]) < len(versions) {
+					versions = versions[:sex[0]]
+				}
+				highest := uint16(0)
+				for i := 0; i+1 < len(versions); i += 2 {
+					v := uint16(versions[i])<<8 | uint16(versions[i+1])
+					if v&greaseBitmask != 0x0A0A && v > highest {
+						highest = v
+					}
+				}
+				if highest != 0 {
+					version = highest
+				}
+			}
+		case alpnExtensionType:
+			// Only the first protocol counts
+			if len(sex) >= 3 && len(sex) >= 3+int(sex[2]) {
+				alpn = sex[3 : 3+int(sex[2])]
+			}
+		case signatureAlgorithmsExtensionType:
+			if len(sex) >= 2 {
+				signatureAlgorithms = sex[2:]
+			}
+		}
+		exs = exs[extensionHeaderLen+exLen:]
+	}
+
+	// JA4_a: protocol, version, SNI, number of cipher suites and extensions and the first ALPN protocol
+	var a strings.Builder
+	a.WriteByte('t')
+	if v, ok := ja4Versions[version]; ok {
+		a.WriteString(v)
+	} else {
+		a.WriteString("00")
+	}
+	sni := byte('i')
+	for _, ex := range j.extensions {
+		if ex == sniExtensionType {
+			sni = 'd'
+		}
+	}
+	a.WriteByte(sni)
+	a.WriteString(ja4Count(len(j.cipherSuites)))
+	a.WriteString(ja4Count(len(j.extensions)))
+	a.WriteString(ja4ALPN(alpn))
+
+	// JA4_b: the sorted cipher suites
+	ciphers := make([]uint16, len(j.cipherSuites))
+	copy(ciphers, j.cipherSuites)
+	sort.Slice(ciphers, func(x, y int) bool { return ciphers[x] < ciphers[y] })
+
+	// JA4_c: the sorted extensions without SNI and ALPN, followed by the signature algorithms in their original order
+	extensions := make([]uint16, 0, len(j.extensions))
+	for _, ex := range j.extensions {
+		if ex != sniExtensionType && ex != alpnExtensionType {
+			extensions = append(extensions, ex)
+		}
+	}
+	sort.Slice(extensions, func(x, y int) bool { return extensions[x] < extensions[y] })
+	c := ja4HexList(extensions)
+	var algorithms []uint16
+	for i := 0; i+1 < len(signatureAlgorithms); i += 2 {
+		alg := uint16(signatureAlgorithms[i])<<8 | uint16(signatureAlgorithms[i+1])
+		if alg&greaseBitmask != 0x0A0A {
+			algorithms = append(algorithms, alg)
+		}
+	}
+	if len(algorithms) > 0 {
+		c += "_" + ja4HexList(algorithms)
+	}
+
+	j.ja4 = a.String() + "_" + ja4Hash(ja4HexList(ciphers)) + "_" + ja4Hash(c)
+	return j.ja4
+}
+
+// ja4Count formats the number of cipher suites or extensions with two digits
+func ja4Count(n int) string {
+	if n > ja4MaxCount {
+		n = ja4MaxCount
+	}
+	if n < 10 {
+		return "0" + strconv.Itoa(n)
+	}
+	return strconv.Itoa(n)
+}
+
+// ja4ALPN returns the first and last character of the ALPN protocol, or of its hexadecimal representation if either
+// of them is not alphanumeric, or "00" if there is none
+func ja4ALPN(alpn []byte) string {
+	if len(alpn) == 0 {
+		return "00"
+	}
+	first, last := alpn[0], alpn[len(alpn)-1]
+	if !isAlphanumeric(first) || !isAlphanumeric(last) {
+		h := hex.EncodeToString(alpn)
+		first, last = h[0], h[len(h)-1]
+	}
+	return string([]byte{first, last})
+}
+
+// isAlphanumeric reports whether the byte is an ASCII letter or digit
+func isAlphanumeric(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'z') || (b >= 'A' && b <= 'Z')
+}
+
+// ja4HexList formats the values as comma separated list of four digit hexadecimal numbers
+func ja4HexList(values []uint16) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = hex.EncodeToString([]byte{byte(v >> 8), byte(v)})
+	}
+	return strings.Join(parts, ",")
+}
+
+// ja4Hash returns the truncated SHA256 hash of the list or zeros if the list is empty
+func ja4Hash(list string) string {
+	if list == "" {
+		return strings.Repeat("0", ja4HashLen)
+	}
+	h := sha256.Sum256([]byte(list))
+	return hex.EncodeToString(h[:])[:ja4HashLen]
+}
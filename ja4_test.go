@@ -0,0 +1,105 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"crypto/tls"
+	"testing"
+)
+
+// buildClientHelloWithCiphers assembles a TLS record containing a Client Hello with the cipher suites and extensions
+func buildClientHelloWithCiphers(ciphers []uint16, extensions ...testExtension) []byte {
+	body := []byte{3, 3}
+	for i := 0; i < 32; i++ {
+		body = append(body, 42)
+	}
+	body = append(body, 0, byte(len(ciphers)>>7), byte(len(ciphers)<<1))
+	for _, c := range ciphers {
+		body = append(body, byte(c>>8), byte(c))
+	}
+	body = append(body, 1, 0)
+	var exs []byte
+	for _, ex := range extensions {
+		exs = append(exs, byte(ex.exType>>8), byte(ex.exType), byte(len(ex.data)>>8), byte(len(ex.data)))
+		exs = append(exs, ex.data...)
+	}
+	body = append(body, byte(len(exs)>>8), byte(len(exs)))
+	body = append(body, exs...)
+	hs := append([]byte{1, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{22, 3, 1, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+type ja4TestContainer struct {
+	testPayload []byte
+	expJA4      string
+}
+
+func TestGetJA4(t *testing.T) {
+	/*
+		Build container with testing data
+
+		The Chrome Client Hello has to result in the JA4 fingerprint of the example in the JA4 specification, GREASE
+		values have to be ignored.
+	*/
+	chrome := buildClientHelloWithCiphers(
+		[]uint16{0x0a0a, 0x1301, 0x1302, 0x1303, 0xc02b, 0xc02f, 0xc02c, 0xc030, 0xcca9, 0xcca8, 0xc013, 0xc014, 0x009c, 0x009d, 0x002f, 0x0035},
+		testExtension{0x1a1a, nil},
+		testExtension{0x0000, []byte{0, 14, 0, 0, 11, 'e', 'x', 'a', 'm', 'p', 'l', 'e', '.', 'c', 'o', 'm'}},
+		testExtension{0x0017, nil},
+		testExtension{0xff01, []byte{0}},
+		testExtension{0x000a, []byte{0, 6, 0x2a, 0x2a, 0, 29, 0, 23}},
+		testExtension{0x000b, []byte{1, 0}},
+		testExtension{0x0023, nil},
+		testExtension{0x0010, []byte{0, 12, 2, 'h', '2', 8, 'h', 't', 't', 'p', '/', '1', '.', '1'}},
+		testExtension{0x0005, []byte{1, 0, 0, 0, 0}},
+		testExtension{0x000d, []byte{0, 16, 4, 3, 8, 4, 4, 1, 5, 3, 8, 5, 5, 1, 8, 6, 6, 1}},
+		testExtension{0x0012, nil},
+		testExtension{0x0033, []byte{0, 0}},
+		testExtension{0x002d, []byte{1, 1}},
+		testExtension{0x002b, []byte{6, 0x3a, 0x3a, 3, 4, 3, 3}},
+		testExtension{0x001b, []byte{2, 0, 2}},
+		testExtension{0x4469, []byte{0, 3, 2, 'h', '2'}},
+		testExtension{0x0015, []byte{0, 0}},
+		testExtension{0x2a2a, []byte{0}},
+	)
+	var ja4TestSet = map[string]ja4TestContainer{
+		"Chrome": {
+			testPayload: chrome,
+			expJA4:      "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+		"TLS 1.2 without ALPN": {
+			testPayload: googleClientHello,
+			expJA4:      "t12d180800_2be01e619085_0dcb6e264b7f",
+		},
+		"No extensions": {
+			testPayload: []byte{22, 3, 0, 0, 44, 1, 0, 0, 40, 3, 0, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 42, 0, 0, 2, 21, 21, 0},
+			expJA4:      "ts3i010000_f1ee529ef491_000000000000",
+		},
+		"Non alphanumeric ALPN": {
+			testPayload: buildClientHelloWithCiphers([]uint16{0x1301}, testExtension{0x0010, []byte{0, 4, 3, 'h', '3', '-'}}),
+			expJA4:      "t12i01016d_0f2cb44170f4_000000000000",
+		},
+	}
+
+	// Run through all test cases
+	for name, test := range ja4TestSet {
+		ja3, err := ComputeJA3FromSegment(test.testPayload)
+		if err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+			continue
+		}
+		if ja4 := ja3.GetJA4(); ja4 != test.expJA4 {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, test.expJA4, ja4)
+		}
+	}
+
+	// Without the raw extensions there is no JA4 fingerprint
+	info := ComputeJA3FromClientHelloInfo(&tls.ClientHelloInfo{CipherSuites: []uint16{0x1301}, Extensions: []uint16{0, 16}})
+	if ja4 := info.GetJA4(); ja4 != "" {
+		t.Errorf("Expected: %q but got: %q\n", "", ja4)
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+// LintCode identifies a protocol violation or oddity found in a Client Hello.
+type LintCode string
+
+// Lint codes
+const (
+	LintDuplicateExtension         LintCode = "duplicate_extension"
+	LintPreSharedKeyNotLast        LintCode = "pre_shared_key_not_last"
+	LintSupportedVersionsMismatch  LintCode = "supported_versions_mismatch"
+	LintKeyShareGroupNotSupported  LintCode = "key_share_group_not_supported"
+	LintNonNullCompression         LintCode = "non_null_compression"
+	LintNullCompressionMissing     LintCode = "null_compression_missing"
+	LintSessionIDTooLong           LintCode = "session_id_too_long"
+	LintPaddingNotZero             LintCode = "padding_not_zero"
+	LintPaddingNotLast             LintCode = "padding_not_last"
+	LintMalformedSupportedVersions LintCode = "malformed_supported_versions"
+	LintMalformedKeyShare          LintCode = "malformed_key_share"
+)
+
+const (
+	// Constants used for linting
+	maxSessionIDLen int = 32
+
+	paddingExtensionType           uint16 = 21
+	preSharedKeyExtensionType      uint16 = 41
+	supportedVersionsExtensionType uint16 = 43
+	keyShareExtensionType          uint16 = 51
+
+	tls12 uint16 = 0x0303
+)
+
+// Lint inspects the parsed Client Hello for violations of the TLS RFCs and other oddities, which are typical for hand
+// rolled TLS stacks. It returns the found lint codes in the order they were encountered or nil if the Client Hello
+// looks sane. As the extensions are read from the parsed segment, the segment must not be modified before calling this
+// function. JA3 objects which were not parsed from a segment, e.g. by ComputeJA3FromClientHelloInfo, can not be linted
+// and return nil.
+func (j *JA3) Lint() []LintCode {
+	// Parsed Client Hellos always have compression methods, which are a slice of the segment, even if empty
+	if j.compressionMethods == nil {
+		return nil
+	}
+
+	var codes []LintCode
+	add := func(code LintCode) {
+		for _, c := range codes {
+			if c == code {
+				return
+			}
+		}
+		codes = append(codes, code)
+	}
+
+	// Session ID
+	if int(j.sessionIDLen) > maxSessionIDLen {
+		add(LintSessionIDTooLong)
+	}
+
+	// Compression methods
+	hasNullCompression := false
+	for _, cm := range j.compressionMethods {
+		if cm == 0 {
+			hasNullCompression = true
+		} else {
+			add(LintNonNullCompression)
+		}
+	}
+	if !hasNullCompression {
+		add(LintNullCompressionMissing)
+	}
+
+	// Walk the extensions once more to check their order and the extensions not kept in the JA3 object
+	var supportedVersions, keyShare []byte
+	var hasSupportedVersions, hasKeyShare bool
+	var seen []uint16
+	var previous uint16
+	first := true
+	exs := j.rawExtensions
+	for len(exs) >= extensionHeaderLen {
+		exType := uint16(exs[0])<<8 | uint16(exs[1])
+		exLen := int(uint16(exs[2])<<8 | uint16(exs[3]))
+		if len(exs) < extensionHeaderLen+exLen {
+			break
+		}
+		sex := exs[extensionHeaderLen : extensionHeaderLen+exLen]
+
+		for _, s := range seen {
+			if s == exType {
+				add(LintDuplicateExtension)
+			}
+		}
+		seen = append(seen, exType)
+
+		if !first {
+			switch previous {
+			case preSharedKeyExtensionType:
+				add(LintPreSharedKeyNotLast)
+			case paddingExtensionType:
+				// Only the pre_shared_key extension has to follow the padding
+				if exType != preSharedKeyExtensionType {
+					add(LintPaddingNotLast)
+				}
+			}
+		}
+		previous, first = exType, false
+
+		switch exType {
+		case supportedVersionsExtensionType:
+			supportedVersions, hasSupportedVersions = sex, true
+		case keyShareExtensionType:
+			keyShare, hasKeyShare = sex, true
+		case paddingExtensionType:
+			for _, b := range sex {
+				if b != 0 {
+					add(LintPaddingNotZero)
+					break
+				}
+			}
+		}
+		exs = exs[extensionHeaderLen+exLen:]
+	}
+
+	// Supported versions
+	if hasSupportedVersions {
+		// The legacy version has to be frozen at TLS 1.2 if the supported_versions extension is used
+		if j.version != tls12 {
+			add(LintSupportedVersionsMismatch)
+		}
+		if len(supportedVersions) < 1 || int(supportedVersions[0]) != len(supportedVersions)-1 || supportedVersions[0]%2 != 0 {
+			add(LintMalformedSupportedVersions)
+		}
+	} else if j.version == tls13 {
+		// TLS 1.3 can only be negotiated with the supported_versions extension
+		add(LintSupportedVersionsMismatch)
+	}
+
+	// Key shares
+	if hasKeyShare {
+		if len(keyShare) < 2 || int(uint16(keyShare[0])<<8|uint16(keyShare[1])) != len(keyShare)-2 {
+			add(LintMalformedKeyShare)
+		} else {
+			shares := keyShare[2:]
+			for len(shares) > 0 {
+				if len(shares) < 4 {
+					add(LintMalformedKeyShare)
+					break
+				}
+				group := uint16(shares[0])<<8 | uint16(shares[1])
+				keyLen := int(uint16(shares[2])<<8 | uint16(shares[3]))
+				if len(shares) < 4+keyLen {
+					add(LintMalformedKeyShare)
+					break
+				}
+				if group&greaseBitmask != 0x0A0A && !containsUint16(j.ellipticCurves, group) {
+					add(LintKeyShareGroupNotSupported)
+				}
+				shares = shares[4+keyLen:]
+			}
+		}
+	}
+
+	return codes
+}
+
+// containsUint16 reports whether v is contained in s
+func containsUint16(s []uint16, v uint16) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
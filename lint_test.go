@@ -0,0 +1,160 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"crypto/tls"
+	"reflect"
+	"testing"
+)
+
+type testExtension struct {
+	exType uint16
+	data   []byte
+}
+
+type lintTestContainer struct {
+	version            uint16
+	sessionIDLen       int
+	compressionMethods []byte
+	extensions         []testExtension
+	expLintCodes       []LintCode
+}
+
+// buildClientHello assembles a TLS record containing a Client Hello with a single cipher suite
+func buildClientHello(version uint16, sessionIDLen int, compressionMethods []byte, extensions []testExtension) []byte {
+	var exs []byte
+	for _, ex := range extensions {
+		exs = append(exs, byte(ex.exType>>8), byte(ex.exType), byte(len(ex.data)>>8), byte(len(ex.data)))
+		exs = append(exs, ex.data...)
+	}
+
+	body := []byte{byte(version >> 8), byte(version)}
+	body = append(body, make([]byte, randomDataLen)...)
+	body = append(body, byte(sessionIDLen))
+	body = append(body, make([]byte, sessionIDLen)...)
+	body = append(body, 0, 2, 0x13, 0x01)
+	body = append(body, byte(len(compressionMethods)))
+	body = append(body, compressionMethods...)
+	body = append(body, byte(len(exs)>>8), byte(len(exs)))
+	body = append(body, exs...)
+
+	hs := append([]byte{handshakeType, 0, byte(len(body) >> 8), byte(len(body))}, body...)
+	return append([]byte{contentType, 3, 1, byte(len(hs) >> 8), byte(len(hs))}, hs...)
+}
+
+func TestLint(t *testing.T) {
+	/*
+		Build container with testing data
+
+		Check that every lint code is found in an otherwise sane Client Hello and that sane Client Hellos pass.
+	*/
+	supportedGroups := testExtension{ecExtensionType, []byte{0, 4, 0, 29, 0, 23}}
+	supportedVersions := testExtension{supportedVersionsExtensionType, []byte{4, 3, 4, 3, 3}}
+	keyShare := testExtension{keyShareExtensionType, []byte{0, 6, 0, 29, 0, 2, 42, 42}}
+	psk := testExtension{preSharedKeyExtensionType, []byte{0, 0}}
+	padding := testExtension{paddingExtensionType, []byte{0, 0, 0}}
+
+	var lintTestSet = []lintTestContainer{
+		{ // Sane TLS 1.3 Client Hello
+			version:            tls12,
+			sessionIDLen:       32,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{supportedGroups, supportedVersions, keyShare, padding, psk},
+		},
+		{ // Sane Client Hello without extensions
+			version:            tls12,
+			compressionMethods: []byte{0},
+		},
+		{ // Duplicate extensions
+			version:            tls12,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{supportedGroups, supportedGroups},
+			expLintCodes:       []LintCode{LintDuplicateExtension},
+		},
+		{ // pre_shared_key not last
+			version:            tls12,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{psk, supportedGroups},
+			expLintCodes:       []LintCode{LintPreSharedKeyNotLast},
+		},
+		{ // supported_versions with a legacy version other than TLS 1.2
+			version:            tls13,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{supportedVersions},
+			expLintCodes:       []LintCode{LintSupportedVersionsMismatch},
+		},
+		{ // TLS 1.3 legacy version without supported_versions
+			version:            tls13,
+			compressionMethods: []byte{0},
+			expLintCodes:       []LintCode{LintSupportedVersionsMismatch},
+		},
+		{ // Malformed supported_versions
+			version:            tls12,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{{supportedVersionsExtensionType, []byte{3, 3, 4}}},
+			expLintCodes:       []LintCode{LintMalformedSupportedVersions},
+		},
+		{ // key_share group not in supported_groups
+			version:            tls12,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{{ecExtensionType, []byte{0, 2, 0, 23}}, keyShare},
+			expLintCodes:       []LintCode{LintKeyShareGroupNotSupported},
+		},
+		{ // Malformed key_share
+			version:            tls12,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{supportedGroups, {keyShareExtensionType, []byte{0, 6, 0, 29, 0, 4, 42, 42}}},
+			expLintCodes:       []LintCode{LintMalformedKeyShare},
+		},
+		{ // Non-null compression methods
+			version:            tls12,
+			compressionMethods: []byte{1, 0},
+			expLintCodes:       []LintCode{LintNonNullCompression},
+		},
+		{ // Missing null compression method
+			version:            tls12,
+			compressionMethods: []byte{},
+			expLintCodes:       []LintCode{LintNullCompressionMissing},
+		},
+		{ // Session ID too long
+			version:            tls12,
+			sessionIDLen:       33,
+			compressionMethods: []byte{0},
+			expLintCodes:       []LintCode{LintSessionIDTooLong},
+		},
+		{ // Padding with non-zero bytes and not as last extension
+			version:            tls12,
+			compressionMethods: []byte{0},
+			extensions:         []testExtension{{paddingExtensionType, []byte{0, 42}}, supportedGroups},
+			expLintCodes:       []LintCode{LintPaddingNotZero, LintPaddingNotLast},
+		},
+	}
+
+	// Run through all test cases
+	for _, test := range lintTestSet {
+		segment := buildClientHello(test.version, test.sessionIDLen, test.compressionMethods, test.extensions)
+		ja3, err := ComputeJA3FromSegment(segment)
+		if err != nil {
+			t.Errorf("Expected: %v but got: %v\n", nil, err)
+			continue
+		}
+		lintCodes := ja3.Lint()
+		if !reflect.DeepEqual(lintCodes, test.expLintCodes) {
+			t.Errorf("Expected: %v but got: %v\n", test.expLintCodes, lintCodes)
+		}
+	}
+
+	// Without the raw Client Hello there is nothing to lint
+	info := ComputeJA3FromClientHelloInfo(&tls.ClientHelloInfo{
+		CipherSuites: []uint16{0x1301},
+		Extensions:   []uint16{0, supportedVersionsExtensionType},
+	})
+	if lintCodes := info.Lint(); lintCodes != nil {
+		t.Errorf("Expected: %v but got: %v\n", nil, lintCodes)
+	}
+}
@@ -48,83 +48,113 @@ func (j *JA3) parseSegment(segment []byte) error {
 
 	// Check if we can decode the next fields
 	if len(segment) < recordLayerHeaderLen {
-		return &ParseError{LengthErr, 1}
+		return newParseError(ErrTruncated, LengthErr, 1, 0, "record")
 	}
 
 	// Check if we have "Content Type: Handshake (22)"
 	contType := uint8(segment[0])
 	if contType != contentType {
-		return &ParseError{errType: ContentTypeErr}
+		return newParseError(ErrNotHandshake, ContentTypeErr, 0, 0, "record.content_type")
 	}
 
 	// Check if TLS record layer version is supported
 	tlsRecordVersion := uint16(segment[1])<<8 | uint16(segment[2])
 	if tlsRecordVersion&tlsVersionBitmask != 0x0300 && tlsRecordVersion != tls13 {
-		return &ParseError{VersionErr, 1}
+		return newParseError(ErrUnsupportedVersion, VersionErr, 1, 1, "record.version")
 	}
 
-    // Check that the Handshake is as long as expected from the length field
-	segmentLen := uint16(segment[3])<<8 | uint16(segment[4])
-    if len(segment[recordLayerHeaderLen:]) < int(segmentLen) {
-        return &ParseError{LengthErr, 2}
-    }
-    // Keep the Handshake messege, ignore any additional following record types
-    hs := segment[recordLayerHeaderLen:recordLayerHeaderLen+int(segmentLen)]
+	// Check that the Handshake is as long as expected from the length field
+	segmentLen := int(uint16(segment[3])<<8 | uint16(segment[4]))
+	if len(segment[recordLayerHeaderLen:]) < segmentLen {
+		if err := j.tolerate(newParseError(ErrTruncated, LengthErr, 2, 3, "record.length")); err != nil {
+			return err
+		}
+		// Continue with the truncated record
+		segmentLen = len(segment[recordLayerHeaderLen:])
+	}
+	// Keep the Handshake messege, ignore any additional following record types
+	hs := segment[recordLayerHeaderLen : recordLayerHeaderLen+segmentLen]
 
 	err := j.parseHandshake(hs)
+	if err != nil {
+		// Drop the partially built JA3 string, the getters marshal it from the parsed fields instead
+		j.ja3ByteString = j.ja3ByteString[:0]
+	}
 
 	return err
 }
 
-// parseHandshake body
+// parseHandshake body, which starts right after the record layer header in the segment
 func (j *JA3) parseHandshake(hs []byte) error {
 
 	// Check if we can decode the next fields
 	if len(hs) < handshakeHeaderLen+randomDataLen+sessionIDHeaderLen {
-		return &ParseError{LengthErr, 3}
+		return newParseError(ErrTruncated, LengthErr, 3, recordLayerHeaderLen, "handshake")
 	}
 
 	// Check if we have "Handshake Type: Client Hello (1)"
 	handshType := uint8(hs[0])
 	if handshType != handshakeType {
-		return &ParseError{errType: HandshakeTypeErr}
+		return newParseError(ErrNotClientHello, HandshakeTypeErr, 0, recordLayerHeaderLen, "handshake.msg_type")
 	}
 
 	// Check if actual length of handshake matches (this is a great exclusion criterion for false positives,
 	// as these fields have to match the actual length of the rest of the segment)
 	handshakeLen := uint32(hs[1])<<16 | uint32(hs[2])<<8 | uint32(hs[3])
 	if len(hs[4:]) != int(handshakeLen) {
-		return &ParseError{LengthErr, 4}
+		// A handshake spanning multiple segments is truncated, everything else is malformed
+		kind := ErrMalformed
+		if len(hs[4:]) < int(handshakeLen) {
+			kind = ErrTruncated
+		}
+		if err := j.tolerate(newParseError(kind, LengthErr, 4, recordLayerHeaderLen+1, "handshake.length")); err != nil {
+			return err
+		}
+		// Cut off any trailing data, a truncated handshake is parsed as far as possible
+		if len(hs[4:]) > int(handshakeLen) {
+			hs = hs[:4+int(handshakeLen)]
+		}
 	}
 
 	// Check if Client Hello version is supported
 	tlsVersion := uint16(hs[4])<<8 | uint16(hs[5])
 	if tlsVersion&tlsVersionBitmask != 0x0300 && tlsVersion != tls13 {
-		return &ParseError{VersionErr, 2}
+		return newParseError(ErrUnsupportedVersion, VersionErr, 2, recordLayerHeaderLen+4, "client_hello.version")
 	}
 	j.version = tlsVersion
+	j.ja3ByteString = j.ja3ByteString[:0]
+	j.hashed = 0
+	j.appendJA3Value(tlsVersion)
+	j.endJA3Field(false)
 
 	// Check if we can decode the next fields
 	sessionIDLen := uint8(hs[38])
 	if len(hs) < handshakeHeaderLen+randomDataLen+sessionIDHeaderLen+int(sessionIDLen) {
-		return &ParseError{LengthErr, 5}
+		return newParseError(ErrMalformed, LengthErr, 5, recordLayerHeaderLen+38, "client_hello.session_id")
 	}
+	j.sessionIDLen = sessionIDLen
 
 	// Cipher Suites
-	cs := hs[handshakeHeaderLen+randomDataLen+sessionIDHeaderLen+int(sessionIDLen):]
+	csOffset := handshakeHeaderLen + randomDataLen + sessionIDHeaderLen + int(sessionIDLen)
+	cs := hs[csOffset:]
+	csOffset += recordLayerHeaderLen
 
 	// Check if we can decode the next fields
 	if len(cs) < cipherSuiteHeaderLen {
-		return &ParseError{LengthErr, 6}
+		return newParseError(ErrMalformed, LengthErr, 6, csOffset, "client_hello.cipher_suites")
 	}
 
 	csLen := uint16(cs[0])<<8 | uint16(cs[1])
 	numCiphers := int(csLen / 2)
-	cipherSuites := make([]uint16, 0, numCiphers)
+	// Reuse the buffer of the JA3 object if possible
+	cipherSuites := j.cipherSuites[:0]
+	if cap(cipherSuites) < numCiphers {
+		cipherSuites = make([]uint16, 0, numCiphers)
+	}
 
 	// Check if we can decode the next fields
 	if len(cs) < cipherSuiteHeaderLen+int(csLen)+compressMethodHeaderLen {
-		return &ParseError{LengthErr, 7}
+		return newParseError(ErrMalformed, LengthErr, 7, csOffset, "client_hello.cipher_suites")
 	}
 
 	for i := 0; i < numCiphers; i++ {
@@ -132,26 +162,45 @@ func (j *JA3) parseHandshake(hs []byte) error {
 		// Ignore any GREASE cipher suites
 		if cipherSuite&greaseBitmask != 0x0A0A {
 			cipherSuites = append(cipherSuites, cipherSuite)
+			j.appendJA3Value(cipherSuite)
 		}
 	}
 	j.cipherSuites = cipherSuites
+	j.endJA3Field(false)
 
 	// Check if we can decode the next fields
 	compressMethodLen := uint16(cs[cipherSuiteHeaderLen+int(csLen)])
 	if len(cs) < cipherSuiteHeaderLen+int(csLen)+compressMethodHeaderLen+int(compressMethodLen) {
-		return &ParseError{LengthErr, 8}
+		return newParseError(ErrMalformed, LengthErr, 8, csOffset+cipherSuiteHeaderLen+int(csLen), "client_hello.compression_methods")
 	}
 
+	j.compressionMethods = cs[cipherSuiteHeaderLen+int(csLen)+compressMethodHeaderLen : cipherSuiteHeaderLen+int(csLen)+compressMethodHeaderLen+int(compressMethodLen)]
+
 	// Extensions
-	exs := cs[cipherSuiteHeaderLen+int(csLen)+compressMethodHeaderLen+int(compressMethodLen):]
+	exsOffset := cipherSuiteHeaderLen + int(csLen) + compressMethodHeaderLen + int(compressMethodLen)
+	exs := cs[exsOffset:]
 
-	err := j.parseExtensions(exs)
+	if err := j.parseExtensions(exs, csOffset+exsOffset); err != nil {
+		return err
+	}
 
-	return err
+	// The elliptic curves and point formats follow all extensions in the JA3 string and only the last extension of
+	// each type counts, so they are appended once all extensions were parsed
+	j.endJA3Field(false)
+	for _, val := range j.ellipticCurves {
+		j.appendJA3Value(val)
+	}
+	j.endJA3Field(false)
+	for _, val := range j.ellipticCurvePF {
+		j.appendJA3Value(uint16(val))
+	}
+	j.endJA3Field(true)
+
+	return nil
 }
 
-// parseExtensions of the handshake
-func (j *JA3) parseExtensions(exs []byte) error {
+// parseExtensions of the handshake, which start at offset in the segment
+func (j *JA3) parseExtensions(exs []byte, offset int) error {
 
 	// Check for no extensions, this fields header is nonexistent if no body is used
 	if len(exs) == 0 {
@@ -160,25 +209,35 @@ func (j *JA3) parseExtensions(exs []byte) error {
 
 	// Check if we can decode the next fields
 	if len(exs) < extensionsHeaderLen {
-		return &ParseError{LengthErr, 9}
+		return newParseError(ErrMalformed, LengthErr, 9, offset, "client_hello.extensions")
 	}
 
 	exsLen := uint16(exs[0])<<8 | uint16(exs[1])
 	exs = exs[extensionsHeaderLen:]
+	j.rawExtensions = exs
+	exsOffset := offset
+	offset += extensionsHeaderLen
 
 	// Check if we can decode the next fields
 	if len(exs) < int(exsLen) {
-		return &ParseError{LengthErr, 10}
+		if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 10, exsOffset, "client_hello.extensions")); err != nil {
+			return err
+		}
 	}
 
+	// Reuse the buffers of the JA3 object if possible
 	var sni []byte
-	var extensions, ellipticCurves []uint16
-	var ellipticCurvePF []uint8
-	for len(exs) > 0 {
+	extensions := j.extensions[:0]
+	ellipticCurves := j.ellipticCurves[:0]
+	ellipticCurvePF := j.ellipticCurvePF[:0]
+	for i := 0; len(exs) > 0; i++ {
 
 		// Check if we can decode the next fields
 		if len(exs) < extensionHeaderLen {
-			return &ParseError{LengthErr, 11}
+			if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 11, offset, extensionField(i, ""))); err != nil {
+				return err
+			}
+			break
 		}
 
 		exType := uint16(exs[0])<<8 | uint16(exs[1])
@@ -186,11 +245,15 @@ func (j *JA3) parseExtensions(exs []byte) error {
 		// Ignore any GREASE extensions
 		if exType&greaseBitmask != 0x0A0A {
 			extensions = append(extensions, exType)
+			j.appendJA3Value(exType)
 		}
 
 		// Check if we can decode the next fields
 		if len(exs) < extensionHeaderLen+int(exLen) {
-			return &ParseError{LengthErr, 12}
+			if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 12, offset+2, extensionField(i, ""))); err != nil {
+				return err
+			}
+			break
 		}
 
 		sex := exs[extensionHeaderLen : extensionHeaderLen+int(exLen)]
@@ -200,7 +263,10 @@ func (j *JA3) parseExtensions(exs []byte) error {
 
 			// Check if we can decode the next fields
 			if len(sex) < sniExtensionHeaderLen {
-				return &ParseError{LengthErr, 13}
+				if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 13, offset+extensionHeaderLen, extensionField(i, "server_name"))); err != nil {
+					return err
+				}
+				break
 			}
 
 			sniType := uint8(sex[2])
@@ -209,30 +275,49 @@ func (j *JA3) parseExtensions(exs []byte) error {
 
 			// Check if we can decode the next fields
 			if len(sex) != int(sniLen) {
-				return &ParseError{LengthErr, 14}
+				if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 14, offset+extensionHeaderLen+3, extensionField(i, "server_name"))); err != nil {
+					return err
+				}
+				if len(sex) > int(sniLen) {
+					sex = sex[:sniLen]
+				}
 			}
 
 			switch sniType {
 			case sniNameDNSHostnameType:
 				sni = sex
 			default:
-				return &ParseError{errType: SNITypeErr}
+				if err := j.tolerate(newParseError(ErrUnsupportedSNIType, SNITypeErr, 0, offset+extensionHeaderLen+2, extensionField(i, "server_name"))); err != nil {
+					return err
+				}
 			}
 		case ecExtensionType: // Extensions: supported_groups
 
 			// Check if we can decode the next fields
 			if len(sex) < ecExtensionHeaderLen {
-				return &ParseError{LengthErr, 15}
+				if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 15, offset+extensionHeaderLen, extensionField(i, "supported_groups"))); err != nil {
+					return err
+				}
+				break
 			}
 
 			ecsLen := uint16(sex[0])<<8 | uint16(sex[1])
-			numCurves := int(ecsLen / 2)
-			ellipticCurves = make([]uint16, 0, numCurves)
 			sex = sex[ecExtensionHeaderLen:]
 
 			// Check if we can decode the next fields
 			if len(sex) != int(ecsLen) {
-				return &ParseError{LengthErr, 16}
+				if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 16, offset+extensionHeaderLen, extensionField(i, "supported_groups"))); err != nil {
+					return err
+				}
+				if len(sex) < int(ecsLen) {
+					ecsLen = uint16(len(sex))
+				}
+			}
+
+			numCurves := int(ecsLen / 2)
+			ellipticCurves = ellipticCurves[:0]
+			if cap(ellipticCurves) < numCurves {
+				ellipticCurves = make([]uint16, 0, numCurves)
 			}
 
 			for i := 0; i < numCurves; i++ {
@@ -247,24 +332,37 @@ func (j *JA3) parseExtensions(exs []byte) error {
 
 			// Check if we can decode the next fields
 			if len(sex) < ecpfExtensionHeaderLen {
-				return &ParseError{LengthErr, 17}
+				if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 17, offset+extensionHeaderLen, extensionField(i, "ec_point_formats"))); err != nil {
+					return err
+				}
+				break
 			}
 
 			ecpfsLen := uint8(sex[0])
 			numPF := int(ecpfsLen)
-			ellipticCurvePF = make([]uint8, numPF)
 			sex = sex[ecpfExtensionHeaderLen:]
 
 			// Check if we can decode the next fields
 			if len(sex) != numPF {
-				return &ParseError{LengthErr, 18}
+				if err := j.tolerate(newParseError(ErrMalformed, LengthErr, 18, offset+extensionHeaderLen, extensionField(i, "ec_point_formats"))); err != nil {
+					return err
+				}
+				if len(sex) < numPF {
+					numPF = len(sex)
+				}
+			}
+
+			if cap(ellipticCurvePF) < numPF {
+				ellipticCurvePF = make([]uint8, numPF)
 			}
+			ellipticCurvePF = ellipticCurvePF[:numPF]
 
 			for i := 0; i < numPF; i++ {
 				ellipticCurvePF[i] = uint8(sex[i])
 			}
 		}
 		exs = exs[4+exLen:]
+		offset += 4 + int(exLen)
 	}
 	j.sni = sni
 	j.extensions = extensions
@@ -273,13 +371,62 @@ func (j *JA3) parseExtensions(exs []byte) error {
 	return nil
 }
 
+// extensionField returns the field path of the i-th extension, optionally followed by the name of the extension
+func extensionField(i int, name string) string {
+	field := "client_hello.extensions[" + strconv.Itoa(i) + "]"
+	if name != "" {
+		field += "." + name
+	}
+	return field
+}
+
+// tolerate records the anomaly and returns nil if the JA3 object is parsed in lenient mode, otherwise the anomaly is
+// returned as error and the parsing has to be aborted.
+func (j *JA3) tolerate(anomaly *ParseError) error {
+	if !j.lenient {
+		return anomaly
+	}
+	j.anomalies = append(j.anomalies, anomaly)
+	return nil
+}
+
+// appendJA3Value appends the value followed by a dash to the JA3 string if it is built while parsing, which is the case
+// if the JA3 object is parsed by a Parser
+func (j *JA3) appendJA3Value(val uint16) {
+	if j.ja3MD5 == nil {
+		return
+	}
+	j.ja3ByteString = append(strconv.AppendUint(j.ja3ByteString, uint64(val), 10), dashByte)
+}
+
+// endJA3Field ends the current field of the JA3 string built while parsing by replacing the dash after its last value
+// with a comma, or removing it if it is the last field, and feeds the completed field to the MD5 state
+func (j *JA3) endJA3Field(last bool) {
+	if j.ja3MD5 == nil {
+		return
+	}
+	byteString := j.ja3ByteString
+	if n := len(byteString); n > j.hashed && byteString[n-1] == dashByte {
+		byteString = byteString[:n-1]
+	}
+	if !last {
+		byteString = append(byteString, commaByte)
+	}
+	j.ja3MD5.Write(byteString[j.hashed:])
+	j.ja3ByteString = byteString
+	j.hashed = len(byteString)
+}
+
 // marshalJA3 into a byte string
 func (j *JA3) marshalJA3() {
 
 	// An uint16 can contain numbers with up to 5 digits and an uint8 can contain numbers with up to 3 digits, but we
 	// also need a byte for each separating character, except at the end.
 	byteStringLen := 6*(1+len(j.cipherSuites)+len(j.extensions)+len(j.ellipticCurves)) + 4*len(j.ellipticCurvePF) - 1
-	byteString := make([]byte, 0, byteStringLen)
+	byteString := j.ja3ByteString[:0]
+	if cap(byteString) < byteStringLen {
+		byteString = make([]byte, 0, byteStringLen)
+	}
 
 	// Version
 	byteString = strconv.AppendUint(byteString, uint64(j.version), 10)
@@ -0,0 +1,57 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+)
+
+// ComputeJA3FromConfig returns the JA3 object of the Client Hello which a crypto/tls client with the given config
+// sends, its GetJA4 method returns the JA4 fingerprint of the Client Hello. The handshake is driven offline over an
+// in-memory connection, so no network access is needed. This allows checking the fingerprint of outbound connections,
+// e.g. in CI to detect unexpected changes between Go versions.
+//
+// If the config neither sets ServerName nor InsecureSkipVerify, InsecureSkipVerify is set on a copy of the config as
+// crypto/tls would refuse to start the handshake otherwise. This does not change the Client Hello.
+func ComputeJA3FromConfig(config *tls.Config) (*JA3, error) {
+	if config == nil {
+		config = &tls.Config{}
+	}
+	config = config.Clone()
+	if config.ServerName == "" {
+		config.InsecureSkipVerify = true
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	clientErr := make(chan error, 1)
+	go func() {
+		defer clientConn.Close()
+		clientErr <- tls.Client(clientConn, config).Handshake()
+	}()
+
+	// Read the record layer header and the Client Hello following it
+	header := make([]byte, recordLayerHeaderLen)
+	if _, err := io.ReadFull(serverConn, header); err != nil {
+		// The client failed before sending the Client Hello, e.g. because of an invalid config
+		serverConn.Close()
+		if cErr := <-clientErr; cErr != nil {
+			return nil, cErr
+		}
+		return nil, err
+	}
+	record := make([]byte, recordLayerHeaderLen+int(uint16(header[3])<<8|uint16(header[4])))
+	copy(record, header)
+	if _, err := io.ReadFull(serverConn, record[recordLayerHeaderLen:]); err != nil {
+		return nil, err
+	}
+
+	return ComputeJA3FromSegment(record)
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2018, Open Systems AG. All rights reserved.
+//
+// Use of this source code is governed by a BSD-style license
+// that can be found in the LICENSE file in the root of the source
+// tree.
+
+package ja3
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+)
+
+func TestComputeJA3FromConfig(t *testing.T) {
+	/*
+		The fingerprint of a config has to match the one of a real client with the same config.
+	*/
+	var configs = map[string]*tls.Config{
+		"Default config": nil,
+		"TLS 1.3 with SNI": {
+			ServerName: "www.example.com",
+			NextProtos: []string{"h2", "http/1.1"},
+		},
+		"TLS 1.2 only": {
+			ServerName:       "www.example.com",
+			MaxVersion:       tls.VersionTLS12,
+			CipherSuites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+			CurvePreferences: []tls.CurveID{tls.CurveP256},
+		},
+	}
+
+	for name, config := range configs {
+		ja3, err := ComputeJA3FromConfig(config)
+		if err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+			continue
+		}
+
+		clientConfig := &tls.Config{InsecureSkipVerify: true}
+		if config != nil {
+			clientConfig = config
+		}
+		info, record := serveClientHello(t, func(c net.Conn) {
+			tls.Client(c, clientConfig).Handshake()
+		})
+		expJA3, err := ComputeJA3FromSegment(record)
+		if err != nil {
+			t.Errorf("%v: Expected: %v but got: %v\n", name, nil, err)
+			continue
+		}
+		if ja3.GetJA3Hash() != expJA3.GetJA3Hash() || ja3.GetJA4() != expJA3.GetJA4() || ja3.GetSNI() != info.ServerName {
+			t.Errorf("%v: Expected: %v, %v but got: %v, %v\n",
+				name,
+				expJA3.GetJA3String()+" "+expJA3.GetJA4(),
+				info.ServerName,
+				ja3.GetJA3String()+" "+ja3.GetJA4(),
+				ja3.GetSNI())
+		}
+	}
+}
+
+func TestComputeJA3FromConfigError(t *testing.T) {
+	/*
+		An invalid config has to return the error of the client instead of blocking.
+	*/
+	_, err := ComputeJA3FromConfig(&tls.Config{ServerName: "www.example.com", MinVersion: tls.VersionTLS13, MaxVersion: tls.VersionTLS12})
+	if err == nil {
+		t.Errorf("Expected an error but got: %v\n", err)
+	}
+}